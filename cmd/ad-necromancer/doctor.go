@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"ad-necromancer/internal/ai"
+	"ad-necromancer/internal/config"
+	"ad-necromancer/internal/transport"
+)
+
+// doctorTarget is a backend the doctor checks, with the role it plays
+type doctorTarget struct {
+	ref  config.BackendRef
+	role string // "primary", "fallback", "ensemble member" or "configured"
+}
+
+// doctor tallies check results
+type doctor struct {
+	failures int
+	warnings int
+}
+
+func (d *doctor) ok(format string, args ...interface{}) {
+	fmt.Printf("    "+ColorGreen+"✓"+ColorReset+" "+format+"\n", args...)
+}
+
+func (d *doctor) warn(format string, args ...interface{}) {
+	d.warnings++
+	fmt.Printf("    "+ColorYellow+"!"+ColorReset+" "+format+"\n", args...)
+}
+
+func (d *doctor) fail(format string, args ...interface{}) {
+	d.failures++
+	fmt.Printf("    "+ColorRed+"✗"+ColorReset+" "+format+"\n", args...)
+}
+
+func (d *doctor) info(format string, args ...interface{}) {
+	fmt.Printf("    "+ColorCyan+"•"+ColorReset+" "+format+"\n", args...)
+}
+
+// runDoctor implements "ad-necromancer doctor": it checks every backend a
+// run would use (and every other configured one) with a minimal request,
+// then the .necromancer directory, and exits non-zero on any failure
+func runDoctor(args []string) {
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	configPath := flags.String("config", "", "Config file (default ./necromancer.yaml, then ~/.config/ad-necromancer/config.yaml)")
+	profileName := flags.String("profile", "", "Named profile from the config file (or NECROMANCER_PROFILE)")
+	backendName := flags.String("backend", "", "Check only this backend (default: the run's backends plus every configured one)")
+	model := flags.String("model", "", "Model id for --backend")
+	privacy := flags.String("privacy", "", "Privacy Cloak policy to evaluate: auto, on or off (default: from the profile)")
+	timeout := flags.Duration("timeout", 30*time.Second, "Deadline for each backend check")
+	verbose := flags.Bool("verbose", false, "Log every HTTP request")
+	flags.Parse(args)
+
+	printBanner(os.Stdout)
+	fmt.Println(ColorCyan + "[*] Examining the ritual circle..." + ColorReset)
+
+	cfgFile, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Printf(ColorRed+"[!] %v\n"+ColorReset, err)
+		os.Exit(1)
+	}
+	if *profileName == "" {
+		*profileName = os.Getenv("NECROMANCER_PROFILE")
+	}
+	profile, err := cfgFile.Profile(*profileName)
+	if err == nil {
+		err = profile.ApplyEnv()
+	}
+	var settings config.Settings
+	if err == nil {
+		settings, err = config.Resolve(config.Settings{Backend: ai.DefaultBackend, Privacy: config.PrivacyAuto}, profile)
+	}
+	if err != nil {
+		fmt.Printf(ColorRed+"[!] %v\n"+ColorReset, err)
+		os.Exit(1)
+	}
+	if *privacy != "" {
+		settings.Privacy = *privacy
+	}
+	if err := settings.Validate(); err != nil {
+		fmt.Printf(ColorRed+"[!] %v\n"+ColorReset, err)
+		os.Exit(1)
+	}
+
+	if cfgFile.Path != "" {
+		fmt.Printf("[*] Config: %s", cfgFile.Path)
+		if name := firstNonEmpty(*profileName, cfgFile.DefaultProfile); name != "" {
+			fmt.Printf(" (profile %q)", name)
+		}
+		fmt.Println()
+	} else {
+		fmt.Println("[*] Config: none found, using environment and defaults")
+	}
+	fmt.Printf("[*] Privacy Cloak policy: %s\n", settings.Privacy)
+
+	summary, err := configureNetwork(settings.Network, *verbose)
+	if err != nil {
+		fmt.Printf(ColorRed+"[!] Network settings: %v\n"+ColorReset, err)
+		os.Exit(1)
+	}
+	printNetwork(os.Stdout, summary)
+
+	d := &doctor{}
+	targets, unused := doctorTargets(settings, profile, *backendName, *model)
+	for _, t := range targets {
+		d.checkBackend(t, settings.Privacy, *timeout)
+	}
+	if len(unused) > 0 {
+		fmt.Printf("\n%s[other backends]%s not used and not configured\n", ColorBold, ColorReset)
+		for _, b := range unused {
+			d.info("%s: set %s to check it", b.Name, configVars(b))
+		}
+	}
+	d.checkWorkDir()
+
+	fmt.Println()
+	switch {
+	case d.failures > 0:
+		fmt.Printf(ColorRed+"[✗] %d problem(s), %d warning(s). The dead will not answer until these are fixed.\n"+ColorReset, d.failures, d.warnings)
+		os.Exit(1)
+	case d.warnings > 0:
+		fmt.Printf(ColorYellow+"[!] No problems, %d warning(s).\n"+ColorReset, d.warnings)
+	default:
+		fmt.Println(ColorGreen + "[✓] All checks passed. The circle is ready." + ColorReset)
+	}
+}
+
+// doctorTargets lists the backends to check: the one given with --backend,
+// or the run's primary, fallbacks and ensemble members followed by every
+// other backend configured in the environment. The remaining backends are
+// returned separately, to be listed without a check.
+func doctorTargets(settings config.Settings, profile config.Profile, only, model string) ([]doctorTarget, []ai.Backend) {
+	if only != "" {
+		return []doctorTarget{{ref: config.BackendRef{Backend: only, Model: model}, role: "selected"}}, nil
+	}
+
+	var targets []doctorTarget
+	if len(settings.Ensemble) > 0 {
+		for _, ref := range settings.Ensemble {
+			targets = append(targets, doctorTarget{ref: ref, role: "ensemble member"})
+		}
+	} else {
+		primary := config.BackendRef{Backend: settings.Backend, Model: settings.Model}
+		if b, err := ai.Lookup(primary.Backend); err == nil && primary.Model == "" {
+			primary.Model = profile.ModelFor(b.Name, b.ModelEnv)
+		}
+		targets = append(targets, doctorTarget{ref: primary, role: "primary"})
+		for _, ref := range settings.Fallback {
+			targets = append(targets, doctorTarget{ref: ref, role: "fallback"})
+		}
+	}
+
+	used := make(map[string]bool)
+	for _, t := range targets {
+		used[strings.ToLower(t.ref.Backend)] = true
+	}
+	var unused []ai.Backend
+	for _, b := range ai.Backends() {
+		switch {
+		case used[b.Name]:
+		case configured(b):
+			targets = append(targets, doctorTarget{ref: config.BackendRef{Backend: b.Name}, role: "configured"})
+		default:
+			unused = append(unused, b)
+		}
+	}
+	return targets, unused
+}
+
+// configured reports whether b is set up in the environment: every
+// required variable is set, and at least one variable is. Backends without
+// required variables, such as Ollama, count only once one is set.
+func configured(b ai.Backend) bool {
+	set := false
+	for _, key := range b.Config {
+		value := os.Getenv(key.Env)
+		if key.Required && value == "" {
+			return false
+		}
+		set = set || value != ""
+	}
+	return set
+}
+
+// configVars names the variables that configure b: the required ones, or
+// the first one when none is required
+func configVars(b ai.Backend) string {
+	var names []string
+	for _, key := range b.Config {
+		if key.Required {
+			names = append(names, key.Env)
+		}
+	}
+	if len(names) == 0 && len(b.Config) > 0 {
+		names = append(names, b.Config[0].Env)
+	}
+	return strings.Join(names, ", ")
+}
+
+// checkBackend validates one backend's configuration and probes it
+func (d *doctor) checkBackend(t doctorTarget, policy string, timeout time.Duration) {
+	name := t.ref.Backend
+	if t.ref.Model != "" {
+		name += "/" + t.ref.Model
+	}
+	fmt.Printf("\n%s[%s]%s %s\n", ColorBold, name, ColorReset, t.role)
+
+	b, err := ai.Lookup(t.ref.Backend)
+	if err != nil {
+		d.fail("%v", err)
+		return
+	}
+	fmt.Printf("    %s\n", b.Description)
+
+	// 1. Configuration
+	var missing []string
+	for _, key := range b.Config {
+		if key.Required && os.Getenv(key.Env) == "" {
+			missing = append(missing, key.Env)
+		}
+	}
+	if len(missing) > 0 {
+		d.fail("not configured: set %s", strings.Join(missing, ", "))
+		return
+	}
+	client, err := b.New(ai.Config{Model: t.ref.Model})
+	if err != nil {
+		d.fail("configuration: %v", err)
+		return
+	}
+	d.ok("configuration complete")
+
+	// 2. Reachability, credentials and model. A failure is reported as is,
+	// without the retries and notices of a run.
+	ctx, cancel := context.WithTimeout(transport.WithoutRetries(context.Background()), timeout)
+	defer cancel()
+	start := time.Now()
+	result, err := ai.Probe(ctx, client)
+	elapsed := time.Since(start).Round(time.Millisecond)
+	switch {
+	case errors.Is(err, ai.ErrModelNotFound):
+		d.ok("reachable and authenticated (%s)", elapsed)
+		d.fail("%v", err)
+		return
+	case err != nil:
+		d.fail("probe failed after %s: %v", elapsed, err)
+		return
+	}
+	d.ok("reachable and authenticated (%s)", elapsed)
+
+	model := firstNonEmpty(result.Model, b.ResolveModel(t.ref.Model))
+	if model != "" {
+		d.ok("model %s", model)
+	} else {
+		d.info("model chosen by the server")
+	}
+	for _, note := range result.Notes {
+		d.info("%s", note)
+	}
+
+	// 3. Capabilities
+	if structured := firstNonEmpty(result.Structured, b.Capabilities.Structured); structured != "" && structured != "none" {
+		d.ok("structured output: %s", structured)
+	} else {
+		d.warn("no structured output; findings rely on the prompt and JSON repair")
+	}
+	if result.ContextWindow > 0 {
+		d.ok("context window: %d tokens", result.ContextWindow)
+	} else {
+		d.info("context window: not reported by the provider")
+	}
+
+	// 4. Privacy Cloak
+	local := b.IsLocal(client)
+	where := "remote"
+	if local {
+		where = "local"
+	}
+	switch {
+	case policy == config.PrivacyOff && !local:
+		d.warn("Privacy Cloak: OFF for a %s backend; real directory data would leave the network", where)
+	case policy == config.PrivacyOff:
+		d.ok("Privacy Cloak: OFF (%s backend, policy off)", where)
+	case policy == config.PrivacyOn || !local:
+		d.ok("Privacy Cloak: ON (%s backend, policy %s)", where, policy)
+	default:
+		d.ok("Privacy Cloak: OFF (%s backend, policy %s)", where, policy)
+	}
+}
+
+// checkWorkDir checks that .necromancer, which holds mappings, transcripts
+// and the response cache, is private, ignored by git and writable
+func (d *doctor) checkWorkDir() {
+	fmt.Printf("\n%s[.necromancer]%s mappings, transcripts and cache\n", ColorBold, ColorReset)
+
+	const dir = ".necromancer"
+	info, err := os.Stat(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		d.info("not created yet; it is created with mode 0700 on first use")
+		d.checkWritable(".")
+		return
+	}
+	if err != nil {
+		d.fail("%v", err)
+		return
+	}
+
+	for _, sub := range []string{"", "mappings", "runs", "cache"} {
+		path := filepath.Join(dir, sub)
+		info, err = os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if perm := info.Mode().Perm(); perm&0077 != 0 {
+			d.warn("%s is accessible to other users (mode %04o); run: chmod 700 %s", path, perm, path)
+		} else {
+			d.ok("%s is private (mode %04o)", path, perm)
+		}
+	}
+
+	// Mapping files pair every token with the real name
+	exposed := 0
+	filepath.WalkDir(filepath.Join(dir, "mappings"), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil && info.Mode().Perm()&0077 != 0 {
+			exposed++
+		}
+		return nil
+	})
+	if exposed > 0 {
+		d.warn("%d mapping file(s) readable by other users; run: chmod 600 %s/mappings/*", exposed, dir)
+	}
+
+	ignore, _ := os.ReadFile(filepath.Join(dir, ".gitignore"))
+	for _, sub := range []string{"mappings", "runs", "cache"} {
+		if _, err := os.Stat(filepath.Join(dir, sub)); err != nil {
+			continue
+		}
+		if !strings.Contains("\n"+string(ignore), "\n"+sub+"/") {
+			d.warn("%s/%s/ is not in %s/.gitignore and could be committed", dir, sub, dir)
+		}
+	}
+
+	d.checkWritable(dir)
+}
+
+// checkWritable verifies a file can be created in dir
+func (d *doctor) checkWritable(dir string) {
+	f, err := os.CreateTemp(dir, ".doctor-*")
+	if err != nil {
+		d.fail("cannot write to %s: %v", dir, err)
+		return
+	}
+	f.Close()
+	os.Remove(f.Name())
+	d.ok("%s is writable", dir)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -1,8 +1,134 @@
 package ai
 
+import "context"
+
 // AIClient is the interface that all AI backend clients must implement
 type AIClient interface {
+	// Chat sends a conversation to the AI backend and returns its reply.
+	// The call is aborted when ctx is cancelled or its deadline passes.
+	Chat(ctx context.Context, req *ChatRequest) (*ChatResponse, error)
+
 	// Summon sends a system prompt and user prompt to the AI backend
-	// and returns the raw JSON response as a string
+	// and returns the raw JSON response as a string.
+	// Kept for compatibility; backends implement it with ai.Summon.
 	Summon(systemPrompt, userPrompt string) (string, error)
 }
+
+// Conversation roles
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	RoleTool      = "tool" // Result of a tool call; see ToolCallID
+)
+
+// Defaults applied by every backend when Options leaves a field unset
+const (
+	DefaultTemperature = 0.7  // High creativity for "mutations"
+	DefaultMaxTokens   = 8000 // Large enough to avoid truncating findings
+)
+
+// Normalized finish reasons
+const (
+	FinishStop          = "stop"           // Natural end of the answer or a stop sequence
+	FinishLength        = "length"         // Hit the max token limit (output truncated)
+	FinishContentFilter = "content_filter" // Provider safety filter stopped the output
+	FinishToolUse       = "tool_use"       // Model stopped to call a tool
+)
+
+// Message is one turn of a conversation
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+
+	// Tool use: the calls an assistant turn made, or for a RoleTool turn
+	// the call it answers
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+	ToolName   string     `json:"tool_name,omitempty"`
+}
+
+// Options tune a single Chat call. Zero values mean "backend default".
+type Options struct {
+	Temperature *float64 // nil = DefaultTemperature
+	MaxTokens   int      // 0 = DefaultMaxTokens
+	Seed        *int     // Deterministic sampling where the provider supports it
+	Stop        []string // Stop sequences
+	Schema      *Schema  // Structured output; nil for free-form text
+}
+
+// TemperatureOrDefault returns the requested temperature or DefaultTemperature
+func (o Options) TemperatureOrDefault() float64 {
+	if o.Temperature != nil {
+		return *o.Temperature
+	}
+	return DefaultTemperature
+}
+
+// MaxTokensOrDefault returns the requested token limit or DefaultMaxTokens
+func (o Options) MaxTokensOrDefault() int {
+	if o.MaxTokens > 0 {
+		return o.MaxTokens
+	}
+	return DefaultMaxTokens
+}
+
+// ChatRequest is a conversation plus call options. Messages may start with
+// a RoleSystem message; after that user and assistant turns alternate,
+// ending with a user turn. With Tools, an assistant turn holding tool calls
+// is followed by one RoleTool turn per call.
+type ChatRequest struct {
+	Messages []Message
+	Options  Options
+	Tools    []Tool // Functions the model may call; only for a ToolUser
+}
+
+// ChatResponse is a backend's answer to a ChatRequest
+type ChatResponse struct {
+	Text         string
+	FinishReason string // One of the Finish* constants, or the provider's raw value
+	Model        string // Model that answered, as reported by the provider
+	Usage        Usage
+	ToolCalls    []ToolCall // Calls the model wants made; FinishReason is FinishToolUse
+}
+
+// Usage reports the tokens consumed by one or more calls
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// Total returns prompt plus completion tokens
+func (u Usage) Total() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+// Add accumulates other into u
+func (u *Usage) Add(other Usage) {
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+}
+
+// Summon runs a single-shot system + user exchange through client.Chat
+// without a deadline. Backends use it to implement AIClient.Summon.
+func Summon(client AIClient, systemPrompt, userPrompt string) (string, error) {
+	resp, err := client.Chat(context.Background(), &ChatRequest{
+		Messages: []Message{
+			{Role: RoleSystem, Content: systemPrompt},
+			{Role: RoleUser, Content: userPrompt},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.Text, nil
+}
+
+// SplitSystem separates a leading system message from the conversation turns,
+// for providers that take the system prompt as a separate field
+func SplitSystem(messages []Message) (string, []Message) {
+	if len(messages) > 0 && messages[0].Role == RoleSystem {
+		return messages[0].Content, messages[1:]
+	}
+	return "", messages
+}
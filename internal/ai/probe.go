@@ -0,0 +1,49 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ProbeResult is what a cheap request revealed about a configured backend
+type ProbeResult struct {
+	Model         string   // Model the server confirmed, if it says
+	ContextWindow int      // Input tokens the model accepts; 0 when unknown
+	Structured    string   // Structured-output mechanism that will be used; "" = the backend's capability
+	Notes         []string // Anything else worth reporting, e.g. the server version
+}
+
+// Prober is implemented by clients that can check connectivity,
+// credentials and the model without a chat completion
+type Prober interface {
+	Probe(ctx context.Context) (*ProbeResult, error)
+}
+
+// ErrModelNotFound is wrapped by probe errors for a model the server does
+// not serve
+var ErrModelNotFound = errors.New("model not found")
+
+// Probe checks client through its Prober, or with ProbeChat
+func Probe(ctx context.Context, client AIClient) (*ProbeResult, error) {
+	if p, ok := client.(Prober); ok {
+		return p.Probe(ctx)
+	}
+	return ProbeChat(ctx, client)
+}
+
+// ProbeChat checks client with a one-token completion. It costs a few
+// tokens but proves the endpoint, credentials and model all work.
+func ProbeChat(ctx context.Context, client AIClient) (*ProbeResult, error) {
+	resp, err := client.Chat(ctx, &ChatRequest{
+		Messages: []Message{{Role: RoleUser, Content: "Reply with OK."}},
+		Options:  Options{MaxTokens: 1},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ProbeResult{
+		Model: resp.Model,
+		Notes: []string{fmt.Sprintf("test completion used %d token(s)", resp.Usage.Total())},
+	}, nil
+}
@@ -0,0 +1,137 @@
+package ai
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultBackend is used when no backend is selected
+const DefaultBackend = "deepseek"
+
+// Backend describes an AI provider that can be selected with --backend.
+// Provider packages register themselves from init().
+type Backend struct {
+	Name         string // Selector used on the command line, e.g. "claude"
+	Description  string // Human-readable provider name
+	ModelEnv     string // Variable holding the model id, if any
+	DefaultModel string // Model used when none is configured, if not documented in Config
+	Config       []ConfigKey
+	Capabilities Capabilities
+	New          func(cfg Config) (AIClient, error)
+}
+
+// ConfigKey documents one environment variable a backend reads
+type ConfigKey struct {
+	Env         string
+	Description string
+	Required    bool
+	Default     string
+	Secret      bool // Value is never printed
+}
+
+// Capabilities advertise what a backend supports
+type Capabilities struct {
+	Local      bool   // Prompts stay on this machine/network; Privacy Cloak is off by default
+	AutoLocal  bool   // Local only when the configured endpoint is; see Locality
+	Structured string // Structured-output mechanism, e.g. "json_schema"
+	Seed       bool   // Honours Options.Seed
+	Tools      bool   // Function calling, for the --agent tool-use loop
+}
+
+// Config holds per-run overrides passed to a backend constructor.
+// Empty fields mean "use the backend's environment or default".
+type Config struct {
+	Model string // Model id (the deployment name for Azure)
+}
+
+// Locality is implemented by clients whose locality is only known once
+// configured, e.g. a generic server that may run on localhost
+type Locality interface {
+	IsLocal() bool
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Backend)
+)
+
+// Register makes a backend available by name. It panics on a duplicate or
+// incomplete registration, as both are programming errors.
+func Register(b Backend) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if b.Name == "" || b.New == nil {
+		panic("ai: Register requires a name and a constructor")
+	}
+	if _, dup := registry[b.Name]; dup {
+		panic("ai: Register called twice for backend " + b.Name)
+	}
+	registry[b.Name] = b
+}
+
+// Lookup returns the backend registered under name
+func Lookup(name string) (Backend, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	b, ok := registry[strings.ToLower(name)]
+	if !ok {
+		return Backend{}, fmt.Errorf("unknown backend %q (available: %s)", name, strings.Join(backendNames(), ", "))
+	}
+	return b, nil
+}
+
+// Backends returns all registered backends sorted by name
+func Backends() []Backend {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	backends := make([]Backend, 0, len(registry))
+	for _, name := range backendNames() {
+		backends = append(backends, registry[name])
+	}
+	return backends
+}
+
+// IsLocal reports whether prompts sent through client stay local, preferring
+// the client's own answer over the backend's static capability
+func (b Backend) IsLocal(client AIClient) bool {
+	if l, ok := client.(Locality); ok {
+		return l.IsLocal()
+	}
+	return b.Capabilities.Local
+}
+
+// ResolveModel returns the model a client created with Config{Model: model}
+// uses: the override, the backend's model variable or its default. It is
+// empty when the backend leaves the choice to the server.
+func (b Backend) ResolveModel(model string) string {
+	if model != "" {
+		return model
+	}
+	if b.ModelEnv != "" {
+		if v := os.Getenv(b.ModelEnv); v != "" {
+			return v
+		}
+		for _, key := range b.Config {
+			if key.Env == b.ModelEnv && key.Default != "" {
+				return key.Default
+			}
+		}
+	}
+	return b.DefaultModel
+}
+
+// backendNames returns the sorted registry keys; callers hold registryMu
+func backendNames() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,155 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync/atomic"
+)
+
+// Schema describes the JSON document a backend should constrain its output to.
+// Backends translate Definition into their native structured-output feature
+// (response_format, responseSchema, tool input_schema, format, ...).
+type Schema struct {
+	Name        string                 // Identifier required by some providers (e.g. "zombie_paths")
+	Description string                 // Short human-readable purpose of the document
+	Definition  map[string]interface{} // JSON Schema (draft 2020-12 subset); root must be an object
+
+	// Instructions describe the document in words; they are added to the
+	// system prompt only when the backend cannot enforce Definition
+	Instructions string
+}
+
+// SchemaRejectedError is returned by a backend when the provider refused the
+// structured-output parameters (unsupported model, unknown field, ...).
+// Callers should retry the same request in prompt-only mode.
+type SchemaRejectedError struct {
+	Err error
+}
+
+func (e *SchemaRejectedError) Error() string {
+	return "structured output rejected: " + e.Err.Error()
+}
+
+func (e *SchemaRejectedError) Unwrap() error {
+	return e.Err
+}
+
+// IsSchemaRejected reports whether err means the backend cannot honour a schema
+func IsSchemaRejected(err error) bool {
+	var rejected *SchemaRejectedError
+	return errors.As(err, &rejected)
+}
+
+// SchemaFor builds a JSON Schema for v from its Go type and json struct tags.
+// Fields tagged omitempty are optional; every other field is required.
+// Fields tagged schema:"-" are filled in by the program and left out.
+func SchemaFor(v interface{}) map[string]interface{} {
+	return schemaForType(reflect.TypeOf(v))
+}
+
+func schemaForType(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": schemaForType(t.Elem()),
+		}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": schemaForType(t.Elem()),
+		}
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() || field.Tag.Get("schema") == "-" {
+				continue
+			}
+
+			name := field.Name
+			optional := false
+			if tag, ok := field.Tag.Lookup("json"); ok {
+				parts := strings.Split(tag, ",")
+				if parts[0] == "-" {
+					continue
+				}
+				if parts[0] != "" {
+					name = parts[0]
+				}
+				for _, opt := range parts[1:] {
+					if opt == "omitempty" {
+						optional = true
+					}
+				}
+			}
+
+			properties[name] = schemaForType(field.Type)
+			if !optional {
+				required = append(required, name)
+			}
+		}
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"required":             required,
+			"additionalProperties": false,
+		}
+	}
+
+	// Interfaces and anything exotic: accept any JSON value
+	return map[string]interface{}{}
+}
+
+// ChatWithSchemaFallback sends req with its schema unless the backend has
+// already rejected structured output; on rejection it flags unsupported and
+// resends the request in prompt-only mode. send receives the schema to
+// apply, which is nil in prompt-only mode.
+func ChatWithSchemaFallback(ctx context.Context, unsupported *atomic.Bool, req *ChatRequest, send func(context.Context, *ChatRequest, *Schema) (*ChatResponse, error)) (*ChatResponse, error) {
+	if req.Options.Schema != nil && !unsupported.Load() {
+		resp, err := send(ctx, req, req.Options.Schema)
+		if !IsSchemaRejected(err) {
+			return resp, err
+		}
+		unsupported.Store(true)
+	}
+	return send(ctx, PromptOnly(req), nil)
+}
+
+// PromptOnly returns req as sent to a backend that cannot enforce its
+// schema: the schema's Instructions are appended to the system prompt
+func PromptOnly(req *ChatRequest) *ChatRequest {
+	if req.Options.Schema == nil || req.Options.Schema.Instructions == "" {
+		return req
+	}
+	instructions := req.Options.Schema.Instructions
+
+	out := *req
+	out.Messages = make([]Message, 0, len(req.Messages)+1)
+	for i, m := range req.Messages {
+		if m.Role == RoleSystem {
+			m.Content += "\n\n" + instructions
+			out.Messages = append(append(out.Messages, m), req.Messages[i+1:]...)
+			return &out
+		}
+		out.Messages = append(out.Messages, m)
+	}
+	out.Messages = append([]Message{{Role: RoleSystem, Content: instructions}}, req.Messages...)
+	return &out
+}
@@ -0,0 +1,139 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// schemaFinding has a field of every kind SchemaFor handles
+type schemaFinding struct {
+	Title    string            `json:"title"`
+	Severity int               `json:"severity"`
+	Score    float64           `json:"score,omitempty"`
+	Tags     []string          `json:"tags"`
+	Extra    map[string]bool   `json:"extra,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Nested   *schemaNested     `json:"nested"`
+	Any      interface{}       `json:"any,omitempty"`
+	Computed string            `json:"computed" schema:"-"` // Filled in by the program
+	Skipped  string            `json:"-"`
+	Untagged bool              // Named after the field
+	private  string            // Not exported, so not in the schema
+}
+
+type schemaNested struct {
+	Reason string `json:"reason"`
+}
+
+func TestSchemaFor(t *testing.T) {
+	got := SchemaFor(&schemaFinding{})
+
+	want := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"title":    map[string]interface{}{"type": "string"},
+			"severity": map[string]interface{}{"type": "integer"},
+			"score":    map[string]interface{}{"type": "number"},
+			"tags":     map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			"extra":    map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{"type": "boolean"}},
+			"nested": map[string]interface{}{
+				"type":                 "object",
+				"properties":           map[string]interface{}{"reason": map[string]interface{}{"type": "string"}},
+				"required":             []string{"reason"},
+				"additionalProperties": false,
+			},
+			"any":      map[string]interface{}{},
+			"Untagged": map[string]interface{}{"type": "boolean"},
+			"labels":   map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{"type": "string"}},
+		},
+		"required":             []string{"title", "severity", "tags", "nested", "Untagged"},
+		"additionalProperties": false,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SchemaFor:\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestPromptOnly(t *testing.T) {
+	schema := &Schema{Name: "findings", Instructions: "Reply with JSON."}
+
+	req := &ChatRequest{
+		Messages: []Message{{Role: RoleSystem, Content: "system"}, {Role: RoleUser, Content: "user"}},
+		Options:  Options{Schema: schema},
+	}
+	out := PromptOnly(req)
+	if got := out.Messages[0].Content; got != "system\n\nReply with JSON." {
+		t.Errorf("system prompt = %q", got)
+	}
+	if req.Messages[0].Content != "system" {
+		t.Error("PromptOnly modified the original request")
+	}
+
+	// Without a system prompt the instructions become one
+	req = &ChatRequest{Messages: []Message{{Role: RoleUser, Content: "user"}}, Options: Options{Schema: schema}}
+	out = PromptOnly(req)
+	if len(out.Messages) != 2 || out.Messages[0].Role != RoleSystem || out.Messages[0].Content != "Reply with JSON." {
+		t.Errorf("messages = %+v, want the instructions as a system prompt", out.Messages)
+	}
+
+	// Nothing to add
+	req = &ChatRequest{Messages: []Message{{Role: RoleUser, Content: "user"}}}
+	if PromptOnly(req) != req {
+		t.Error("PromptOnly copied a request without a schema")
+	}
+}
+
+func TestChatWithSchemaFallback(t *testing.T) {
+	req := &ChatRequest{
+		Messages: []Message{{Role: RoleSystem, Content: "system"}, {Role: RoleUser, Content: "user"}},
+		Options:  Options{Schema: &Schema{Name: "findings", Instructions: "Reply with JSON."}},
+	}
+
+	var schemas []*Schema
+	var systems []string
+	send := func(ctx context.Context, req *ChatRequest, schema *Schema) (*ChatResponse, error) {
+		schemas = append(schemas, schema)
+		systems = append(systems, req.Messages[0].Content)
+		if schema != nil {
+			return nil, &SchemaRejectedError{Err: errors.New("unknown field response_format")}
+		}
+		return &ChatResponse{Text: "[]"}, nil
+	}
+
+	var unsupported atomic.Bool
+	if _, err := ChatWithSchemaFallback(context.Background(), &unsupported, req, send); err != nil {
+		t.Fatal(err)
+	}
+	if len(schemas) != 2 || schemas[0] == nil || schemas[1] != nil {
+		t.Fatalf("schemas sent = %v, want the schema then none", schemas)
+	}
+	if !strings.HasSuffix(systems[1], "Reply with JSON.") {
+		t.Errorf("prompt-only system prompt = %q, want the instructions", systems[1])
+	}
+	if !unsupported.Load() {
+		t.Error("the rejection was not remembered")
+	}
+
+	// Later calls go straight to prompt-only
+	schemas = nil
+	if _, err := ChatWithSchemaFallback(context.Background(), &unsupported, req, send); err != nil {
+		t.Fatal(err)
+	}
+	if len(schemas) != 1 || schemas[0] != nil {
+		t.Errorf("schemas sent after a rejection = %v, want none", schemas)
+	}
+
+	// Other errors are returned as they are
+	failure := errors.New("connection refused")
+	var fresh atomic.Bool
+	_, err := ChatWithSchemaFallback(context.Background(), &fresh, req, func(context.Context, *ChatRequest, *Schema) (*ChatResponse, error) {
+		return nil, failure
+	})
+	if !errors.Is(err, failure) || fresh.Load() {
+		t.Errorf("err = %v, unsupported = %v; want the error and the schema kept", err, fresh.Load())
+	}
+}
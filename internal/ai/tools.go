@@ -0,0 +1,62 @@
+package ai
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Tool is a function the model may call during a conversation
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  map[string]interface{} // JSON Schema of the arguments object
+}
+
+// ToolCall is one function call requested by the model
+type ToolCall struct {
+	ID        string `json:"id"` // Pairs the call with its RoleTool result
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"` // JSON object
+}
+
+// ToolUser is implemented by clients that can offer ChatRequest.Tools to
+// the model and return its calls. Clients wrapping another client report
+// what the wrapped one supports.
+type ToolUser interface {
+	SupportsTools() bool
+}
+
+// SupportsTools reports whether client can take part in a tool-use loop
+func SupportsTools(client AIClient) bool {
+	t, ok := client.(ToolUser)
+	return ok && t.SupportsTools()
+}
+
+// ToolsRejectedError reports that the backend refused a request because of
+// its tools, e.g. an Ollama model without function calling
+type ToolsRejectedError struct {
+	Err error
+}
+
+func (e *ToolsRejectedError) Error() string {
+	return "tools rejected: " + e.Err.Error()
+}
+
+func (e *ToolsRejectedError) Unwrap() error {
+	return e.Err
+}
+
+// IsToolsRejected reports whether err means the backend cannot call tools
+func IsToolsRejected(err error) bool {
+	var rejected *ToolsRejectedError
+	return errors.As(err, &rejected)
+}
+
+// ToolCallID returns id, or a generated one for providers whose calls
+// carry none; results are matched to calls by it
+func ToolCallID(id string, index int) string {
+	if id != "" {
+		return id
+	}
+	return fmt.Sprintf("call_%d", index+1)
+}
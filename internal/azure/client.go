@@ -0,0 +1,213 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"ad-necromancer/internal/ai"
+	"ad-necromancer/internal/openaicompat"
+	"ad-necromancer/internal/transport"
+)
+
+// First GA api-version with structured outputs (json_schema)
+const defaultAPIVersion = "2024-10-21"
+
+// Client is the Azure OpenAI backend. Azure speaks the OpenAI protocol but
+// addresses models by deployment name, versions the API with a query
+// parameter and authenticates with an api-key header or an Entra ID token.
+type Client struct {
+	*openaicompat.Client
+	Deployment string
+	APIVersion string
+}
+
+// NewClient creates a new Azure OpenAI client from the environment:
+//
+//	AZURE_OPENAI_ENDPOINT     required, e.g. https://contoso.openai.azure.com
+//	AZURE_OPENAI_DEPLOYMENT   required, the deployment name
+//	AZURE_OPENAI_API_VERSION  optional, defaults to 2024-10-21
+//	AZURE_OPENAI_API_KEY      key authentication, or
+//	AZURE_OPENAI_AD_TOKEN     Entra ID bearer token
+//	                          (e.g. az account get-access-token --resource https://cognitiveservices.azure.com)
+func NewClient() (*Client, error) {
+	return newClient(os.Getenv("AZURE_OPENAI_DEPLOYMENT"))
+}
+
+// newClient reads the rest of the configuration from the environment
+func newClient(deployment string) (*Client, error) {
+	endpoint := os.Getenv("AZURE_OPENAI_ENDPOINT")
+	if endpoint == "" {
+		return nil, fmt.Errorf("AZURE_OPENAI_ENDPOINT environment variable not set")
+	}
+	if _, err := url.ParseRequestURI(endpoint); err != nil {
+		return nil, fmt.Errorf("invalid AZURE_OPENAI_ENDPOINT: %w", err)
+	}
+	if deployment == "" {
+		return nil, fmt.Errorf("AZURE_OPENAI_DEPLOYMENT environment variable not set")
+	}
+
+	apiVersion := os.Getenv("AZURE_OPENAI_API_VERSION")
+	if apiVersion == "" {
+		apiVersion = defaultAPIVersion
+	}
+
+	// The deployment selects the model, so no model id is sent
+	c := openaicompat.New("Azure OpenAI", endpoint, "", 180*time.Second)
+	c.Path = "/openai/deployments/" + url.PathEscape(deployment) +
+		"/chat/completions?api-version=" + url.QueryEscape(apiVersion)
+	c.ClassifyError = classifyError(deployment)
+
+	switch apiKey, token := os.Getenv("AZURE_OPENAI_API_KEY"), os.Getenv("AZURE_OPENAI_AD_TOKEN"); {
+	case apiKey != "":
+		c.Headers["api-key"] = apiKey
+	case token != "":
+		c.APIKey = token // sent as "Authorization: Bearer"
+	default:
+		return nil, fmt.Errorf("set AZURE_OPENAI_API_KEY or AZURE_OPENAI_AD_TOKEN")
+	}
+
+	return &Client{Client: c, Deployment: deployment, APIVersion: apiVersion}, nil
+}
+
+func init() {
+	ai.Register(ai.Backend{
+		Name:        "azure",
+		Description: "Azure OpenAI",
+		ModelEnv:    "AZURE_OPENAI_DEPLOYMENT",
+		Config: []ai.ConfigKey{
+			{Env: "AZURE_OPENAI_ENDPOINT", Description: "Resource endpoint, e.g. https://contoso.openai.azure.com", Required: true},
+			{Env: "AZURE_OPENAI_DEPLOYMENT", Description: "Deployment name (--model overrides)", Required: true},
+			{Env: "AZURE_OPENAI_API_VERSION", Description: "API version", Default: defaultAPIVersion},
+			{Env: "AZURE_OPENAI_API_KEY", Description: "API key (or set AZURE_OPENAI_AD_TOKEN)", Secret: true},
+			{Env: "AZURE_OPENAI_AD_TOKEN", Description: "Entra ID bearer token", Secret: true},
+		},
+		Capabilities: ai.Capabilities{Structured: "json_schema", Seed: true, Tools: true},
+		New: func(cfg ai.Config) (ai.AIClient, error) {
+			if cfg.Model != "" {
+				return newClient(cfg.Model)
+			}
+			return NewClient()
+		},
+	})
+}
+
+// Summon implements the AIClient interface
+func (c *Client) Summon(systemPrompt, userPrompt string) (string, error) {
+	return ai.Summon(c, systemPrompt, userPrompt)
+}
+
+// Chat implements the AIClient interface. A completion withheld by the
+// output filter is reported as a ContentFilterError like a filtered prompt.
+func (c *Client) Chat(ctx context.Context, req *ai.ChatRequest) (*ai.ChatResponse, error) {
+	resp, err := c.Client.Chat(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.FinishReason == ai.FinishContentFilter && strings.TrimSpace(resp.Text) == "" {
+		return nil, &ContentFilterError{
+			Deployment: c.Deployment,
+			Source:     "completion",
+			Message:    "the completion was withheld by the Azure content filter",
+		}
+	}
+	return resp, nil
+}
+
+// Probe implements ai.Prober with a one-token completion, since Azure has no
+// data-plane call that checks a deployment without using it
+func (c *Client) Probe(ctx context.Context) (*ai.ProbeResult, error) {
+	result, err := ai.ProbeChat(ctx, c)
+	if err != nil {
+		if transport.IsStatus(err, http.StatusNotFound) {
+			return nil, fmt.Errorf("%w: deployment %q not found: %v", ai.ErrModelNotFound, c.Deployment, err)
+		}
+		return nil, err
+	}
+	result.Structured = c.Structured
+	result.Notes = append(result.Notes, "api-version "+c.APIVersion)
+	return result, nil
+}
+
+// ContentFilterError is returned when Azure's content management policy
+// blocks the prompt or the completion. Security content such as attack
+// paths occasionally trips the filters; rerunning will not help, but a
+// smaller sample or a deployment with a custom filter policy may.
+type ContentFilterError struct {
+	Deployment string
+	Source     string   // "prompt" or "completion"
+	Categories []string // Filter categories that triggered, e.g. "jailbreak", "violence"
+	Message    string
+}
+
+func (e *ContentFilterError) Error() string {
+	msg := fmt.Sprintf("Azure OpenAI content filter blocked the %s (deployment %s)", e.Source, e.Deployment)
+	if len(e.Categories) > 0 {
+		msg += ": " + strings.Join(e.Categories, ", ")
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// IsContentFilter reports whether err is a ContentFilterError
+func IsContentFilter(err error) bool {
+	var filterErr *ContentFilterError
+	return errors.As(err, &filterErr)
+}
+
+// errorBody is Azure's error envelope for filtered prompts
+type errorBody struct {
+	Error struct {
+		Code       string `json:"code"`
+		Message    string `json:"message"`
+		InnerError struct {
+			Code                string `json:"code"`
+			ContentFilterResult map[string]struct {
+				Filtered bool `json:"filtered"`
+			} `json:"content_filter_result"`
+		} `json:"innererror"`
+	} `json:"error"`
+}
+
+// classifyError turns a content_filter 400 into a ContentFilterError so the
+// schema fallback does not mistake it for a rejected response_format
+func classifyError(deployment string) func(error) error {
+	return func(err error) error {
+		var statusErr *transport.StatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadRequest {
+			return nil
+		}
+
+		var body errorBody
+		if json.Unmarshal([]byte(statusErr.Body), &body) != nil {
+			return nil
+		}
+		if body.Error.Code != "content_filter" && body.Error.InnerError.Code != "ResponsibleAIPolicyViolation" {
+			return nil
+		}
+
+		var categories []string
+		for name, result := range body.Error.InnerError.ContentFilterResult {
+			if result.Filtered {
+				categories = append(categories, name)
+			}
+		}
+		sort.Strings(categories)
+
+		return &ContentFilterError{
+			Deployment: deployment,
+			Source:     "prompt",
+			Categories: categories,
+			Message:    body.Error.Message,
+		}
+	}
+}
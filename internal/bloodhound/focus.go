@@ -0,0 +1,171 @@
+package bloodhound
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Select returns the object identifiers a focus reference names, in load
+// order. The reference is tried as an object identifier or full name, then
+// as a distinguished name whose subtree is selected, then as a domain name,
+// then as a name without its @DOMAIN or .domain suffix. A domain, OU or
+// container selects everything under it.
+func (g *Graph) Select(ref string) ([]string, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return nil, fmt.Errorf("empty focus")
+	}
+	upper := strings.ToUpper(ref)
+
+	if n, ok := g.Node(ref); ok {
+		switch {
+		case n.Kind == KindDomain:
+			return g.selectDomain(strings.ToUpper(n.Properties.Name)), nil
+		case (n.Kind == KindOU || n.Kind == KindContainer) && n.Properties.DistinguishedName != "":
+			return g.selectSubtree(strings.ToUpper(n.Properties.DistinguishedName)), nil
+		}
+		return []string{strings.ToUpper(n.ObjectIdentifier)}, nil
+	}
+	if strings.Contains(ref, "=") {
+		if ids := g.selectSubtree(upper); len(ids) > 0 {
+			return ids, nil
+		}
+		return nil, fmt.Errorf("no loaded object is in %s", ref)
+	}
+	if ids := g.selectDomain(upper); len(ids) > 0 {
+		return ids, nil
+	}
+
+	var id string
+	var matches []string
+	for _, n := range g.order {
+		name := strings.ToUpper(n.Properties.Name)
+		if strings.HasPrefix(name, upper+"@") || strings.HasPrefix(name, upper+".") {
+			id = strings.ToUpper(n.ObjectIdentifier)
+			matches = append(matches, n.Properties.Name)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no object, distinguished name or domain matches %q", ref)
+	case 1:
+		return []string{id}, nil
+	}
+	sort.Strings(matches)
+	return nil, fmt.Errorf("%q is ambiguous: %s", ref, strings.Join(matches, ", "))
+}
+
+// selectDomain returns every object of the domain named domain, the
+// domain object included
+func (g *Graph) selectDomain(domain string) []string {
+	var ids []string
+	for _, n := range g.order {
+		if strings.ToUpper(n.Properties.Domain) == domain ||
+			(n.Kind == KindDomain && strings.ToUpper(n.Properties.Name) == domain) {
+			ids = append(ids, strings.ToUpper(n.ObjectIdentifier))
+		}
+	}
+	return ids
+}
+
+// selectSubtree returns every object whose distinguished name is dn or
+// under it
+func (g *Graph) selectSubtree(dn string) []string {
+	var ids []string
+	for _, n := range g.order {
+		name := strings.ToUpper(n.Properties.DistinguishedName)
+		if name == dn || strings.HasSuffix(name, ","+dn) {
+			ids = append(ids, strings.ToUpper(n.ObjectIdentifier))
+		}
+	}
+	return ids
+}
+
+// Subgraph is the neighbourhood of a set of seed objects
+type Subgraph struct {
+	Seeds     []string       // Object identifiers, in the order given
+	IDs       []string       // Seeds first, then nearest first
+	Hops      map[string]int // Distance of every object in IDs from the nearest seed
+	Edges     []Edge         // Between objects of the subgraph, scope edges included
+	Truncated bool           // maxNodes was reached before hops
+}
+
+// Neighbourhood collects what surrounds the seeds within hops edges.
+// Following edges outwards: what they control, the groups they are in, and
+// for a GPO, OU or domain what it applies to. Following edges inwards: who
+// controls them and the members of seed groups. The OUs above each seed
+// and the GPOs linked to those are one hop away, and whoever controls them
+// is expanded inwards like a seed's controllers. Objects reached outwards
+// are only expanded outwards and vice versa, so the members of a group a
+// seed belongs to are not pulled in. It stops at maxNodes objects, nearest
+// first; maxNodes < 1 means no limit.
+func (g *Graph) Neighbourhood(seeds []string, hops, maxNodes int) *Subgraph {
+	s := &Subgraph{Hops: make(map[string]int)}
+	add := func(id string, hop int) bool {
+		if _, seen := s.Hops[id]; seen {
+			return false
+		}
+		if maxNodes > 0 && len(s.IDs) >= maxNodes {
+			s.Truncated = true
+			return false
+		}
+		s.Hops[id] = hop
+		s.IDs = append(s.IDs, id)
+		return true
+	}
+
+	var outward, inward, scope []string
+	for _, id := range seeds {
+		id = strings.ToUpper(id)
+		if add(id, 0) {
+			s.Seeds = append(s.Seeds, id)
+			outward = append(outward, id)
+			inward = append(inward, id)
+		}
+	}
+	if hops > 0 {
+		for _, id := range s.Seeds {
+			for _, e := range g.Scope(id) {
+				if add(e.Source, 1) {
+					scope = append(scope, e.Source)
+				}
+			}
+		}
+	}
+
+	for hop := 1; hop <= hops && (len(outward) > 0 || len(inward) > 0); hop++ {
+		var nextOut, nextIn []string
+		for _, id := range outward {
+			for _, edges := range [][]Edge{g.out[id], g.scopeOut[id]} {
+				for _, e := range edges {
+					if add(e.Target, hop) {
+						nextOut = append(nextOut, e.Target)
+					}
+				}
+			}
+		}
+		for _, id := range inward {
+			for _, e := range g.in[id] {
+				if add(e.Source, hop) {
+					nextIn = append(nextIn, e.Source)
+				}
+			}
+		}
+		if hop == 1 {
+			nextIn = append(nextIn, scope...)
+		}
+		outward, inward = nextOut, nextIn
+	}
+
+	for _, id := range s.IDs {
+		for _, edges := range [][]Edge{g.out[id], g.scopeOut[id]} {
+			for _, e := range edges {
+				if _, ok := s.Hops[e.Target]; ok {
+					s.Edges = append(s.Edges, e)
+				}
+			}
+		}
+	}
+	return s
+}
@@ -0,0 +1,347 @@
+package bloodhound
+
+import "strings"
+
+// Node kinds, as BloodHound labels them
+const (
+	KindUser         = "User"
+	KindGroup        = "Group"
+	KindComputer     = "Computer"
+	KindDomain       = "Domain"
+	KindGPO          = "GPO"
+	KindOU           = "OU"
+	KindContainer    = "Container"
+	KindCertTemplate = "CertTemplate"
+	KindEnterpriseCA = "EnterpriseCA"
+)
+
+// EdgeMemberOf links a member to its group; every other edge is the right
+// an ACE grants
+const EdgeMemberOf = "MemberOf"
+
+// Scope edges place an object under its OUs and the GPOs that apply to it.
+// They are derived from distinguished names and GPO links, and are kept
+// apart from the control edges: only Scope, Contents and Neighbourhood
+// return them.
+const (
+	EdgeContains = "Contains" // From a domain, OU or container to an object in it
+	EdgeGPLink   = "GPLink"   // From a GPO to the domain or OU it is linked to
+)
+
+// tier0RIDs are the relative ids of groups that control a domain: Domain
+// Admins, Domain Controllers, Schema Admins, Enterprise Admins, Key Admins,
+// Enterprise Key Admins, and the builtin Administrators, Account Operators,
+// Server Operators and Backup Operators
+var tier0RIDs = []string{"-512", "-516", "-518", "-519", "-526", "-527", "-544", "-548", "-549", "-551"}
+
+// Edge is a directed control relationship: Source can act on Target
+type Edge struct {
+	Source     string // Object identifier
+	SourceKind string // From the ACE or membership; the node may not be loaded
+	Target     string
+	Kind       string // EdgeMemberOf or the ACE's right, e.g. GenericAll
+	Inherited  bool
+}
+
+// GraphNode is a loaded node and its kind
+type GraphNode struct {
+	*Node
+	Kind string
+}
+
+// Graph indexes the loaded nodes and the edges between them: ACEs and
+// group memberships. It is read-only once built, so it is safe for
+// concurrent use.
+type Graph struct {
+	nodes  map[string]*GraphNode // By upper-case object identifier
+	byName map[string]string     // Upper-case name -> object identifier
+	byDN   map[string]string     // Upper-case distinguished name -> object identifier
+	sids   map[string]string     // Domain SID -> upper-case domain name, trusted domains included
+	order  []*GraphNode          // Load order, for stable search results
+	out    map[string][]Edge
+	in     map[string][]Edge
+
+	scopeOut map[string][]Edge // Contains and GPLink edges by source
+	scopeIn  map[string][]Edge // and by target
+}
+
+// NewGraph indexes data
+func NewGraph(data *BloodHoundData) *Graph {
+	g := &Graph{
+		nodes:  make(map[string]*GraphNode),
+		byName: make(map[string]string),
+		byDN:   make(map[string]string),
+		sids:   make(map[string]string),
+		out:    make(map[string][]Edge),
+		in:     make(map[string][]Edge),
+
+		scopeOut: make(map[string][]Edge),
+		scopeIn:  make(map[string][]Edge),
+	}
+	for _, set := range []struct {
+		kind  string
+		nodes []Node
+	}{
+		{KindDomain, data.Domains},
+		{KindUser, data.Users},
+		{KindGroup, data.Groups},
+		{KindComputer, data.Computers},
+		{KindGPO, data.GPOs},
+		{KindOU, data.OUs},
+		{KindContainer, data.Containers},
+		{KindCertTemplate, data.CertTemplates},
+		{KindEnterpriseCA, data.EnterpriseCAs},
+	} {
+		for i := range set.nodes {
+			n := &GraphNode{Node: &set.nodes[i], Kind: set.kind}
+			id := strings.ToUpper(n.ObjectIdentifier)
+			if id == "" {
+				continue
+			}
+			if _, dup := g.nodes[id]; dup {
+				continue
+			}
+			g.nodes[id] = n
+			g.order = append(g.order, n)
+			if n.Properties.Name != "" {
+				g.byName[strings.ToUpper(n.Properties.Name)] = id
+			}
+			if n.Properties.DistinguishedName != "" {
+				g.byDN[strings.ToUpper(n.Properties.DistinguishedName)] = id
+			}
+			if n.Kind == KindDomain {
+				g.sids[id] = strings.ToUpper(n.Properties.Name)
+				for _, t := range n.Trusts {
+					sid := strings.ToUpper(t.TargetDomainSid)
+					if _, known := g.sids[sid]; !known && sid != "" && t.TargetDomainName != "" {
+						g.sids[sid] = strings.ToUpper(t.TargetDomainName)
+					}
+				}
+			}
+		}
+	}
+
+	for _, n := range g.order {
+		target := strings.ToUpper(n.ObjectIdentifier)
+		for _, ace := range n.Aces {
+			g.add(Edge{
+				Source:     strings.ToUpper(ace.PrincipalSID),
+				SourceKind: ace.PrincipalType,
+				Target:     target,
+				Kind:       ace.RightName,
+				Inherited:  ace.IsInherited,
+			})
+		}
+		for _, m := range n.Members {
+			g.add(Edge{
+				Source:     strings.ToUpper(m.ObjectIdentifier),
+				SourceKind: m.ObjectType,
+				Target:     target,
+				Kind:       EdgeMemberOf,
+			})
+		}
+		g.addScope(n)
+	}
+	return g
+}
+
+// addScope indexes the edges placing n under the nearest loaded domain,
+// OU or container above it, and the GPOs linked to n
+func (g *Graph) addScope(n *GraphNode) {
+	id := strings.ToUpper(n.ObjectIdentifier)
+	for _, l := range n.Links {
+		if gpo := strings.ToUpper(l.GUID); gpo != "" {
+			g.addScopeEdge(Edge{Source: gpo, SourceKind: KindGPO, Target: id, Kind: EdgeGPLink})
+		}
+	}
+	for dn := parentDN(n.Properties.DistinguishedName); dn != ""; dn = parentDN(dn) {
+		if parent, ok := g.byDN[strings.ToUpper(dn)]; ok {
+			g.addScopeEdge(Edge{Source: parent, SourceKind: g.nodes[parent].Kind, Target: id, Kind: EdgeContains})
+			return
+		}
+	}
+}
+
+func (g *Graph) addScopeEdge(e Edge) {
+	g.scopeOut[e.Source] = append(g.scopeOut[e.Source], e)
+	g.scopeIn[e.Target] = append(g.scopeIn[e.Target], e)
+}
+
+func (g *Graph) add(e Edge) {
+	if e.Source == "" || e.Kind == "" {
+		return
+	}
+	g.out[e.Source] = append(g.out[e.Source], e)
+	g.in[e.Target] = append(g.in[e.Target], e)
+}
+
+// Node looks a node up by object identifier or name, case-insensitively
+func (g *Graph) Node(ref string) (*GraphNode, bool) {
+	ref = strings.ToUpper(strings.TrimSpace(ref))
+	if n, ok := g.nodes[ref]; ok {
+		return n, true
+	}
+	if id, ok := g.byName[ref]; ok {
+		return g.nodes[id], true
+	}
+	return nil, false
+}
+
+// Lookup returns the loaded node with object identifier id, if any
+func (g *Graph) Lookup(id string) (*GraphNode, bool) {
+	n, ok := g.nodes[strings.ToUpper(id)]
+	return n, ok
+}
+
+// Nodes returns every node in load order
+func (g *Graph) Nodes() []*GraphNode {
+	return g.order
+}
+
+// Outbound returns the edges from the node with object identifier id:
+// what it can control, and the groups it belongs to
+func (g *Graph) Outbound(id string) []Edge {
+	return g.out[strings.ToUpper(id)]
+}
+
+// Inbound returns the edges into the node with object identifier id: who
+// controls it, and its members if it is a group
+func (g *Graph) Inbound(id string) []Edge {
+	return g.in[strings.ToUpper(id)]
+}
+
+// Scope returns the edges placing the object with identifier id in the
+// directory tree: Contains from each loaded domain, OU or container above
+// it, and GPLink from each GPO linked to one of them, nearest first
+func (g *Graph) Scope(id string) []Edge {
+	var edges []Edge
+	for child := strings.ToUpper(id); ; {
+		var parent string
+		for _, e := range g.scopeIn[child] {
+			edges = append(edges, e)
+			if e.Kind == EdgeContains {
+				parent = e.Source
+			}
+		}
+		if parent == "" {
+			return edges
+		}
+		child = parent
+	}
+}
+
+// Contents returns the scope edges out of the object with identifier id:
+// Contains to the objects directly in a domain, OU or container, and GPLink
+// to the domains and OUs a GPO is linked to
+func (g *Graph) Contents(id string) []Edge {
+	return g.scopeOut[strings.ToUpper(id)]
+}
+
+// parentDN drops the first component of a distinguished name, minding
+// escaped commas; it returns "" for a top-level name
+func parentDN(dn string) string {
+	for i := 0; i < len(dn); i++ {
+		switch dn[i] {
+		case '\\':
+			i++
+		case ',':
+			return strings.TrimSpace(dn[i+1:])
+		}
+	}
+	return ""
+}
+
+// IsTier0 reports whether the object controls a domain outright: a domain,
+// a node marked high value, or a privileged builtin group. The object need
+// not be loaded, as ACEs often name builtin groups that were not exported.
+func (g *Graph) IsTier0(id string) bool {
+	id = strings.ToUpper(id)
+	if n, ok := g.nodes[id]; ok && (n.Kind == KindDomain || n.Properties.HighValue) {
+		return true
+	}
+	for _, rid := range tier0RIDs {
+		if strings.HasSuffix(id, rid) {
+			return true
+		}
+	}
+	return false
+}
+
+// ShortestPathToTier0 returns the fewest edges leading from the object with
+// identifier from to a tier-0 object, following at most maxHops edges. It
+// returns an empty path when from is tier 0 itself and false when no path
+// exists.
+func (g *Graph) ShortestPathToTier0(from string, maxHops int) ([]Edge, bool) {
+	from = strings.ToUpper(from)
+	if g.IsTier0(from) {
+		return nil, true
+	}
+
+	// Breadth-first, remembering the edge each node was reached by
+	via := map[string]Edge{from: {}}
+	frontier := []string{from}
+	for hop := 0; hop < maxHops && len(frontier) > 0; hop++ {
+		var next []string
+		for _, id := range frontier {
+			for _, e := range g.out[id] {
+				if _, seen := via[e.Target]; seen {
+					continue
+				}
+				via[e.Target] = e
+				if g.IsTier0(e.Target) {
+					return pathTo(via, from, e.Target), true
+				}
+				next = append(next, e.Target)
+			}
+		}
+		frontier = next
+	}
+	return nil, false
+}
+
+// pathTo walks the recorded edges back from target to from
+func pathTo(via map[string]Edge, from, target string) []Edge {
+	var path []Edge
+	for id := target; id != from; {
+		e := via[id]
+		path = append([]Edge{e}, path...)
+		id = e.Source
+	}
+	return path
+}
+
+// DistancesToTier0 returns, for every object with a path of at most
+// maxHops edges to a tier-0 object, the length of the shortest one. Tier-0
+// objects themselves are at distance 0.
+func (g *Graph) DistancesToTier0(maxHops int) map[string]int {
+	dist := make(map[string]int)
+	var frontier []string
+	seed := func(id string) {
+		if _, seen := dist[id]; !seen && g.IsTier0(id) {
+			dist[id] = 0
+			frontier = append(frontier, id)
+		}
+	}
+	for _, n := range g.order {
+		seed(strings.ToUpper(n.ObjectIdentifier))
+	}
+	for id := range g.in {
+		seed(id)
+	}
+
+	// Breadth-first against the edges, from all tier-0 objects at once
+	for hop := 1; hop <= maxHops && len(frontier) > 0; hop++ {
+		var next []string
+		for _, id := range frontier {
+			for _, e := range g.in[id] {
+				if _, seen := dist[e.Source]; seen {
+					continue
+				}
+				dist[e.Source] = hop
+				next = append(next, e.Source)
+			}
+		}
+		frontier = next
+	}
+	return dist
+}
@@ -1,8 +1,11 @@
 package bloodhound
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"hash"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -12,14 +15,23 @@ import (
 // Loader handles ingesting BloodHound JSON files
 type Loader struct {
 	Data BloodHoundData
+
+	digest hash.Hash // Over the content of every file loaded, in order
 }
 
 func NewLoader() *Loader {
 	return &Loader{
-		Data: BloodHoundData{},
+		Data:   BloodHoundData{},
+		digest: sha256.New(),
 	}
 }
 
+// Fingerprint identifies the collection loaded so far: the same files give
+// the same fingerprint, wherever they are read from
+func (l *Loader) Fingerprint() string {
+	return hex.EncodeToString(l.digest.Sum(nil))
+}
+
 // LoadFromDirectory reads all BloodHound JSON files from a directory
 func (l *Loader) LoadFromDirectory(dirPath string) error {
 	files, err := ioutil.ReadDir(dirPath)
@@ -69,6 +81,7 @@ func (l *Loader) loadFile(path string) error {
 	}
 
 	fname := strings.ToLower(filepath.Base(path))
+	l.digest.Write(bytes)
 
 	// Use case-insensitive substring matching
 	switch {
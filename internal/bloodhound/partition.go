@@ -0,0 +1,144 @@
+package bloodhound
+
+import (
+	"sort"
+	"strings"
+)
+
+// Partition is the part of a collection that belongs to one domain
+type Partition struct {
+	Domain string // Upper-case name; "" for objects of no known domain
+	Data   BloodHoundData
+	Trusts []Trust // Of the domain object, when it is loaded
+
+	// Boundary holds the edges between an object of the domain and an
+	// object of another known domain, in both directions
+	Boundary []Edge
+}
+
+// DomainOf returns the upper-case name of the domain the object with
+// identifier id belongs to, or "" when it cannot tell. Objects that are
+// not loaded are placed by their SID or by the domain prefix SharpHound
+// gives well-known principals, e.g. CORP.LOCAL-S-1-5-32-544.
+func (g *Graph) DomainOf(id string) string {
+	id = strings.ToUpper(id)
+	if n, ok := g.nodes[id]; ok {
+		if n.Kind == KindDomain {
+			return strings.ToUpper(n.Properties.Name)
+		}
+		if n.Properties.Domain != "" {
+			return strings.ToUpper(n.Properties.Domain)
+		}
+	}
+	if i := strings.LastIndex(id, "-"); strings.HasPrefix(id, "S-1-5-21-") && i > 0 {
+		return g.sids[id[:i]]
+	}
+	if domain, _, ok := strings.Cut(id, "-S-1-"); ok {
+		return domain
+	}
+	return ""
+}
+
+// TrustBetween returns a trust the domain a has towards b or b towards a,
+// as loaded from either domain object
+func (g *Graph) TrustBetween(a, b string) (Trust, bool) {
+	a, b = strings.ToUpper(a), strings.ToUpper(b)
+	for _, n := range g.order {
+		if n.Kind != KindDomain {
+			continue
+		}
+		name := strings.ToUpper(n.Properties.Name)
+		for _, t := range n.Trusts {
+			target := strings.ToUpper(t.TargetDomainName)
+			if target == "" {
+				target = g.sids[strings.ToUpper(t.TargetDomainSid)]
+			}
+			if (name == a && target == b) || (name == b && target == a) {
+				return t, true
+			}
+		}
+	}
+	return Trust{}, false
+}
+
+// Partition splits the loaded objects by domain: domains in load order,
+// then domains only named by objects, then objects of no known domain
+func (g *Graph) Partition() []*Partition {
+	byDomain := make(map[string]*Partition)
+	var parts, named []*Partition
+	part := func(domain string) *Partition {
+		p, ok := byDomain[domain]
+		if !ok {
+			p = &Partition{Domain: domain}
+			byDomain[domain] = p
+		}
+		return p
+	}
+	for _, n := range g.order {
+		if n.Kind == KindDomain {
+			p := part(strings.ToUpper(n.Properties.Name))
+			p.Trusts = n.Trusts
+			parts = append(parts, p)
+		}
+	}
+
+	for _, n := range g.order {
+		id := strings.ToUpper(n.ObjectIdentifier)
+		domain := g.DomainOf(id)
+		p, ok := byDomain[domain]
+		if !ok {
+			p = part(domain)
+			named = append(named, p)
+		}
+		p.add(n)
+
+		for _, e := range g.out[id] {
+			if other := g.DomainOf(e.Target); other != "" && domain != "" && other != domain {
+				p.Boundary = append(p.Boundary, e)
+			}
+		}
+		for _, e := range g.in[id] {
+			if other := g.DomainOf(e.Source); other != "" && domain != "" && other != domain {
+				p.Boundary = append(p.Boundary, e)
+			}
+		}
+	}
+
+	// Objects of no known domain last
+	sort.SliceStable(named, func(i, j int) bool {
+		return named[i].Domain != "" && (named[j].Domain == "" || named[i].Domain < named[j].Domain)
+	})
+	return append(parts, named...)
+}
+
+// add files n under its kind
+func (p *Partition) add(n *GraphNode) {
+	d := &p.Data
+	switch n.Kind {
+	case KindUser:
+		d.Users = append(d.Users, *n.Node)
+	case KindGroup:
+		d.Groups = append(d.Groups, *n.Node)
+	case KindComputer:
+		d.Computers = append(d.Computers, *n.Node)
+	case KindDomain:
+		d.Domains = append(d.Domains, *n.Node)
+	case KindGPO:
+		d.GPOs = append(d.GPOs, *n.Node)
+	case KindOU:
+		d.OUs = append(d.OUs, *n.Node)
+	case KindContainer:
+		d.Containers = append(d.Containers, *n.Node)
+	case KindCertTemplate:
+		d.CertTemplates = append(d.CertTemplates, *n.Node)
+	case KindEnterpriseCA:
+		d.EnterpriseCAs = append(d.EnterpriseCAs, *n.Node)
+	}
+}
+
+// Objects returns the number of objects in the partition
+func (p *Partition) Objects() int {
+	d := &p.Data
+	return len(d.Users) + len(d.Groups) + len(d.Computers) + len(d.Domains) + len(d.GPOs) +
+		len(d.OUs) + len(d.Containers) + len(d.CertTemplates) + len(d.EnterpriseCAs)
+}
@@ -0,0 +1,79 @@
+package bloodhound
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Trust is a trust of a domain towards another one, as SharpHound exports
+// it. Direction and type are numbers in older collectors and names in
+// newer ones; both decode to the name.
+type Trust struct {
+	TargetDomainSid     string         `json:"TargetDomainSid"`
+	TargetDomainName    string         `json:"TargetDomainName"`
+	IsTransitive        bool           `json:"IsTransitive"`
+	SidFilteringEnabled bool           `json:"SidFilteringEnabled"`
+	TrustDirection      TrustDirection `json:"TrustDirection"`
+	TrustType           TrustType      `json:"TrustType"`
+}
+
+// TrustDirection is Disabled, Inbound, Outbound or Bidirectional
+type TrustDirection string
+
+// TrustType is ParentChild, CrossLink, Forest, External or Unknown
+type TrustType string
+
+var (
+	trustDirections = []string{"Disabled", "Inbound", "Outbound", "Bidirectional"}
+	trustTypes      = []string{"ParentChild", "CrossLink", "Forest", "External", "Unknown"}
+)
+
+func (d *TrustDirection) UnmarshalJSON(data []byte) error {
+	name, err := enumName(data, trustDirections)
+	*d = TrustDirection(name)
+	return err
+}
+
+func (t *TrustType) UnmarshalJSON(data []byte) error {
+	name, err := enumName(data, trustTypes)
+	*t = TrustType(name)
+	return err
+}
+
+// enumName decodes a name, or a number indexing names
+func enumName(data []byte, names []string) (string, error) {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		return name, nil
+	}
+	var i int
+	if err := json.Unmarshal(data, &i); err != nil {
+		return "", fmt.Errorf("expected a name or a number, got %s", data)
+	}
+	if i < 0 || i >= len(names) {
+		return fmt.Sprint(i), nil
+	}
+	return names[i], nil
+}
+
+// String describes the trust, e.g. "ParentChild, Bidirectional,
+// transitive, SID filtering"
+func (t Trust) String() string {
+	parts := []string{string(t.TrustType), string(t.TrustDirection)}
+	if t.IsTransitive {
+		parts = append(parts, "transitive")
+	}
+	if t.SidFilteringEnabled {
+		parts = append(parts, "SID filtering")
+	} else {
+		parts = append(parts, "no SID filtering")
+	}
+	var out []string
+	for _, p := range parts {
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	return strings.Join(out, ", ")
+}
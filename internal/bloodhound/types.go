@@ -18,6 +18,9 @@ type Node struct {
 	ObjectIdentifier string     `json:"ObjectIdentifier"`
 	Properties       Properties `json:"Properties"`
 	Aces             []Ace      `json:"Aces,omitempty"`
+	Members          []Member   `json:"Members,omitempty"` // Groups only
+	Links            []Link     `json:"Links,omitempty"`   // OUs and domains: the GPOs linked to them
+	Trusts           []Trust    `json:"Trusts,omitempty"`  // Domains only
 	IsDeleted        bool       `json:"IsDeleted,omitempty"`
 }
 
@@ -35,6 +38,16 @@ type Properties struct {
 	OperatingSystem string `json:"operatingsystem,omitempty"`
 }
 
+type Member struct {
+	ObjectIdentifier string `json:"ObjectIdentifier"`
+	ObjectType       string `json:"ObjectType"`
+}
+
+type Link struct {
+	GUID       string `json:"GUID"` // Object identifier of the linked GPO
+	IsEnforced bool   `json:"IsEnforced"`
+}
+
 type Ace struct {
 	PrincipalSID  string `json:"PrincipalSID"`
 	PrincipalType string `json:"PrincipalType"`
@@ -0,0 +1,418 @@
+package cache
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ad-necromancer/internal/ai"
+	"ad-necromancer/internal/privacy"
+)
+
+// Defaults used when the configuration leaves a limit unset
+const (
+	DefaultTTL     = 24 * time.Hour
+	DefaultMaxSize = 256 << 20 // 256 MiB
+)
+
+// DefaultDir is where responses are cached
+var DefaultDir = filepath.Join(".necromancer", "cache")
+
+// KeyEnv names the variable holding the passphrase entries are encrypted
+// with. Without it a random key is generated and kept next to the entries,
+// where it protects them from nothing but a copy of the entries alone.
+const KeyEnv = "NECROMANCER_CACHE_KEY"
+
+const (
+	entrySuffix = ".bin"
+	keyFile     = "key"
+	saltFile    = "salt"
+)
+
+// Store is an on-disk response cache. Entries are AES-256-GCM encrypted;
+// because the engine tokenizes before calling the client, a run with the
+// Privacy Cloak on only ever caches tokenized prompts and responses.
+type Store struct {
+	Dir     string
+	TTL     time.Duration
+	MaxSize int64     // Total bytes kept on disk; the oldest entries are evicted first
+	Notify  io.Writer // Receives notices, such as a response that could not be cached
+
+	aead      cipher.AEAD
+	keyOnDisk bool
+	salt      string
+	mu        sync.Mutex // serializes writes and eviction
+	hits      atomic.Int64
+	misses    atomic.Int64
+}
+
+// Open creates dir if needed, loads or creates the encryption key and
+// removes expired entries
+func Open(dir string, ttl time.Duration, maxSize int64) (*Store, error) {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	if err := privacy.EnsureIgnored("cache/"); err != nil {
+		return nil, err
+	}
+
+	key, keyOnDisk, err := loadKey(dir)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize cache cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize cache cipher: %w", err)
+	}
+
+	s := &Store{Dir: dir, TTL: ttl, MaxSize: maxSize, Notify: io.Discard, aead: aead, keyOnDisk: keyOnDisk}
+	if s.salt, err = s.loadSalt(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.evict(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// loadKey derives the key from KeyEnv, or reads (creating on first use) a
+// random key stored in the cache directory. onDisk reports the latter.
+func loadKey(dir string) (key []byte, onDisk bool, err error) {
+	if passphrase := os.Getenv(KeyEnv); passphrase != "" {
+		sum := sha256.Sum256([]byte(passphrase))
+		return sum[:], false, nil
+	}
+
+	path := filepath.Join(dir, keyFile)
+	key, err = os.ReadFile(path)
+	if err == nil {
+		if len(key) != 32 {
+			return nil, true, fmt.Errorf("cache key %s is corrupt; delete the cache directory to reset it", path)
+		}
+		return key, true, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, true, fmt.Errorf("failed to read cache key: %w", err)
+	}
+
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, true, fmt.Errorf("failed to generate cache key: %w", err)
+	}
+	if err := os.WriteFile(path, key, 0600); err != nil {
+		return nil, true, fmt.Errorf("failed to write cache key: %w", err)
+	}
+	return key, true, nil
+}
+
+// KeyOnDisk reports whether the key is the one kept in the cache directory
+// because KeyEnv is unset. Anyone who can read the entries can then read
+// the key too, so they are obscured rather than protected.
+func (s *Store) KeyOnDisk() bool {
+	return s.keyOnDisk
+}
+
+// loadSalt reads (creating on first use) the tokenizer salt kept in the
+// cache directory, sealed with the cache key like the entries
+func (s *Store) loadSalt() (string, error) {
+	path := filepath.Join(s.Dir, saltFile)
+	if sealed, err := os.ReadFile(path); err == nil {
+		n := s.aead.NonceSize()
+		if len(sealed) > n {
+			if salt, err := s.aead.Open(nil, sealed[:n], sealed[n:], nil); err == nil {
+				return string(salt), nil
+			}
+		}
+		// Sealed with another key: the entries are unreadable too, so
+		// start over with a new salt
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("failed to read cache salt: %w", err)
+	}
+
+	salt := privacy.NewSalt()
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	if err := os.WriteFile(path, s.aead.Seal(nonce, nonce, []byte(salt), nil), 0600); err != nil {
+		return "", fmt.Errorf("failed to write cache salt: %w", err)
+	}
+	return salt, nil
+}
+
+// TokenSalt returns the salt the Privacy Cloak tokenizes the given
+// collection with on cached runs. A tokenizer salted per run would give
+// every cloaked prompt new tokens, so the cache could never hit; one salt
+// for every collection would give a name the same token in every
+// engagement. The salt is derived from the collection's fingerprint with
+// the cache's own secret salt, so only a re-run of the same collection
+// reuses it.
+func (s *Store) TokenSalt(collection string) string {
+	mac := hmac.New(sha256.New, []byte(s.salt))
+	mac.Write([]byte(collection))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Stats returns the number of hits and misses so far
+func (s *Store) Stats() (hits, misses int64) {
+	return s.hits.Load(), s.misses.Load()
+}
+
+// Wrap returns a client that answers from the cache when it can. backend
+// and model become part of the key, so the same prompt sent to another
+// model is a miss.
+func (s *Store) Wrap(client ai.AIClient, backend, model string) ai.AIClient {
+	return &cachingClient{AIClient: client, store: s, backend: backend, model: model}
+}
+
+// entry is the plaintext of a cached response
+type entry struct {
+	CreatedAt    time.Time     `json:"created_at"`
+	Backend      string        `json:"backend"`
+	Model        string        `json:"model"`
+	Messages     []ai.Message  `json:"messages"`
+	Text         string        `json:"text"`
+	ToolCalls    []ai.ToolCall `json:"tool_calls,omitempty"`
+	FinishReason string        `json:"finish_reason"`
+	Usage        ai.Usage      `json:"usage"`
+}
+
+// keyFields are hashed into an entry's name
+type keyFields struct {
+	Backend     string       `json:"backend"`
+	Model       string       `json:"model"`
+	Temperature float64      `json:"temperature"`
+	MaxTokens   int          `json:"max_tokens"`
+	Seed        *int         `json:"seed"`
+	Stop        []string     `json:"stop"`
+	Schema      string       `json:"schema"`
+	Tools       []string     `json:"tools,omitempty"` // Names of the tools offered
+	Messages    []ai.Message `json:"messages"`        // System prompt, user prompt and any follow-up turns
+}
+
+// Key returns the cache key for a request to backend/model
+func Key(backend, model string, req *ai.ChatRequest) string {
+	fields := keyFields{
+		Backend:     backend,
+		Model:       model,
+		Temperature: req.Options.TemperatureOrDefault(),
+		MaxTokens:   req.Options.MaxTokensOrDefault(),
+		Seed:        req.Options.Seed,
+		Stop:        req.Options.Stop,
+		Messages:    req.Messages,
+	}
+	if req.Options.Schema != nil {
+		fields.Schema = req.Options.Schema.Name
+	}
+	for _, tool := range req.Tools {
+		fields.Tools = append(fields.Tools, tool.Name)
+	}
+	data, _ := json.Marshal(fields) // plain strings and numbers always marshal
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// get returns the live entry for key, if any
+func (s *Store) get(key string) (*entry, bool) {
+	path := filepath.Join(s.Dir, key+entrySuffix)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, false
+	}
+	if time.Since(info.ModTime()) > s.TTL {
+		os.Remove(path)
+		return nil, false
+	}
+
+	sealed, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	e, err := s.open(sealed)
+	if err != nil {
+		// Written with another key or damaged; it will be replaced
+		os.Remove(path)
+		return nil, false
+	}
+	return e, true
+}
+
+// put stores e under key and evicts entries beyond the size limit
+func (s *Store) put(key string, e *entry) error {
+	sealed, err := s.seal(e)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := filepath.Join(s.Dir, key+entrySuffix)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, sealed, 0600); err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	return s.evict()
+}
+
+func (s *Store) seal(e *entry) ([]byte, error) {
+	plaintext, err := json.Marshal(e)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal cache entry: %w", err)
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return s.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (s *Store) open(sealed []byte) (*entry, error) {
+	n := s.aead.NonceSize()
+	if len(sealed) < n {
+		return nil, fmt.Errorf("cache entry too short")
+	}
+	plaintext, err := s.aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return nil, err
+	}
+	var e entry
+	if err := json.Unmarshal(plaintext, &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// evict removes expired entries, then the oldest ones until the cache fits
+// in MaxSize; callers hold s.mu
+func (s *Store) evict() error {
+	dirEntries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to read cache directory: %w", err)
+	}
+
+	type file struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []file
+	var total int64
+	for _, de := range dirEntries {
+		if de.IsDir() || !strings.HasSuffix(de.Name(), entrySuffix) {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(s.Dir, de.Name())
+		if time.Since(info.ModTime()) > s.TTL {
+			os.Remove(path)
+			continue
+		}
+		files = append(files, file{path, info.Size(), info.ModTime()})
+		total += info.Size()
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	for _, f := range files {
+		if total <= s.MaxSize {
+			break
+		}
+		if err := os.Remove(f.path); err == nil {
+			total -= f.size
+		}
+	}
+	return nil
+}
+
+// cachingClient answers from the store and fills it from the wrapped client
+type cachingClient struct {
+	ai.AIClient
+	store   *Store
+	backend string
+	model   string
+}
+
+// Summon implements the AIClient interface
+func (c *cachingClient) Summon(systemPrompt, userPrompt string) (string, error) {
+	return ai.Summon(c, systemPrompt, userPrompt)
+}
+
+// Chat implements the AIClient interface. Only complete, successful
+// responses are cached; errors and truncated output are always retried.
+// A hit consumed no tokens, so it reports zero usage.
+func (c *cachingClient) Chat(ctx context.Context, req *ai.ChatRequest) (*ai.ChatResponse, error) {
+	key := Key(c.backend, c.model, req)
+	if e, ok := c.store.get(key); ok {
+		c.store.hits.Add(1)
+		return &ai.ChatResponse{
+			Text:         e.Text,
+			FinishReason: e.FinishReason,
+			Model:        e.Model,
+			ToolCalls:    e.ToolCalls,
+		}, nil
+	}
+	c.store.misses.Add(1)
+
+	resp, err := c.AIClient.Chat(ctx, req)
+	if err != nil || resp.FinishReason == ai.FinishLength || resp.FinishReason == ai.FinishContentFilter {
+		return resp, err
+	}
+
+	model := resp.Model
+	if model == "" {
+		model = c.model
+	}
+	if err := c.store.put(key, &entry{
+		CreatedAt:    time.Now().UTC(),
+		Backend:      c.backend,
+		Model:        model,
+		Messages:     req.Messages,
+		Text:         resp.Text,
+		ToolCalls:    resp.ToolCalls,
+		FinishReason: resp.FinishReason,
+		Usage:        resp.Usage,
+	}); err != nil {
+		// The cache is an optimization; the response is still good
+		fmt.Fprintf(c.store.Notify, "[!] Failed to cache response: %v\n", err)
+	}
+	return resp, nil
+}
+
+// SupportsTools implements ai.ToolUser for the wrapped client
+func (c *cachingClient) SupportsTools() bool {
+	return ai.SupportsTools(c.AIClient)
+}
@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ad-necromancer/internal/ai"
+	"ad-necromancer/internal/privacy"
+)
+
+// countingClient answers every call and counts them
+type countingClient struct {
+	calls int
+}
+
+func (c *countingClient) Chat(ctx context.Context, req *ai.ChatRequest) (*ai.ChatResponse, error) {
+	c.calls++
+	return &ai.ChatResponse{Text: "[]", FinishReason: ai.FinishStop}, nil
+}
+
+func (c *countingClient) Summon(systemPrompt, userPrompt string) (string, error) {
+	return ai.Summon(c, systemPrompt, userPrompt)
+}
+
+// run tokenizes a prompt the way a cloaked run of collection does and sends
+// it through a freshly opened store
+func run(t *testing.T, dir, collection string, upstream *countingClient) {
+	t.Helper()
+	store, err := Open(dir, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenizer := privacy.NewTokenizerWithSalt(store.TokenSalt(collection))
+	prompt := "Analyse " + tokenizer.TokenizeUser("svc_backup_legacy@corp.local") +
+		" in " + tokenizer.TokenizeDomain("corp.local")
+
+	client := store.Wrap(upstream, "test", "model")
+	req := &ai.ChatRequest{Messages: []ai.Message{{Role: ai.RoleUser, Content: prompt}}}
+	if _, err := client.Chat(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCloakedRerunHitsCache(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv(KeyEnv, "")
+	dir := filepath.Join(".necromancer", "cache")
+
+	upstream := &countingClient{}
+	run(t, dir, "collection-a", upstream)
+	run(t, dir, "collection-a", upstream)
+	if upstream.calls != 1 {
+		t.Errorf("backend called %d times over two runs, want 1", upstream.calls)
+	}
+
+	// Another collection tokenizes the same names differently, so tokens
+	// cannot be linked across engagements
+	run(t, dir, "collection-b", upstream)
+	if upstream.calls != 2 {
+		t.Errorf("another collection hit the cache: %d calls, want 2", upstream.calls)
+	}
+}
+
+func TestSaltSurvivesReopen(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv(KeyEnv, "")
+	dir := filepath.Join(".necromancer", "cache")
+
+	first, err := Open(dir, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Open(dir, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.TokenSalt("c") != second.TokenSalt("c") {
+		t.Errorf("salt changed between runs: %q, %q", first.TokenSalt("c"), second.TokenSalt("c"))
+	}
+	if first.TokenSalt("c") == first.TokenSalt("d") {
+		t.Errorf("two collections share a salt")
+	}
+	if !first.KeyOnDisk() {
+		t.Errorf("KeyOnDisk() = false without %s", KeyEnv)
+	}
+
+	// Another key cannot read the salt; a new one replaces it
+	t.Setenv(KeyEnv, "another passphrase")
+	third, err := Open(dir, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.TokenSalt("c") == first.TokenSalt("c") {
+		t.Errorf("salt sealed with the old key was reused")
+	}
+	if third.KeyOnDisk() {
+		t.Errorf("KeyOnDisk() = true with %s set", KeyEnv)
+	}
+}
@@ -1,28 +1,37 @@
 package claude
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"sync/atomic"
+	"time"
+
+	"ad-necromancer/internal/ai"
+	"ad-necromancer/internal/transport"
 )
 
 const (
-	apiEndpoint  = "https://api.anthropic.com/v1/messages"
-	defaultModel = "claude-3-5-sonnet-20241022"
-	apiVersion   = "2023-06-01"
+	DefaultBaseURL = "https://api.anthropic.com/v1"
+	defaultModel   = "claude-3-5-sonnet-20241022"
+	apiVersion     = "2023-06-01"
 )
 
 type Client struct {
-	APIKey string
-	Model  string
+	BaseURL   string // Messages API root; /messages and /models are appended
+	APIKey    string // Sent as x-api-key when set
+	Model     string
+	Transport *transport.Client
+
+	toolsUnsupported atomic.Bool // set once the model rejects forced tool use
 }
 
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"` // Text, or []ContentBlock for tool use
 }
 
 type MessagesRequest struct {
@@ -30,13 +39,47 @@ type MessagesRequest struct {
 	MaxTokens   int       `json:"max_tokens"`
 	System      string    `json:"system"`
 	Messages    []Message `json:"messages"`
-	Temperature float64   `json:"temperature,omitempty"`
+	Temperature float64   `json:"temperature"`
+	Stop        []string  `json:"stop_sequences,omitempty"`
+
+	// Tools the model may call. Structured output adds a tool whose
+	// input_schema is the output schema, and a tool_choice forcing the
+	// model to call it.
+	Tools      []Tool      `json:"tools,omitempty"`
+	ToolChoice *ToolChoice `json:"tool_choice,omitempty"`
+}
+
+type Tool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema map[string]interface{} `json:"input_schema"`
+}
+
+type ToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
 }
 
 type MessagesResponse struct {
-	Content []struct {
-		Text string `json:"text"`
-	} `json:"content"`
+	Model      string         `json:"model"`
+	Content    []ContentBlock `json:"content"`
+	StopReason string         `json:"stop_reason"`
+	Usage      struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+}
+
+type ContentBlock struct {
+	Type  string          `json:"type"`
+	Text  string          `json:"text,omitempty"`
+	ID    string          `json:"id,omitempty"` // tool_use
+	Name  string          `json:"name,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
+
+	// tool_result
+	ToolUseID string `json:"tool_use_id,omitempty"`
+	Content   string `json:"content,omitempty"`
 }
 
 // NewClient creates a new Claude client
@@ -52,57 +95,215 @@ func NewClient() (*Client, error) {
 	}
 
 	return &Client{
-		APIKey: apiKey,
-		Model:  model,
+		BaseURL:   DefaultBaseURL,
+		APIKey:    apiKey,
+		Model:     model,
+		Transport: transport.New("Claude", 180*time.Second),
 	}, nil
 }
 
+func init() {
+	ai.Register(ai.Backend{
+		Name:        "claude",
+		Description: "Anthropic Claude",
+		ModelEnv:    "CLAUDE_MODEL",
+		Config: []ai.ConfigKey{
+			{Env: "CLAUDE_API_KEY", Description: "API key", Required: true, Secret: true},
+			{Env: "CLAUDE_MODEL", Description: "Model id", Default: defaultModel},
+		},
+		Capabilities: ai.Capabilities{Structured: "forced tool call", Tools: true},
+		New: func(cfg ai.Config) (ai.AIClient, error) {
+			c, err := NewClient()
+			if err != nil {
+				return nil, err
+			}
+			if cfg.Model != "" {
+				c.Model = cfg.Model
+			}
+			return c, nil
+		},
+	})
+}
+
 // Summon implements the AIClient interface
 func (c *Client) Summon(systemPrompt, userPrompt string) (string, error) {
+	return ai.Summon(c, systemPrompt, userPrompt)
+}
+
+// Chat implements the AIClient interface. A schema is applied by forcing a
+// tool call whose input_schema is the requested schema; the tool input is
+// returned as the response text. Claude has no seed parameter, so
+// Options.Seed is ignored.
+func (c *Client) Chat(ctx context.Context, req *ai.ChatRequest) (*ai.ChatResponse, error) {
+	return ai.ChatWithSchemaFallback(ctx, &c.toolsUnsupported, req, c.chat)
+}
+
+// schemaErrorTerms mark a 400 as a refusal of the forced schema tool
+var schemaErrorTerms = []string{"input_schema", "tool_choice", "schema"}
+
+func (c *Client) chat(ctx context.Context, req *ai.ChatRequest, schema *ai.Schema) (*ai.ChatResponse, error) {
+	systemPrompt, turns := ai.SplitSystem(req.Messages)
+
 	reqBody := MessagesRequest{
-		Model:     c.Model,
-		MaxTokens: 8000,
-		System:    systemPrompt,
-		Messages: []Message{
-			{Role: "user", Content: userPrompt},
-		},
-		Temperature: 0.7,
+		Model:       c.Model,
+		MaxTokens:   req.Options.MaxTokensOrDefault(),
+		System:      systemPrompt,
+		Messages:    messages(turns),
+		Temperature: req.Options.TemperatureOrDefault(),
+		Stop:        req.Options.Stop,
+	}
+	for _, tool := range req.Tools {
+		reqBody.Tools = append(reqBody.Tools, Tool{
+			Name:        tool.Name,
+			Description: tool.Description,
+			InputSchema: tool.Parameters,
+		})
+	}
+	if schema != nil {
+		reqBody.Tools = append(reqBody.Tools, Tool{
+			Name:        schema.Name,
+			Description: schema.Description,
+			InputSchema: schema.Definition,
+		})
+		reqBody.ToolChoice = &ToolChoice{Type: "tool", Name: schema.Name}
 	}
 
-	jsonData, err := json.Marshal(reqBody)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+	var result MessagesResponse
+	if err := c.Transport.PostJSON(ctx, c.BaseURL+"/messages", c.headers(), reqBody, &result); err != nil {
+		if schema != nil && transport.IsBadRequestAbout(err, schemaErrorTerms...) {
+			return nil, &ai.SchemaRejectedError{Err: err}
+		}
+		if len(req.Tools) > 0 && transport.IsStatus(err, http.StatusBadRequest) {
+			return nil, &ai.ToolsRejectedError{Err: err}
+		}
+		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", apiEndpoint, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+	if len(result.Content) == 0 {
+		return nil, fmt.Errorf("no response from Claude")
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("x-api-key", c.APIKey)
-	req.Header.Set("anthropic-version", apiVersion)
+	reply := &ai.ChatResponse{
+		FinishReason: finishReason(result.StopReason),
+		Model:        result.Model,
+		Usage: ai.Usage{
+			PromptTokens:     result.Usage.InputTokens,
+			CompletionTokens: result.Usage.OutputTokens,
+		},
+	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to call Claude API: %w", err)
+	// A forced tool call carries the structured document as its input
+	for _, block := range result.Content {
+		if schema != nil && block.Type == "tool_use" && block.Name == schema.Name && len(block.Input) > 0 {
+			reply.Text = string(block.Input)
+			if reply.FinishReason == ai.FinishToolUse {
+				reply.FinishReason = ai.FinishStop // the forced call is the answer
+			}
+			return reply, nil
+		}
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("Claude API returned status %d: %s", resp.StatusCode, string(body))
+	for _, block := range result.Content {
+		switch block.Type {
+		case "text":
+			if reply.Text == "" {
+				reply.Text = block.Text
+			}
+		case "tool_use":
+			reply.ToolCalls = append(reply.ToolCalls, ai.ToolCall{ID: block.ID, Name: block.Name, Arguments: string(block.Input)})
+		}
 	}
+	return reply, nil
+}
 
-	var result MessagesResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
+// messages converts conversation turns to the Messages API format. Tool
+// calls become tool_use blocks, and the results answering them are sent
+// together as tool_result blocks of the next user turn.
+func messages(turns []ai.Message) []Message {
+	out := make([]Message, 0, len(turns))
+	for _, m := range turns {
+		switch {
+		case m.Role == ai.RoleTool:
+			block := ContentBlock{Type: "tool_result", ToolUseID: m.ToolCallID, Content: m.Content}
+			if last := len(out) - 1; last >= 0 && out[last].Role == ai.RoleUser {
+				if blocks, ok := out[last].Content.([]ContentBlock); ok {
+					out[last].Content = append(blocks, block)
+					continue
+				}
+			}
+			out = append(out, Message{Role: ai.RoleUser, Content: []ContentBlock{block}})
+		case len(m.ToolCalls) > 0:
+			var blocks []ContentBlock
+			if m.Content != "" {
+				blocks = append(blocks, ContentBlock{Type: "text", Text: m.Content})
+			}
+			for _, call := range m.ToolCalls {
+				input := json.RawMessage(call.Arguments)
+				if !json.Valid(input) {
+					input = json.RawMessage("{}")
+				}
+				blocks = append(blocks, ContentBlock{Type: "tool_use", ID: call.ID, Name: call.Name, Input: input})
+			}
+			out = append(out, Message{Role: m.Role, Content: blocks})
+		default:
+			out = append(out, Message{Role: m.Role, Content: m.Content})
+		}
 	}
+	return out
+}
 
-	if len(result.Content) == 0 {
-		return "", fmt.Errorf("no response from Claude")
+// SupportsTools implements ai.ToolUser
+func (c *Client) SupportsTools() bool {
+	return true
+}
+
+func (c *Client) headers() map[string]string {
+	headers := map[string]string{"anthropic-version": apiVersion}
+	if c.APIKey != "" {
+		headers["x-api-key"] = c.APIKey
 	}
+	return headers
+}
+
+// finishReason maps Claude stop reasons onto the ai.Finish* constants
+func finishReason(reason string) string {
+	switch reason {
+	case "end_turn", "stop_sequence":
+		return ai.FinishStop
+	case "max_tokens":
+		return ai.FinishLength
+	case "tool_use":
+		return ai.FinishToolUse
+	case "refusal":
+		return ai.FinishContentFilter
+	}
+	return reason
+}
+
+// ModelInfo is the GET /v1/models/{id} response
+type ModelInfo struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"display_name"`
+}
 
-	return result.Content[0].Text, nil
+// Probe implements ai.Prober by looking the model up, which checks the API
+// key and the model id without a completion
+func (c *Client) Probe(ctx context.Context) (*ai.ProbeResult, error) {
+	body, err := c.Transport.Do(ctx, http.MethodGet, c.BaseURL+"/models/"+url.PathEscape(c.Model), c.headers(), nil)
+	if transport.IsStatus(err, http.StatusNotFound) {
+		return nil, fmt.Errorf("%w: Claude has no model %q", ai.ErrModelNotFound, c.Model)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var info ModelInfo
+	if err := json.Unmarshal(body, &info); err != nil {
+		return nil, fmt.Errorf("failed to decode Claude model info: %w", err)
+	}
+	result := &ai.ProbeResult{Model: info.ID}
+	if info.DisplayName != "" {
+		result.Notes = append(result.Notes, info.DisplayName)
+	}
+	return result, nil
 }
@@ -0,0 +1,190 @@
+package claude
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"ad-necromancer/internal/ai"
+	"ad-necromancer/internal/transport"
+)
+
+// testServer decodes each Messages API request into requests and answers
+// it with handle
+func testServer(t *testing.T, handle func(w http.ResponseWriter, r *http.Request, req MessagesRequest)) (*Client, *[]MessagesRequest) {
+	t.Helper()
+	var requests []MessagesRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req MessagesRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		requests = append(requests, req)
+		handle(w, r, req)
+	}))
+	t.Cleanup(srv.Close)
+
+	tr := transport.New("Claude", 5*time.Second)
+	tr.HTTPClient.Transport = &http.Transport{}
+	tr.BaseDelay = time.Millisecond
+	return &Client{BaseURL: srv.URL, APIKey: "test-key", Model: "claude-test", Transport: tr}, &requests
+}
+
+func respond(w http.ResponseWriter, stopReason string, content ...ContentBlock) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"model":       "claude-test-20250101",
+		"content":     content,
+		"stop_reason": stopReason,
+		"usage":       map[string]int{"input_tokens": 120, "output_tokens": 30},
+	})
+}
+
+func schemaRequest() *ai.ChatRequest {
+	temperature := 0.2
+	return &ai.ChatRequest{
+		Messages: []ai.Message{
+			{Role: ai.RoleSystem, Content: "system"},
+			{Role: ai.RoleUser, Content: "user"},
+		},
+		Options: ai.Options{
+			Temperature: &temperature,
+			MaxTokens:   1000,
+			Stop:        []string{"END"},
+			Schema: &ai.Schema{
+				Name:         "findings",
+				Description:  "Zombie paths",
+				Definition:   map[string]interface{}{"type": "object"},
+				Instructions: "Reply with a findings object.",
+			},
+		},
+	}
+}
+
+func TestChatForcesSchemaTool(t *testing.T) {
+	c, requests := testServer(t, func(w http.ResponseWriter, r *http.Request, req MessagesRequest) {
+		if r.URL.Path != "/messages" || r.Header.Get("x-api-key") != "test-key" || r.Header.Get("anthropic-version") != apiVersion {
+			http.Error(w, "bad request line or headers", http.StatusUnauthorized)
+			return
+		}
+		respond(w, "tool_use", ContentBlock{Type: "tool_use", ID: "toolu_1", Name: "findings", Input: json.RawMessage(`{"findings":[]}`)})
+	})
+
+	resp, err := c.Chat(context.Background(), schemaRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := (*requests)[0]
+	if req.Model != "claude-test" || req.System != "system" || req.MaxTokens != 1000 || req.Temperature != 0.2 {
+		t.Errorf("request = %+v", req)
+	}
+	if !reflect.DeepEqual(req.Stop, []string{"END"}) {
+		t.Errorf("stop_sequences = %v", req.Stop)
+	}
+	if len(req.Messages) != 1 || req.Messages[0].Role != ai.RoleUser || req.Messages[0].Content != "user" {
+		t.Errorf("messages = %+v, want the user turn without the system prompt", req.Messages)
+	}
+	if len(req.Tools) != 1 || req.Tools[0].Name != "findings" || req.Tools[0].InputSchema["type"] != "object" {
+		t.Errorf("tools = %+v, want the schema as a tool", req.Tools)
+	}
+	if req.ToolChoice == nil || *req.ToolChoice != (ToolChoice{Type: "tool", Name: "findings"}) {
+		t.Errorf("tool_choice = %+v, want the schema tool forced", req.ToolChoice)
+	}
+
+	want := &ai.ChatResponse{
+		Text:         `{"findings":[]}`,
+		FinishReason: ai.FinishStop,
+		Model:        "claude-test-20250101",
+		Usage:        ai.Usage{PromptTokens: 120, CompletionTokens: 30},
+	}
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("response = %+v, want %+v", resp, want)
+	}
+}
+
+func TestChatSchemaFallback(t *testing.T) {
+	c, requests := testServer(t, func(w http.ResponseWriter, r *http.Request, req MessagesRequest) {
+		if req.ToolChoice != nil {
+			http.Error(w, `{"type":"error","error":{"type":"invalid_request_error","message":"tool_choice is not supported"}}`, http.StatusBadRequest)
+			return
+		}
+		respond(w, "end_turn", ContentBlock{Type: "text", Text: "[]"})
+	})
+
+	for range 2 {
+		resp, err := c.Chat(context.Background(), schemaRequest())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Text != "[]" || resp.FinishReason != ai.FinishStop {
+			t.Errorf("response = %+v", resp)
+		}
+	}
+
+	if len(*requests) != 3 {
+		t.Fatalf("%d requests, want the forced tool once then prompt-only twice", len(*requests))
+	}
+	for _, req := range (*requests)[1:] {
+		if len(req.Tools) != 0 || req.ToolChoice != nil {
+			t.Errorf("prompt-only request still has tools: %+v", req.Tools)
+		}
+		if !strings.HasSuffix(req.System, "Reply with a findings object.") {
+			t.Errorf("system = %q, want the schema instructions", req.System)
+		}
+	}
+}
+
+func TestChatMapsToolCalls(t *testing.T) {
+	c, requests := testServer(t, func(w http.ResponseWriter, r *http.Request, req MessagesRequest) {
+		respond(w, "tool_use",
+			ContentBlock{Type: "text", Text: "Looking it up."},
+			ContentBlock{Type: "tool_use", ID: "toolu_2", Name: "lookup", Input: json.RawMessage(`{"name":"svc_backup"}`)})
+	})
+
+	req := &ai.ChatRequest{
+		Messages: []ai.Message{
+			{Role: ai.RoleUser, Content: "Who is svc_sql?"},
+			{Role: ai.RoleAssistant, ToolCalls: []ai.ToolCall{
+				{ID: "toolu_0", Name: "lookup", Arguments: `{"name":"svc_sql"}`},
+				{ID: "toolu_1", Name: "members", Arguments: `not json`},
+			}},
+			{Role: ai.RoleTool, ToolCallID: "toolu_0", ToolName: "lookup", Content: `{"enabled":true}`},
+			{Role: ai.RoleTool, ToolCallID: "toolu_1", ToolName: "members", Content: `[]`},
+		},
+		Tools: []ai.Tool{{Name: "lookup", Description: "Look up a principal", Parameters: map[string]interface{}{"type": "object"}}},
+	}
+	resp, err := c.Chat(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent := (*requests)[0]
+	if len(sent.Tools) != 1 || sent.Tools[0].Name != "lookup" || sent.ToolChoice != nil {
+		t.Errorf("tools = %+v, tool_choice = %+v", sent.Tools, sent.ToolChoice)
+	}
+	if len(sent.Messages) != 3 {
+		t.Fatalf("messages = %+v, want the results grouped in one user turn", sent.Messages)
+	}
+	calls, _ := json.Marshal(sent.Messages[1].Content)
+	if !strings.Contains(string(calls), `"input":{"name":"svc_sql"}`) || !strings.Contains(string(calls), `"input":{}`) {
+		t.Errorf("assistant turn = %s, want tool_use blocks with malformed input sent as {}", calls)
+	}
+	results, _ := json.Marshal(sent.Messages[2].Content)
+	if sent.Messages[2].Role != ai.RoleUser || strings.Count(string(results), `"type":"tool_result"`) != 2 {
+		t.Errorf("results turn = %s, want two tool_result blocks", results)
+	}
+
+	if resp.Text != "Looking it up." || resp.FinishReason != ai.FinishToolUse {
+		t.Errorf("response = %+v", resp)
+	}
+	if len(resp.ToolCalls) != 1 || resp.ToolCalls[0] != (ai.ToolCall{ID: "toolu_2", Name: "lookup", Arguments: `{"name":"svc_backup"}`}) {
+		t.Errorf("tool calls = %+v", resp.ToolCalls)
+	}
+}
@@ -0,0 +1,670 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"ad-necromancer/internal/pricing"
+	"ad-necromancer/internal/ratelimit"
+)
+
+// Privacy policies
+const (
+	PrivacyAuto = "auto" // Cloak on for remote backends, off for local ones
+	PrivacyOn   = "on"   // Always tokenize, even for local backends
+	PrivacyOff  = "off"  // Never tokenize
+)
+
+// Output formats
+const (
+	FormatText = "text" // Coloured terminal report
+	FormatJSON = "json" // Findings as a JSON array on stdout; progress goes to stderr
+)
+
+// ProjectFile is looked up in the working directory before the user config
+const ProjectFile = "necromancer.yaml"
+
+// File is the on-disk configuration: a set of named profiles
+type File struct {
+	DefaultProfile string             `yaml:"default_profile"`
+	Profiles       map[string]Profile `yaml:"profiles"`
+
+	// Prices adds to or corrects the built-in price table, in USD per
+	// million tokens by model id
+	Prices pricing.Table `yaml:"prices"`
+
+	Path string `yaml:"-"` // Where the file was loaded from
+}
+
+// Profile is a named set of run settings. Unset fields fall through to
+// the environment and built-in defaults.
+type Profile struct {
+	Backend     string        `yaml:"backend"`
+	Model       string        `yaml:"model"`
+	Temperature *float64      `yaml:"temperature"`
+	MaxTokens   int           `yaml:"max_tokens"`
+	Seed        *int          `yaml:"seed"`
+	Privacy     string        `yaml:"privacy"` // auto, on or off
+	SaveMapping *bool         `yaml:"save_mapping"`
+	Record      *bool         `yaml:"record"` // Keep a transcript of every model call
+	MaxAttempts int           `yaml:"max_attempts"`
+	Timeout     time.Duration `yaml:"timeout"`
+	CallTimeout time.Duration `yaml:"call_timeout"`
+	Budget      Budget        `yaml:"budget"`
+	Sampling    Sampling      `yaml:"sampling"`
+	Output      Output        `yaml:"output"`
+	Cache       Cache         `yaml:"cache"`
+	Network     Network       `yaml:"network"`
+	Agent       Agent         `yaml:"agent"`
+	Triage      Triage        `yaml:"triage"`
+	Focus       Focus         `yaml:"focus"`
+	Workers     int           `yaml:"workers"` // Model calls in flight at once
+
+	// PerDomain analyses each domain of the collection on its own, with
+	// the edges crossing into other domains listed alongside
+	PerDomain *bool `yaml:"per_domain"`
+
+	// RateLimits caps requests and tokens per minute by backend name
+	// (openai, claude, ...), shared by every model of that backend
+	RateLimits map[string]ratelimit.Limits `yaml:"rate_limits"`
+
+	// Fallback lists backends tried in order when the main one fails
+	Fallback []BackendRef `yaml:"fallback"`
+
+	// Ensemble lists backends that all analyse the same data; when set it
+	// replaces backend and fallback
+	Ensemble []BackendRef `yaml:"ensemble"`
+
+	// Env supplies backend variables (endpoints, deployments, ...) that are
+	// not already set in the environment
+	Env map[string]string `yaml:"env"`
+}
+
+// BackendRef names a backend and optionally the model to use with it
+type BackendRef struct {
+	Backend string `yaml:"backend"`
+	Model   string `yaml:"model"`
+}
+
+// ParseBackendList parses "claude,azure,ollama=llama3" into BackendRefs
+func ParseBackendList(spec string) ([]BackendRef, error) {
+	var refs []BackendRef
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, model, _ := strings.Cut(item, "=")
+		if name == "" {
+			return nil, fmt.Errorf("missing backend name in %q", item)
+		}
+		refs = append(refs, BackendRef{Backend: name, Model: model})
+	}
+	return refs, nil
+}
+
+// ParseRateLimits parses "openai=500/30000,claude=50" into limits by
+// backend name: requests per minute, then optionally tokens per minute.
+// Either may be left empty for unlimited, as in "ollama=/20000".
+func ParseRateLimits(spec string) (map[string]ratelimit.Limits, error) {
+	limits := make(map[string]ratelimit.Limits)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(item, "=")
+		if name == "" || !ok {
+			return nil, fmt.Errorf("expected backend=rpm[/tpm] (got %q)", item)
+		}
+		rpm, tpm, _ := strings.Cut(value, "/")
+		var l ratelimit.Limits
+		for dst, v := range map[*int]string{&l.RequestsPerMinute: rpm, &l.TokensPerMinute: tpm} {
+			if v == "" {
+				continue
+			}
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid rate limit %q for %s", v, name)
+			}
+			*dst = n
+		}
+		limits[name] = l
+	}
+	return limits, nil
+}
+
+// Budget caps what a run may spend; calls that could exceed it are not made
+type Budget struct {
+	MaxCost        float64 `yaml:"max_cost"` // USD
+	MaxTokensTotal int     `yaml:"max_tokens_total"`
+}
+
+// Agent controls the agentic mode, in which the model explores the graph
+// through tool calls instead of receiving a sample of it
+type Agent struct {
+	Enabled   *bool `yaml:"enabled"`
+	MaxSteps  int   `yaml:"max_steps"`  // Model calls while exploring, per backend
+	MaxTokens int   `yaml:"max_tokens"` // Tokens spent exploring, per backend
+}
+
+// Triage controls two-phase runs: the model ranks candidates from an
+// overview of every entity, then analyses the top ones in depth
+type Triage struct {
+	Enabled     *bool `yaml:"enabled"`
+	DeepDives   int   `yaml:"deep_dives"`  // Candidates analysed in depth
+	Concurrency int   `yaml:"concurrency"` // Deep dives in flight at once
+}
+
+// Focus narrows the analysis to the neighbourhood of chosen objects
+type Focus struct {
+	Targets []string `yaml:"targets"` // SIDs, names, distinguished names or domains
+	Hops    int      `yaml:"hops"`    // Edges followed away from the targets
+}
+
+// Sampling controls which entities are sent to the model
+type Sampling struct {
+	SampleSize int `yaml:"sample_size"`
+}
+
+// Cache controls the on-disk response cache
+type Cache struct {
+	Enabled   *bool         `yaml:"enabled"` // Default true
+	TTL       time.Duration `yaml:"ttl"`
+	MaxSizeMB int           `yaml:"max_size_mb"`
+}
+
+// Network configures egress for every backend, e.g. through a
+// TLS-inspecting proxy
+type Network struct {
+	Proxy      string `yaml:"proxy"`       // Proxy URL; unset = HTTPS_PROXY/HTTP_PROXY
+	NoProxy    string `yaml:"no_proxy"`    // Hosts or domains reached directly, comma-separated
+	CAFile     string `yaml:"ca_file"`     // PEM bundle trusted in addition to the system roots
+	ClientCert string `yaml:"client_cert"` // PEM certificate for mutual TLS
+	ClientKey  string `yaml:"client_key"`
+	MinTLS     string `yaml:"min_tls"` // 1.2 or 1.3
+}
+
+// Output controls how findings are reported
+type Output struct {
+	Format string `yaml:"format"` // text or json
+}
+
+// Settings are the effective values for a run after merging
+// flags > environment > profile > defaults
+type Settings struct {
+	Backend             string
+	Model               string
+	Temperature         *float64
+	MaxTokens           int
+	Seed                *int
+	Privacy             string
+	SaveMapping         bool
+	Record              bool
+	MaxAttempts         int
+	Timeout             time.Duration
+	CallTimeout         time.Duration
+	MaxCost             float64
+	MaxTotal            int // Max prompt plus completion tokens per run
+	SampleSize          int
+	Format              string
+	Cache               bool
+	CacheTTL            time.Duration
+	CacheMaxMB          int
+	Network             Network
+	Agent               bool
+	AgentSteps          int
+	AgentTokens         int
+	Triage              bool
+	DeepDives           int
+	DeepDiveConcurrency int
+	Focus               []string
+	FocusHops           int
+	PerDomain           bool
+	Workers             int
+	RateLimits          map[string]ratelimit.Limits
+	Fallback            []BackendRef
+	Ensemble            []BackendRef
+}
+
+// DefaultPaths returns the locations searched when no file is given:
+// ./necromancer.yaml, then $XDG_CONFIG_HOME/ad-necromancer/config.yaml
+// (~/.config/ad-necromancer/config.yaml)
+func DefaultPaths() []string {
+	paths := []string{ProjectFile}
+
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			dir = filepath.Join(home, ".config")
+		}
+	}
+	if dir != "" {
+		paths = append(paths, filepath.Join(dir, "ad-necromancer", "config.yaml"))
+	}
+	return paths
+}
+
+// Load reads the configuration from path, or from the first DefaultPaths
+// entry that exists when path is empty. A missing default file yields an
+// empty configuration; a missing explicit file is an error.
+func Load(path string) (*File, error) {
+	if path != "" {
+		return loadFile(path)
+	}
+	for _, candidate := range DefaultPaths() {
+		if _, err := os.Stat(candidate); err == nil {
+			return loadFile(candidate)
+		}
+	}
+	return &File{}, nil
+}
+
+func loadFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	var f File
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true) // Typos in setting names are errors, not silent defaults
+	if err := dec.Decode(&f); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	f.Path = path
+
+	for name, p := range f.Profiles {
+		if err := p.validate(); err != nil {
+			return nil, fmt.Errorf("config %s: profile %q: %w", path, name, err)
+		}
+	}
+	return &f, nil
+}
+
+// Profile returns the named profile, or the default profile when name is
+// empty. With neither, it returns an empty profile.
+func (f *File) Profile(name string) (Profile, error) {
+	if name == "" {
+		name = f.DefaultProfile
+		if name == "" {
+			return Profile{}, nil
+		}
+	}
+	p, ok := f.Profiles[name]
+	if !ok {
+		if f.Path == "" {
+			return Profile{}, fmt.Errorf("profile %q requested but no config file found (searched %v)", name, DefaultPaths())
+		}
+		return Profile{}, fmt.Errorf("profile %q not found in %s (available: %v)", name, f.Path, f.ProfileNames())
+	}
+	return p, nil
+}
+
+// ProfileNames returns the profile names sorted alphabetically
+func (f *File) ProfileNames() []string {
+	names := make([]string, 0, len(f.Profiles))
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p Profile) validate() error {
+	switch p.Privacy {
+	case "", PrivacyAuto, PrivacyOn, PrivacyOff:
+	default:
+		return fmt.Errorf("privacy must be auto, on or off (got %q)", p.Privacy)
+	}
+	switch p.Output.Format {
+	case "", FormatText, FormatJSON:
+	default:
+		return fmt.Errorf("output.format must be text or json (got %q)", p.Output.Format)
+	}
+	if p.MaxTokens < 0 || p.MaxAttempts < 0 || p.Sampling.SampleSize < 0 || p.Cache.TTL < 0 || p.Cache.MaxSizeMB < 0 ||
+		p.Budget.MaxCost < 0 || p.Budget.MaxTokensTotal < 0 || p.Agent.MaxSteps < 0 || p.Agent.MaxTokens < 0 ||
+		p.Triage.DeepDives < 0 || p.Triage.Concurrency < 0 || p.Workers < 0 || p.Focus.Hops < 0 {
+		return fmt.Errorf("max_tokens, max_attempts, sampling.sample_size, cache, budget, agent, triage, focus and worker limits must not be negative")
+	}
+	for name, l := range p.RateLimits {
+		if l.RequestsPerMinute < 0 || l.TokensPerMinute < 0 {
+			return fmt.Errorf("rate_limits.%s must not be negative", name)
+		}
+	}
+	if enabled(p.Agent.Enabled) && enabled(p.Triage.Enabled) {
+		return fmt.Errorf("agent and triage modes cannot be combined")
+	}
+	if len(p.Focus.Targets) > 0 && (enabled(p.Agent.Enabled) || enabled(p.Triage.Enabled)) {
+		return fmt.Errorf("focus cannot be combined with agent or triage mode")
+	}
+	if len(p.Focus.Targets) > 0 && enabled(p.PerDomain) {
+		return fmt.Errorf("focus cannot be combined with per-domain analysis")
+	}
+	switch p.Network.MinTLS {
+	case "", "1.2", "1.3":
+	default:
+		return fmt.Errorf("network.min_tls must be 1.2 or 1.3 (got %q)", p.Network.MinTLS)
+	}
+	if (p.Network.ClientCert == "") != (p.Network.ClientKey == "") {
+		return fmt.Errorf("network.client_cert and network.client_key must be set together")
+	}
+	if p.Model != "" && p.Backend == "" {
+		return fmt.Errorf("model needs a backend to apply to")
+	}
+	for _, refs := range [][]BackendRef{p.Fallback, p.Ensemble} {
+		for _, ref := range refs {
+			if ref.Backend == "" {
+				return fmt.Errorf("fallback and ensemble entries need a backend")
+			}
+		}
+	}
+	return nil
+}
+
+// ApplyEnv exports the profile's backend variables that are not already
+// set, so real environment variables keep precedence over the file
+func (p Profile) ApplyEnv() error {
+	for key, value := range p.Env {
+		if _, set := os.LookupEnv(key); set {
+			continue
+		}
+		if err := os.Setenv(key, value); err != nil {
+			return fmt.Errorf("failed to set %s from profile: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// ModelFor returns the profile's model for the given backend. The model
+// belongs to the profile's own backend, so it is not used when another one
+// was selected with --backend or NECROMANCER_BACKEND, nor when the
+// backend's own model variable (e.g. OPENAI_MODEL) is set.
+func (p Profile) ModelFor(backend, modelEnv string) string {
+	if !strings.EqualFold(p.Backend, backend) {
+		return ""
+	}
+	if modelEnv != "" && os.Getenv(modelEnv) != "" {
+		return ""
+	}
+	return p.Model
+}
+
+// Resolve layers the profile over the defaults and the NECROMANCER_*
+// environment variables over the profile. Flags are applied by the caller;
+// the profile's model is resolved per backend with ModelFor.
+func Resolve(defaults Settings, p Profile) (Settings, error) {
+	s := defaults
+
+	if p.Backend != "" {
+		s.Backend = p.Backend
+	}
+	if p.Temperature != nil {
+		s.Temperature = p.Temperature
+	}
+	if p.MaxTokens > 0 {
+		s.MaxTokens = p.MaxTokens
+	}
+	if p.Seed != nil {
+		s.Seed = p.Seed
+	}
+	if p.Privacy != "" {
+		s.Privacy = p.Privacy
+	}
+	if p.SaveMapping != nil {
+		s.SaveMapping = *p.SaveMapping
+	}
+	if p.Record != nil {
+		s.Record = *p.Record
+	}
+	if p.MaxAttempts > 0 {
+		s.MaxAttempts = p.MaxAttempts
+	}
+	if p.Timeout > 0 {
+		s.Timeout = p.Timeout
+	}
+	if p.CallTimeout > 0 {
+		s.CallTimeout = p.CallTimeout
+	}
+	if p.Budget.MaxCost > 0 {
+		s.MaxCost = p.Budget.MaxCost
+	}
+	if p.Budget.MaxTokensTotal > 0 {
+		s.MaxTotal = p.Budget.MaxTokensTotal
+	}
+	if p.Sampling.SampleSize > 0 {
+		s.SampleSize = p.Sampling.SampleSize
+	}
+	if p.Output.Format != "" {
+		s.Format = p.Output.Format
+	}
+	if p.Cache.Enabled != nil {
+		s.Cache = *p.Cache.Enabled
+	}
+	if p.Cache.TTL > 0 {
+		s.CacheTTL = p.Cache.TTL
+	}
+	if p.Cache.MaxSizeMB > 0 {
+		s.CacheMaxMB = p.Cache.MaxSizeMB
+	}
+	s.Network.merge(p.Network)
+	if p.Agent.Enabled != nil {
+		s.Agent = *p.Agent.Enabled
+	}
+	if p.Agent.MaxSteps > 0 {
+		s.AgentSteps = p.Agent.MaxSteps
+	}
+	if p.Agent.MaxTokens > 0 {
+		s.AgentTokens = p.Agent.MaxTokens
+	}
+	if p.Triage.Enabled != nil {
+		s.Triage = *p.Triage.Enabled
+	}
+	if p.Triage.DeepDives > 0 {
+		s.DeepDives = p.Triage.DeepDives
+	}
+	if p.Triage.Concurrency > 0 {
+		s.DeepDiveConcurrency = p.Triage.Concurrency
+	}
+	if len(p.Focus.Targets) > 0 {
+		s.Focus = p.Focus.Targets
+	}
+	if p.Focus.Hops > 0 {
+		s.FocusHops = p.Focus.Hops
+	}
+	if p.PerDomain != nil {
+		s.PerDomain = *p.PerDomain
+	}
+	if p.Workers > 0 {
+		s.Workers = p.Workers
+	}
+	s.MergeRateLimits(p.RateLimits)
+	if len(p.Fallback) > 0 {
+		s.Fallback = p.Fallback
+	}
+	if len(p.Ensemble) > 0 {
+		s.Ensemble = p.Ensemble
+	}
+
+	if err := s.applyEnv(); err != nil {
+		return Settings{}, err
+	}
+	return s, nil
+}
+
+// applyEnv overrides settings from NECROMANCER_* variables
+func (s *Settings) applyEnv() error {
+	if v := os.Getenv("NECROMANCER_BACKEND"); v != "" {
+		s.Backend = v
+	}
+	if v := os.Getenv("NECROMANCER_MODEL"); v != "" {
+		s.Model = v
+	}
+	if v := os.Getenv("NECROMANCER_PRIVACY"); v != "" {
+		s.Privacy = v
+	}
+	if v := os.Getenv("NECROMANCER_FORMAT"); v != "" {
+		s.Format = v
+	}
+	s.Network.merge(Network{
+		Proxy:      os.Getenv("NECROMANCER_PROXY"),
+		NoProxy:    os.Getenv("NECROMANCER_NO_PROXY"),
+		CAFile:     os.Getenv("NECROMANCER_CA_FILE"),
+		ClientCert: os.Getenv("NECROMANCER_CLIENT_CERT"),
+		ClientKey:  os.Getenv("NECROMANCER_CLIENT_KEY"),
+		MinTLS:     os.Getenv("NECROMANCER_MIN_TLS"),
+	})
+	for env, dst := range map[string]*[]BackendRef{
+		"NECROMANCER_FALLBACK": &s.Fallback,
+		"NECROMANCER_ENSEMBLE": &s.Ensemble,
+	} {
+		if v := os.Getenv(env); v != "" {
+			refs, err := ParseBackendList(v)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", env, err)
+			}
+			*dst = refs
+		}
+	}
+	if v := os.Getenv("NECROMANCER_FOCUS"); v != "" {
+		// Semicolons, as distinguished names hold commas
+		s.Focus = nil
+		for _, target := range strings.Split(v, ";") {
+			if target = strings.TrimSpace(target); target != "" {
+				s.Focus = append(s.Focus, target)
+			}
+		}
+	}
+	if v := os.Getenv("NECROMANCER_RATE_LIMIT"); v != "" {
+		limits, err := ParseRateLimits(v)
+		if err != nil {
+			return fmt.Errorf("invalid NECROMANCER_RATE_LIMIT: %w", err)
+		}
+		s.MergeRateLimits(limits)
+	}
+	if v := os.Getenv("NECROMANCER_TEMPERATURE"); v != "" {
+		t, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("invalid NECROMANCER_TEMPERATURE: %w", err)
+		}
+		s.Temperature = &t
+	}
+	if v := os.Getenv("NECROMANCER_MAX_COST"); v != "" {
+		cost, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("invalid NECROMANCER_MAX_COST: %w", err)
+		}
+		s.MaxCost = cost
+	}
+	if v := os.Getenv("NECROMANCER_AGENT"); v != "" {
+		agent, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid NECROMANCER_AGENT: %w", err)
+		}
+		s.Agent = agent
+	}
+	if v := os.Getenv("NECROMANCER_TRIAGE"); v != "" {
+		triage, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid NECROMANCER_TRIAGE: %w", err)
+		}
+		s.Triage = triage
+	}
+	if v := os.Getenv("NECROMANCER_PER_DOMAIN"); v != "" {
+		perDomain, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid NECROMANCER_PER_DOMAIN: %w", err)
+		}
+		s.PerDomain = perDomain
+	}
+	if v := os.Getenv("NECROMANCER_SEED"); v != "" {
+		seed, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid NECROMANCER_SEED: %w", err)
+		}
+		s.Seed = &seed
+	}
+	for env, dst := range map[string]*int{
+		"NECROMANCER_MAX_TOKENS":       &s.MaxTokens,
+		"NECROMANCER_SAMPLE_SIZE":      &s.SampleSize,
+		"NECROMANCER_MAX_TOKENS_TOTAL": &s.MaxTotal,
+		"NECROMANCER_WORKERS":          &s.Workers,
+		"NECROMANCER_FOCUS_HOPS":       &s.FocusHops,
+	} {
+		if v := os.Getenv(env); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", env, err)
+			}
+			*dst = n
+		}
+	}
+	return nil
+}
+
+// Validate checks the merged settings
+func (s Settings) Validate() error {
+	return Profile{
+		Privacy:     s.Privacy,
+		MaxTokens:   s.MaxTokens,
+		MaxAttempts: s.MaxAttempts,
+		Sampling:    Sampling{SampleSize: s.SampleSize},
+		Output:      Output{Format: s.Format},
+		Cache:       Cache{TTL: s.CacheTTL, MaxSizeMB: s.CacheMaxMB},
+		Budget:      Budget{MaxCost: s.MaxCost, MaxTokensTotal: s.MaxTotal},
+		Network:     s.Network,
+		Agent:       Agent{Enabled: &s.Agent, MaxSteps: s.AgentSteps, MaxTokens: s.AgentTokens},
+		Triage:      Triage{Enabled: &s.Triage, DeepDives: s.DeepDives, Concurrency: s.DeepDiveConcurrency},
+		Focus:       Focus{Targets: s.Focus, Hops: s.FocusHops},
+		PerDomain:   &s.PerDomain,
+		Workers:     s.Workers,
+		RateLimits:  s.RateLimits,
+	}.validate()
+}
+
+// MergeRateLimits overrides the limits of the backends named in limits,
+// keeping those of the others
+func (s *Settings) MergeRateLimits(limits map[string]ratelimit.Limits) {
+	if len(limits) == 0 {
+		return
+	}
+	merged := make(map[string]ratelimit.Limits, len(s.RateLimits)+len(limits))
+	for name, l := range s.RateLimits {
+		merged[name] = l
+	}
+	for name, l := range limits {
+		merged[name] = l
+	}
+	s.RateLimits = merged
+}
+
+// enabled reports whether an optional switch is set and on
+func enabled(b *bool) bool {
+	return b != nil && *b
+}
+
+// merge overrides the fields of n that are set in o
+func (n *Network) merge(o Network) {
+	for dst, src := range map[*string]string{
+		&n.Proxy:      o.Proxy,
+		&n.NoProxy:    o.NoProxy,
+		&n.CAFile:     o.CAFile,
+		&n.ClientCert: o.ClientCert,
+		&n.ClientKey:  o.ClientKey,
+		&n.MinTLS:     o.MinTLS,
+	} {
+		if src != "" {
+			*dst = src
+		}
+	}
+}
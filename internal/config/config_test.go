@@ -0,0 +1,161 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// clearEnv blanks the variables these tests read, so the caller's own
+// environment cannot leak into them
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, kv := range os.Environ() {
+		if name, _, _ := strings.Cut(kv, "="); strings.HasPrefix(name, "NECROMANCER_") {
+			t.Setenv(name, "")
+		}
+	}
+	t.Setenv("OPENAI_MODEL", "")
+	t.Setenv("OLLAMA_MODEL", "")
+}
+
+// loadProfile writes a config file and returns its named profile
+func loadProfile(t *testing.T, yaml, name string) Profile {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := f.Profile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+const testConfig = `
+profiles:
+  client-x:
+    backend: ollama
+    model: llama3
+    max_tokens: 8000
+    privacy: on
+    fallback:
+      - backend: claude
+`
+
+func TestResolvePrecedence(t *testing.T) {
+	clearEnv(t)
+	p := loadProfile(t, testConfig, "client-x")
+	defaults := Settings{Backend: "deepseek", Privacy: PrivacyAuto, MaxTokens: 4000, Workers: 4}
+
+	// The profile overrides the defaults it sets and keeps the others
+	s, err := Resolve(defaults, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Backend != "ollama" || s.MaxTokens != 8000 || s.Privacy != PrivacyOn || s.Workers != 4 {
+		t.Errorf("profile over defaults: got backend %q, max_tokens %d, privacy %q, workers %d",
+			s.Backend, s.MaxTokens, s.Privacy, s.Workers)
+	}
+	if len(s.Fallback) != 1 || s.Fallback[0].Backend != "claude" {
+		t.Errorf("fallback = %+v, want claude from the profile", s.Fallback)
+	}
+	if s.Model != "" {
+		t.Errorf("Model = %q; the profile's model is resolved with ModelFor", s.Model)
+	}
+
+	// The environment overrides the profile
+	t.Setenv("NECROMANCER_BACKEND", "openai")
+	t.Setenv("NECROMANCER_MAX_TOKENS", "2000")
+	t.Setenv("NECROMANCER_FALLBACK", "gemini,azure=gpt-4o")
+	s, err = Resolve(defaults, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Backend != "openai" || s.MaxTokens != 2000 || s.Privacy != PrivacyOn {
+		t.Errorf("env over profile: got backend %q, max_tokens %d, privacy %q", s.Backend, s.MaxTokens, s.Privacy)
+	}
+	if len(s.Fallback) != 2 || s.Fallback[1] != (BackendRef{Backend: "azure", Model: "gpt-4o"}) {
+		t.Errorf("fallback = %+v, want gemini and azure=gpt-4o from the environment", s.Fallback)
+	}
+
+	t.Setenv("NECROMANCER_MAX_TOKENS", "lots")
+	if _, err := Resolve(defaults, p); err == nil {
+		t.Error("Resolve accepted an invalid NECROMANCER_MAX_TOKENS")
+	}
+}
+
+func TestModelForOverriddenBackend(t *testing.T) {
+	clearEnv(t)
+	p := loadProfile(t, testConfig, "client-x")
+
+	if got := p.ModelFor("ollama", "OLLAMA_MODEL"); got != "llama3" {
+		t.Errorf("ModelFor(ollama) = %q, want llama3", got)
+	}
+	if got := p.ModelFor("Ollama", "OLLAMA_MODEL"); got != "llama3" {
+		t.Errorf("ModelFor(Ollama) = %q, want llama3 regardless of case", got)
+	}
+
+	// --backend openai or NECROMANCER_BACKEND=openai must not send llama3 to OpenAI
+	t.Setenv("NECROMANCER_BACKEND", "openai")
+	s, err := Resolve(Settings{Backend: "deepseek"}, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := p.ModelFor(s.Backend, "OPENAI_MODEL"); got != "" {
+		t.Errorf("ModelFor(%s) = %q, want the backend's own default", s.Backend, got)
+	}
+
+	// The backend's own model variable wins over the profile
+	t.Setenv("OLLAMA_MODEL", "qwen2.5:32b")
+	if got := p.ModelFor("ollama", "OLLAMA_MODEL"); got != "" {
+		t.Errorf("ModelFor with OLLAMA_MODEL set = %q, want empty", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := Settings{Backend: "deepseek", Privacy: PrivacyAuto, Format: FormatText}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("valid settings rejected: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*Settings)
+	}{
+		{"privacy", func(s *Settings) { s.Privacy = "maybe" }},
+		{"format", func(s *Settings) { s.Format = "xml" }},
+		{"negative max tokens", func(s *Settings) { s.MaxTokens = -1 }},
+		{"negative budget", func(s *Settings) { s.MaxCost = -1 }},
+		{"agent with triage", func(s *Settings) { s.Agent, s.Triage = true, true }},
+		{"focus with agent", func(s *Settings) { s.Focus, s.Agent = []string{"svc_backup"}, true }},
+		{"focus per domain", func(s *Settings) { s.Focus, s.PerDomain = []string{"svc_backup"}, true }},
+		{"min tls", func(s *Settings) { s.Network.MinTLS = "1.1" }},
+		{"client cert without key", func(s *Settings) { s.Network.ClientCert = "cert.pem" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := valid
+			tt.modify(&s)
+			if err := s.Validate(); err == nil {
+				t.Error("Validate accepted invalid settings")
+			}
+		})
+	}
+}
+
+func TestLoadRejectsModelWithoutBackend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("profiles:\n  bare:\n    model: llama3\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Error("Load accepted a profile model without a backend")
+	}
+}
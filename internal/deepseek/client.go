@@ -1,12 +1,12 @@
 package deepseek
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"net/http"
 	"os"
 	"time"
+
+	"ad-necromancer/internal/ai"
+	"ad-necromancer/internal/openaicompat"
 )
 
 const (
@@ -14,10 +14,10 @@ const (
 	defaultModel   = "deepseek-chat"
 )
 
+// Client is the DeepSeek backend. DeepSeek speaks the OpenAI protocol but
+// only offers JSON mode (no schema), so structured output uses json_object.
 type Client struct {
-	ApiKey     string
-	BaseURL    string
-	HTTPClient *http.Client
+	*openaicompat.Client
 }
 
 func NewClient() (*Client, error) {
@@ -26,77 +26,31 @@ func NewClient() (*Client, error) {
 		return nil, fmt.Errorf("DEEPSEEK_API_KEY environment variable is not set")
 	}
 
-	return &Client{
-		ApiKey:     apiKey,
-		BaseURL:    defaultBaseURL,
-		HTTPClient: &http.Client{Timeout: 180 * time.Second}, // Increased for larger payloads
-	}, nil
-}
-
-type ChatRequest struct {
-	Model       string    `json:"model"`
-	Messages    []Message `json:"messages"`
-	Temperature float64   `json:"temperature,omitempty"`
-	MaxTokens   int       `json:"max_tokens,omitempty"`
-}
-
-type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
-}
+	c := openaicompat.New("DeepSeek", defaultBaseURL, defaultModel, 180*time.Second) // Increased for larger payloads
+	c.APIKey = apiKey
+	c.Structured = openaicompat.StructuredJSONObject
 
-type ChatResponse struct {
-	Id      string `json:"id"`
-	Choices []struct {
-		Message Message `json:"message"`
-	} `json:"choices"`
+	return &Client{Client: c}, nil
 }
 
-// Summon (Complete) sends a prompt to DeepSeek and returns the "resurrected" answer
-func (c *Client) Summon(systemPrompt, userPrompt string) (string, error) {
-	reqBody := ChatRequest{
-		Model: defaultModel,
-		Messages: []Message{
-			{Role: "system", Content: systemPrompt},
-			{Role: "user", Content: userPrompt},
+func init() {
+	ai.Register(ai.Backend{
+		Name:         "deepseek",
+		Description:  "DeepSeek",
+		DefaultModel: defaultModel,
+		Config: []ai.ConfigKey{
+			{Env: "DEEPSEEK_API_KEY", Description: "API key", Required: true, Secret: true},
 		},
-		Temperature: 0.7,  // High creativity for "mutations"
-		MaxTokens:   8000, // Increased to prevent truncation
-	}
-
-	jsonBody, err := json.Marshal(reqBody)
-	if err != nil {
-		return "", err
-	}
-
-	req, err := http.NewRequest("POST", c.BaseURL+"/chat/completions", bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return "", err
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.ApiKey)
-
-	resp, err := c.HTTPClient.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		// Read error body for better debugging
-		bodyBytes, _ := json.Marshal(reqBody)
-		return "", fmt.Errorf("API request failed with status: %d (payload size: %d bytes)", resp.StatusCode, len(bodyBytes))
-	}
-
-	var chatResp ChatResponse
-	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
-		return "", err
-	}
-
-	if len(chatResp.Choices) == 0 {
-		return "", fmt.Errorf("no response choices returned")
-	}
-
-	return chatResp.Choices[0].Message.Content, nil
+		Capabilities: ai.Capabilities{Structured: "json_object", Tools: true},
+		New: func(cfg ai.Config) (ai.AIClient, error) {
+			c, err := NewClient()
+			if err != nil {
+				return nil, err
+			}
+			if cfg.Model != "" {
+				c.Model = cfg.Model
+			}
+			return c, nil
+		},
+	})
 }
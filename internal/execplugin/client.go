@@ -0,0 +1,338 @@
+package execplugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"ad-necromancer/internal/ai"
+	"ad-necromancer/internal/transport"
+)
+
+const (
+	defaultTimeout     = 5 * time.Minute
+	defaultMaxAttempts = 3
+	maxStdout          = 32 << 20 // bytes of plugin output accepted
+	maxStderr          = 4 << 10  // bytes of stderr kept for error messages
+	waitDelay          = 5 * time.Second
+)
+
+// Client runs an external program for every model call, speaking the
+// versioned JSON protocol described in protocol.go
+type Client struct {
+	Command     string        // Resolved path of the plugin
+	Args        []string      // Arguments passed on every run
+	Model       string        // Sent as Request.Model
+	Timeout     time.Duration // Per run of the plugin
+	Local       bool          // The plugin keeps prompts on-premise
+	MaxAttempts int           // Runs per call, including retries of transient errors
+	Notify      io.Writer     // Receives retry notices; nil discards them
+
+	schemaUnsupported atomic.Bool // set once the plugin declines schemas
+}
+
+// PluginError is a failure reported by the plugin in its response
+type PluginError struct {
+	Command    string
+	Message    string
+	Code       string // One of the Code* constants, or ""
+	RetryAfter time.Duration
+}
+
+func (e *PluginError) Error() string {
+	msg := fmt.Sprintf("exec plugin %s: %s", e.Command, e.Message)
+	if e.Code != "" {
+		msg += " (" + e.Code + ")"
+	}
+	return msg
+}
+
+func (e *PluginError) retryable() bool {
+	return e.Code == CodeRateLimited || e.Code == CodeUnavailable
+}
+
+// NewClient creates an exec plugin client from the environment:
+//
+//	EXEC_COMMAND  required, plugin binary (path or name on PATH)
+//	EXEC_ARGS     arguments, space-separated or a JSON array of strings
+//	EXEC_MODEL    model id passed to the plugin
+//	EXEC_TIMEOUT  deadline per run, e.g. 10m (default 5m)
+//	EXEC_LOCAL    true if the plugin keeps prompts on-premise (Privacy Cloak off by default)
+func NewClient() (*Client, error) {
+	command := os.Getenv("EXEC_COMMAND")
+	if command == "" {
+		return nil, fmt.Errorf("EXEC_COMMAND environment variable not set")
+	}
+	path, err := exec.LookPath(command)
+	if err != nil {
+		return nil, fmt.Errorf("invalid EXEC_COMMAND: %w", err)
+	}
+
+	c := &Client{
+		Command:     path,
+		Model:       os.Getenv("EXEC_MODEL"),
+		Timeout:     defaultTimeout,
+		MaxAttempts: defaultMaxAttempts,
+		Notify:      transport.Notifier(),
+	}
+
+	if args := strings.TrimSpace(os.Getenv("EXEC_ARGS")); strings.HasPrefix(args, "[") {
+		if err := json.Unmarshal([]byte(args), &c.Args); err != nil {
+			return nil, fmt.Errorf("invalid EXEC_ARGS: %w", err)
+		}
+	} else {
+		c.Args = strings.Fields(args)
+	}
+
+	if v := os.Getenv("EXEC_TIMEOUT"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid EXEC_TIMEOUT %q (want a duration such as 10m)", v)
+		}
+		c.Timeout = timeout
+	}
+	if v := os.Getenv("EXEC_LOCAL"); v != "" {
+		local, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EXEC_LOCAL %q (want true or false)", v)
+		}
+		c.Local = local
+	}
+	return c, nil
+}
+
+func init() {
+	ai.Register(ai.Backend{
+		Name:        "exec",
+		Description: "External plugin process (JSON over stdin/stdout)",
+		ModelEnv:    "EXEC_MODEL",
+		Config: []ai.ConfigKey{
+			{Env: "EXEC_COMMAND", Description: "Plugin binary, path or name on PATH", Required: true},
+			{Env: "EXEC_ARGS", Description: "Arguments, space-separated or a JSON array"},
+			{Env: "EXEC_MODEL", Description: "Model id passed to the plugin"},
+			{Env: "EXEC_TIMEOUT", Description: "Deadline per plugin run", Default: defaultTimeout.String()},
+			{Env: "EXEC_LOCAL", Description: "true if the plugin keeps prompts on-premise", Default: "false"},
+		},
+		Capabilities: ai.Capabilities{AutoLocal: true, Structured: "options.schema, if the plugin supports it", Seed: true},
+		New: func(cfg ai.Config) (ai.AIClient, error) {
+			c, err := NewClient()
+			if err != nil {
+				return nil, err
+			}
+			if cfg.Model != "" {
+				c.Model = cfg.Model
+			}
+			return c, nil
+		},
+	})
+}
+
+// IsLocal reports the EXEC_LOCAL setting; the plugin's destination is
+// unknown otherwise, so it is treated as remote by default
+func (c *Client) IsLocal() bool {
+	return c.Local
+}
+
+// Summon implements the AIClient interface
+func (c *Client) Summon(systemPrompt, userPrompt string) (string, error) {
+	return ai.Summon(c, systemPrompt, userPrompt)
+}
+
+// Chat implements the AIClient interface. A plugin that answers
+// schema_unsupported gets prompt-only requests for the rest of the run.
+func (c *Client) Chat(ctx context.Context, req *ai.ChatRequest) (*ai.ChatResponse, error) {
+	return ai.ChatWithSchemaFallback(ctx, &c.schemaUnsupported, req, c.chat)
+}
+
+func (c *Client) chat(ctx context.Context, req *ai.ChatRequest, schema *ai.Schema) (*ai.ChatResponse, error) {
+	opts := &RequestOptions{
+		Temperature: req.Options.TemperatureOrDefault(),
+		MaxTokens:   req.Options.MaxTokensOrDefault(),
+		Seed:        req.Options.Seed,
+		Stop:        req.Options.Stop,
+	}
+	if schema != nil {
+		opts.Schema = &Schema{Name: schema.Name, Description: schema.Description, Definition: schema.Definition}
+	}
+
+	resp, err := c.call(ctx, &Request{Type: TypeChat, Model: c.Model, Messages: newMessages(req.Messages), Options: opts})
+	if err != nil {
+		var pluginErr *PluginError
+		if schema != nil && errors.As(err, &pluginErr) && pluginErr.Code == CodeSchemaUnsupported {
+			return nil, &ai.SchemaRejectedError{Err: err}
+		}
+		return nil, err
+	}
+
+	reply := &ai.ChatResponse{Text: resp.Text, FinishReason: resp.FinishReason, Model: resp.Model}
+	if resp.Usage != nil {
+		reply.Usage = ai.Usage{PromptTokens: resp.Usage.PromptTokens, CompletionTokens: resp.Usage.CompletionTokens}
+	}
+	return reply, nil
+}
+
+// Probe implements ai.Prober with a probe request. Plugins that do not
+// implement probe are checked with a one-token completion.
+func (c *Client) Probe(ctx context.Context) (*ai.ProbeResult, error) {
+	notes := []string{fmt.Sprintf("plugin %s (protocol v%d)", c.Command, ProtocolVersion)}
+
+	resp, err := c.call(ctx, &Request{Type: TypeProbe, Model: c.Model})
+	var pluginErr *PluginError
+	if errors.As(err, &pluginErr) {
+		switch pluginErr.Code {
+		case CodeUnsupported:
+			result, err := ai.ProbeChat(ctx, c)
+			if result != nil {
+				result.Notes = append(notes, result.Notes...)
+			}
+			return result, err
+		case CodeModelNotFound:
+			return nil, fmt.Errorf("%w: %v", ai.ErrModelNotFound, err)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ai.ProbeResult{
+		Model:         resp.Model,
+		ContextWindow: resp.ContextWindow,
+		Structured:    resp.Structured,
+		Notes:         append(notes, resp.Notes...),
+	}, nil
+}
+
+// call runs the plugin for req, retrying rate limits and transient errors
+func (c *Client) call(ctx context.Context, req *Request) (*Response, error) {
+	req.Version = ProtocolVersion
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal plugin request: %w", err)
+	}
+
+	maxAttempts := max(c.MaxAttempts, 1)
+	if !transport.RetriesAllowed(ctx) {
+		maxAttempts = 1
+	}
+	for attempt := 1; ; attempt++ {
+		resp, err := c.run(ctx, payload)
+		var pluginErr *PluginError
+		if err == nil || !errors.As(err, &pluginErr) || !pluginErr.retryable() || attempt >= maxAttempts {
+			return resp, err
+		}
+
+		wait := pluginErr.RetryAfter
+		if wait <= 0 {
+			wait = time.Duration(attempt) * time.Second
+		}
+		if c.Notify != nil {
+			fmt.Fprintf(c.Notify, "[~] exec plugin: %s; retrying in %s (attempt %d/%d)\n",
+				pluginErr.Code, wait.Round(10*time.Millisecond), attempt+1, maxAttempts)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// run starts the plugin once and decodes its response
+func (c *Client) run(parent context.Context, payload []byte) (*Response, error) {
+	ctx, cancel := context.WithTimeout(parent, c.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, c.Command, c.Args...)
+	cmd.Stdin = bytes.NewReader(payload)
+	stdout := &cappedBuffer{limit: maxStdout}
+	stderr := &tailBuffer{limit: maxStderr}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = waitDelay // Don't hang on children that keep the pipes open
+
+	runErr := cmd.Run()
+	if err := parent.Err(); err != nil {
+		return nil, err
+	}
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("exec plugin %s did not answer within %s%s", c.Command, c.Timeout, stderr.suffix())
+	}
+	if stdout.overflow {
+		return nil, fmt.Errorf("exec plugin %s wrote more than %d MB to stdout", c.Command, maxStdout>>20)
+	}
+
+	var resp Response
+	decodeErr := json.Unmarshal(bytes.TrimSpace(stdout.Bytes()), &resp)
+	switch {
+	case decodeErr != nil && runErr != nil:
+		return nil, fmt.Errorf("exec plugin %s failed: %v%s", c.Command, runErr, stderr.suffix())
+	case decodeErr != nil:
+		return nil, fmt.Errorf("exec plugin %s wrote an invalid response (%v); stdout must hold one JSON object%s", c.Command, decodeErr, stderr.suffix())
+	case resp.Version != ProtocolVersion:
+		return nil, fmt.Errorf("exec plugin %s answered with protocol version %d; this build speaks version %d", c.Command, resp.Version, ProtocolVersion)
+	case resp.Error != nil:
+		if resp.Error.Message == "" {
+			resp.Error.Message = "unspecified error"
+		}
+		return nil, &PluginError{
+			Command:    c.Command,
+			Message:    resp.Error.Message,
+			Code:       resp.Error.Code,
+			RetryAfter: time.Duration(resp.Error.RetryAfter * float64(time.Second)),
+		}
+	case runErr != nil:
+		return nil, fmt.Errorf("exec plugin %s failed after answering: %v%s", c.Command, runErr, stderr.suffix())
+	}
+	return &resp, nil
+}
+
+// cappedBuffer keeps the first limit bytes written to it. Writes never
+// fail, so the plugin is not killed by a broken pipe.
+type cappedBuffer struct {
+	bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); len(p) > room {
+		b.overflow = true
+		b.Buffer.Write(p[:max(room, 0)])
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// tailBuffer keeps the last limit bytes written to it
+type tailBuffer struct {
+	buf   []byte
+	limit int
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.limit {
+		b.buf = b.buf[len(b.buf)-b.limit:]
+	}
+	return len(p), nil
+}
+
+// suffix formats the captured stderr for an error message
+func (b *tailBuffer) suffix() string {
+	s := strings.TrimSpace(string(b.buf))
+	if s == "" {
+		return ""
+	}
+	return "; stderr: " + s
+}
@@ -0,0 +1,102 @@
+package execplugin
+
+import "ad-necromancer/internal/ai"
+
+// ProtocolVersion is the plugin protocol spoken by this build. Requests
+// carry it and responses must echo it; a plugin that cannot speak it
+// answers with CodeUnsupportedVersion.
+//
+// For every model call the plugin is started once, reads one Request as a
+// JSON object from stdin (closed after the request), writes one Response as
+// a JSON object to stdout and exits. Anything written to stderr is
+// captured and shown when the call fails. The plugin inherits the
+// environment, so it can read its own credentials.
+const ProtocolVersion = 1
+
+// Request types
+const (
+	TypeChat  = "chat"  // Generate a reply to Messages
+	TypeProbe = "probe" // Check credentials and model without generating; optional
+)
+
+// Error codes a plugin may return
+const (
+	CodeSchemaUnsupported  = "schema_unsupported"  // Retried without options.schema for the rest of the run
+	CodeRateLimited        = "rate_limited"        // Retried after retry_after seconds or a backoff
+	CodeUnavailable        = "unavailable"         // Transient failure; retried with backoff
+	CodeUnsupported        = "unsupported"         // Request type not implemented, e.g. probe
+	CodeUnsupportedVersion = "unsupported_version" // The plugin does not speak Request.Version
+	CodeModelNotFound      = "model_not_found"     // The requested model does not exist
+)
+
+// Request is written to the plugin's stdin
+type Request struct {
+	Version  int             `json:"version"`
+	Type     string          `json:"type"`
+	Model    string          `json:"model,omitempty"`    // EXEC_MODEL or --model; "" = the plugin's default
+	Messages []Message       `json:"messages,omitempty"` // System prompt first, then alternating user and assistant turns
+	Options  *RequestOptions `json:"options,omitempty"`
+}
+
+// Message is one turn of the conversation
+type Message struct {
+	Role    string `json:"role"` // system, user or assistant
+	Content string `json:"content"`
+}
+
+// newMessages converts the conversation to protocol messages, so changes to
+// ai.Message never reach the wire format
+func newMessages(messages []ai.Message) []Message {
+	out := make([]Message, 0, len(messages))
+	for _, m := range messages {
+		out = append(out, Message{Role: m.Role, Content: m.Content})
+	}
+	return out
+}
+
+// RequestOptions are the call options of a chat request
+type RequestOptions struct {
+	Temperature float64  `json:"temperature"`
+	MaxTokens   int      `json:"max_tokens"`
+	Seed        *int     `json:"seed,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+	Schema      *Schema  `json:"schema,omitempty"` // JSON Schema the reply text should satisfy
+}
+
+// Schema is a structured-output request
+type Schema struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Definition  map[string]interface{} `json:"definition"`
+}
+
+// Response is read from the plugin's stdout
+type Response struct {
+	Version int `json:"version"`
+
+	// Chat
+	Text         string `json:"text,omitempty"`
+	FinishReason string `json:"finish_reason,omitempty"` // stop, length, content_filter, or the provider's value
+	Model        string `json:"model,omitempty"`
+	Usage        *Usage `json:"usage,omitempty"`
+
+	// Probe
+	ContextWindow int      `json:"context_window,omitempty"`
+	Structured    string   `json:"structured,omitempty"`
+	Notes         []string `json:"notes,omitempty"`
+
+	Error *Error `json:"error,omitempty"`
+}
+
+// Usage reports the tokens of one call
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// Error is a failure reported by the plugin
+type Error struct {
+	Message    string  `json:"message"`
+	Code       string  `json:"code,omitempty"`        // One of the Code* constants, or "" for a permanent failure
+	RetryAfter float64 `json:"retry_after,omitempty"` // Seconds to wait before retrying
+}
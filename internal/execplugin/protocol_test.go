@@ -0,0 +1,40 @@
+package execplugin
+
+import (
+	"encoding/json"
+	"testing"
+
+	"ad-necromancer/internal/ai"
+)
+
+// The wire format is public; internal message fields must not leak into it
+func TestRequestMessagesWireFormat(t *testing.T) {
+	req := Request{
+		Version: ProtocolVersion,
+		Type:    TypeChat,
+		Messages: newMessages([]ai.Message{
+			{Role: ai.RoleSystem, Content: "system"},
+			{Role: ai.RoleAssistant, Content: "reply", ToolCalls: []ai.ToolCall{{ID: "1", Name: "lookup"}}},
+			{Role: ai.RoleUser, Content: "", ToolCallID: "1", ToolName: "lookup"},
+		}),
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded struct {
+		Messages []map[string]interface{} `json:"messages"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Messages) != 3 {
+		t.Fatalf("%d messages, want 3", len(decoded.Messages))
+	}
+	for i, m := range decoded.Messages {
+		if len(m) != 2 || m["role"] == nil || m["content"] == nil {
+			t.Errorf("message %d = %v, want exactly role and content", i, m)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package gateway
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"ad-necromancer/internal/transport"
+)
+
+// Auth supplies the credentials sent with every gateway request
+type Auth interface {
+	// Token returns the bearer token for the next request; "" sends none
+	Token(ctx context.Context) (string, error)
+
+	// Invalidate drops token, which the gateway rejected, and reports
+	// whether a fresh one can be obtained
+	Invalidate(token string) bool
+
+	// Describe summarises the method for doctor and error messages
+	Describe() string
+}
+
+// NoAuth sends no credentials, e.g. for gateways that trust mTLS or the
+// network
+type NoAuth struct{}
+
+func (NoAuth) Token(context.Context) (string, error) { return "", nil }
+func (NoAuth) Invalidate(string) bool                { return false }
+func (NoAuth) Describe() string                      { return "none" }
+
+// StaticToken sends a fixed bearer token
+type StaticToken string
+
+func (t StaticToken) Token(context.Context) (string, error) { return string(t), nil }
+func (t StaticToken) Invalidate(string) bool                { return false }
+func (t StaticToken) Describe() string                      { return "static bearer token" }
+
+// refreshSkew renews tokens this long before they expire, so a token
+// never runs out between being handed out and reaching the gateway
+const refreshSkew = 60 * time.Second
+
+// defaultTokenLifetime is assumed when the token response has no expires_in
+const defaultTokenLifetime = 5 * time.Minute
+
+// ClientCredentials obtains tokens with the OAuth2 client credentials grant
+// (RFC 6749 section 4.4) and caches them until shortly before they expire
+type ClientCredentials struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scope        string // Space-separated; optional
+	Audience     string // Sent as "audience" (Auth0, Okta); optional
+	InBody       bool   // Send the client credentials as form fields instead of HTTP Basic auth
+	Transport    *transport.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// TokenResponse is the token endpoint's success response
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"` // Seconds
+}
+
+// Token returns the cached token, fetching a new one when it is missing or
+// about to expire. Concurrent callers wait for a single fetch.
+func (c *ClientCredentials) Token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && time.Now().Before(c.expires) {
+		return c.token, nil
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if c.Scope != "" {
+		form.Set("scope", c.Scope)
+	}
+	if c.Audience != "" {
+		form.Set("audience", c.Audience)
+	}
+	headers := map[string]string{
+		"Content-Type": "application/x-www-form-urlencoded",
+		"Accept":       "application/json",
+	}
+	if c.InBody {
+		form.Set("client_id", c.ClientID)
+		form.Set("client_secret", c.ClientSecret)
+	} else {
+		// RFC 6749 section 2.3.1: form-encode, then Basic auth
+		credentials := url.QueryEscape(c.ClientID) + ":" + url.QueryEscape(c.ClientSecret)
+		headers["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+	}
+
+	body, err := c.Transport.Do(ctx, http.MethodPost, c.TokenURL, headers, []byte(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to obtain gateway token: %w", err)
+	}
+	var resp TokenResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", fmt.Errorf("failed to decode token response from %s: %w", c.TokenURL, err)
+	}
+	if resp.AccessToken == "" {
+		return "", fmt.Errorf("token response from %s has no access_token", c.TokenURL)
+	}
+	if resp.TokenType != "" && !strings.EqualFold(resp.TokenType, "bearer") {
+		return "", fmt.Errorf("token endpoint %s issued a %q token; only bearer tokens are supported", c.TokenURL, resp.TokenType)
+	}
+
+	lifetime := defaultTokenLifetime
+	if resp.ExpiresIn > 0 {
+		lifetime = time.Duration(resp.ExpiresIn) * time.Second
+	}
+	// Short-lived tokens are renewed halfway through instead
+	c.token = resp.AccessToken
+	c.expires = time.Now().Add(max(lifetime-refreshSkew, lifetime/2))
+	return c.token, nil
+}
+
+// Invalidate forgets token so the next request fetches a new one. A token
+// another request has already renewed is kept.
+func (c *ClientCredentials) Invalidate(token string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token == token {
+		c.token = ""
+	}
+	return true
+}
+
+func (c *ClientCredentials) Describe() string {
+	return fmt.Sprintf("OAuth2 client credentials (%s, client %s)", c.TokenURL, c.ClientID)
+}
+
+// Expires returns when the cached token will be renewed; zero when none
+// is cached
+func (c *ClientCredentials) Expires() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token == "" {
+		return time.Time{}
+	}
+	return c.expires
+}
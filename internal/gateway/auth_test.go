@@ -0,0 +1,238 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ad-necromancer/internal/transport"
+)
+
+// tokenEndpoint is a stand-in OAuth2 token endpoint that issues token-1,
+// token-2, ... and records how each request authenticated
+type tokenEndpoint struct {
+	*httptest.Server
+
+	fetches   atomic.Int32
+	expiresIn int
+
+	mu       sync.Mutex
+	forms    []map[string]string
+	basicIDs []string
+	secrets  []string
+}
+
+func newTokenEndpoint(t *testing.T, expiresIn int) *tokenEndpoint {
+	e := &tokenEndpoint{expiresIn: expiresIn}
+	e.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "client_credentials" {
+			http.Error(w, `{"error":"unsupported_grant_type"}`, http.StatusBadRequest)
+			return
+		}
+		// Slow enough for concurrent callers to overlap
+		time.Sleep(20 * time.Millisecond)
+		n := e.fetches.Add(1)
+
+		form := make(map[string]string)
+		for key := range r.PostForm {
+			form[key] = r.PostForm.Get(key)
+		}
+		id, secret, _ := r.BasicAuth()
+		e.mu.Lock()
+		e.forms = append(e.forms, form)
+		e.basicIDs = append(e.basicIDs, id)
+		e.secrets = append(e.secrets, secret)
+		e.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(TokenResponse{
+			AccessToken: fmt.Sprintf("token-%d", n),
+			TokenType:   "Bearer",
+			ExpiresIn:   e.expiresIn,
+		})
+	}))
+	t.Cleanup(e.Close)
+	return e
+}
+
+func (e *tokenEndpoint) credentials() *ClientCredentials {
+	tr := transport.New("Test token endpoint", 5*time.Second)
+	tr.HTTPClient.Transport = &http.Transport{}
+	tr.BaseDelay = time.Millisecond
+	return &ClientCredentials{
+		TokenURL:     e.URL,
+		ClientID:     "necro:client",
+		ClientSecret: "s3cret&more",
+		Scope:        "llm.read",
+		Transport:    tr,
+	}
+}
+
+func TestClientCredentialsCachesToken(t *testing.T) {
+	endpoint := newTokenEndpoint(t, 3600)
+	cc := endpoint.credentials()
+	ctx := context.Background()
+
+	for range 3 {
+		token, err := cc.Token(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "token-1" {
+			t.Errorf("Token = %q, want the cached token-1", token)
+		}
+	}
+	if n := endpoint.fetches.Load(); n != 1 {
+		t.Errorf("%d fetches, want 1", n)
+	}
+
+	// Renewed refreshSkew before the hour is up
+	if renew := time.Until(cc.Expires()); renew > time.Hour-refreshSkew || renew < time.Hour-refreshSkew-time.Minute {
+		t.Errorf("renews in %s, want about %s", renew, time.Hour-refreshSkew)
+	}
+
+	// Once the renewal time has passed the next call fetches a new token
+	cc.mu.Lock()
+	cc.expires = time.Now().Add(-time.Second)
+	cc.mu.Unlock()
+	if token, _ := cc.Token(ctx); token != "token-2" {
+		t.Errorf("Token after expiry = %q, want token-2", token)
+	}
+}
+
+func TestClientCredentialsRenewsShortLivedTokensHalfway(t *testing.T) {
+	for _, tt := range []struct {
+		expiresIn int
+		want      time.Duration
+	}{
+		{expiresIn: 60, want: 30 * time.Second},
+		{expiresIn: 0, want: defaultTokenLifetime - refreshSkew},
+	} {
+		endpoint := newTokenEndpoint(t, tt.expiresIn)
+		cc := endpoint.credentials()
+		if _, err := cc.Token(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if renew := time.Until(cc.Expires()); renew > tt.want || renew < tt.want-5*time.Second {
+			t.Errorf("expires_in %d: renews in %s, want about %s", tt.expiresIn, renew, tt.want)
+		}
+	}
+}
+
+func TestClientCredentialsSingleFetchForConcurrentCallers(t *testing.T) {
+	endpoint := newTokenEndpoint(t, 3600)
+	cc := endpoint.credentials()
+
+	var wg sync.WaitGroup
+	tokens := make([]string, 20)
+	for i := range tokens {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tokens[i], _ = cc.Token(context.Background())
+		}()
+	}
+	wg.Wait()
+
+	if n := endpoint.fetches.Load(); n != 1 {
+		t.Errorf("%d fetches, want 1", n)
+	}
+	for i, token := range tokens {
+		if token != "token-1" {
+			t.Errorf("caller %d got %q, want token-1", i, token)
+		}
+	}
+}
+
+func TestClientCredentialsClientAuthentication(t *testing.T) {
+	t.Run("basic", func(t *testing.T) {
+		endpoint := newTokenEndpoint(t, 3600)
+		cc := endpoint.credentials()
+		if _, err := cc.Token(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		// RFC 6749 section 2.3.1 form-encodes both before Basic auth
+		if endpoint.basicIDs[0] != "necro%3Aclient" || endpoint.secrets[0] != "s3cret%26more" {
+			t.Errorf("Basic credentials = %q:%q", endpoint.basicIDs[0], endpoint.secrets[0])
+		}
+		if _, ok := endpoint.forms[0]["client_secret"]; ok {
+			t.Error("client_secret sent in the body as well as Basic auth")
+		}
+		if scope := endpoint.forms[0]["scope"]; scope != "llm.read" {
+			t.Errorf("scope = %q, want llm.read", scope)
+		}
+	})
+
+	t.Run("body", func(t *testing.T) {
+		endpoint := newTokenEndpoint(t, 3600)
+		cc := endpoint.credentials()
+		cc.InBody = true
+		cc.Audience = "https://llm.corp.example"
+		if _, err := cc.Token(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		form := endpoint.forms[0]
+		if form["client_id"] != "necro:client" || form["client_secret"] != "s3cret&more" {
+			t.Errorf("form credentials = %q:%q", form["client_id"], form["client_secret"])
+		}
+		if form["audience"] != "https://llm.corp.example" {
+			t.Errorf("audience = %q", form["audience"])
+		}
+		if endpoint.basicIDs[0] != "" {
+			t.Errorf("Basic auth sent as well: %q", endpoint.basicIDs[0])
+		}
+	})
+}
+
+func TestClientCredentialsRejectsBadResponses(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"no access token", `{"token_type":"Bearer"}`, "no access_token"},
+		{"wrong token type", `{"access_token":"x","token_type":"mac"}`, "only bearer"},
+		{"not json", `<html>`, "decode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			cc := (&tokenEndpoint{Server: srv}).credentials()
+			if _, err := cc.Token(context.Background()); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want one mentioning %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientCredentialsInvalidateKeepsRenewedToken(t *testing.T) {
+	endpoint := newTokenEndpoint(t, 3600)
+	cc := endpoint.credentials()
+	ctx := context.Background()
+
+	first, _ := cc.Token(ctx)
+	if !cc.Invalidate(first) {
+		t.Fatal("Invalidate = false, want a fresh token to be obtainable")
+	}
+	second, _ := cc.Token(ctx)
+
+	// A late 401 for the old token must not throw away the new one
+	cc.Invalidate(first)
+	if third, _ := cc.Token(ctx); third != second {
+		t.Errorf("Token = %q after a stale invalidation, want %q", third, second)
+	}
+	if n := endpoint.fetches.Load(); n != 2 {
+		t.Errorf("%d fetches, want 2", n)
+	}
+}
@@ -0,0 +1,237 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"ad-necromancer/internal/ai"
+	"ad-necromancer/internal/claude"
+	"ad-necromancer/internal/gemini"
+	"ad-necromancer/internal/openaicompat"
+	"ad-necromancer/internal/transport"
+)
+
+// Wire protocols a gateway can speak
+const (
+	ProtocolOpenAI    = "openai"    // POST {url}/chat/completions
+	ProtocolAnthropic = "anthropic" // POST {url}/messages
+	ProtocolGemini    = "gemini"    // POST {url}/models/{model}:generateContent
+)
+
+// Client reaches a model through an internal LLM gateway. It speaks one of
+// the provider protocols and adds the gateway's credentials and headers to
+// every request.
+type Client struct {
+	URL      string
+	Protocol string
+	Model    string
+	Auth     Auth
+
+	inner ai.AIClient // Protocol client whose transport carries the auth
+}
+
+// NewClient creates a gateway client from the environment:
+//
+//	GATEWAY_URL            required, API root, e.g. https://llm.corp.example/v1
+//	GATEWAY_PROTOCOL       openai (default), anthropic or gemini
+//	GATEWAY_MODEL          model id (required for anthropic and gemini)
+//	GATEWAY_TOKEN          static bearer token
+//	GATEWAY_TOKEN_URL      OAuth2 token endpoint; enables client credentials
+//	GATEWAY_CLIENT_ID      OAuth2 client id
+//	GATEWAY_CLIENT_SECRET  OAuth2 client secret
+//	GATEWAY_SCOPE          OAuth2 scope, optional
+//	GATEWAY_AUDIENCE       OAuth2 audience, optional
+//	GATEWAY_CLIENT_AUTH    basic (default) or body: how the client secret is sent
+//	GATEWAY_HEADERS        header templates, "X-Tenant=acme,X-Request-ID={{uuid}}"
+//	GATEWAY_STRUCTURED     openai protocol only: json_schema (default), json_object or none
+func NewClient() (*Client, error) {
+	return newClient("")
+}
+
+// newClient creates a gateway client for model, or GATEWAY_MODEL when empty
+func newClient(model string) (*Client, error) {
+	base := strings.TrimRight(os.Getenv("GATEWAY_URL"), "/")
+	if base == "" {
+		return nil, fmt.Errorf("GATEWAY_URL environment variable not set")
+	}
+	if _, err := url.ParseRequestURI(base); err != nil {
+		return nil, fmt.Errorf("invalid GATEWAY_URL: %w", err)
+	}
+
+	c := &Client{
+		URL:      base,
+		Protocol: strings.ToLower(os.Getenv("GATEWAY_PROTOCOL")),
+		Model:    model,
+	}
+	if c.Model == "" {
+		c.Model = os.Getenv("GATEWAY_MODEL")
+	}
+	if c.Protocol == "" {
+		c.Protocol = ProtocolOpenAI
+	}
+
+	auth, err := authFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	c.Auth = auth
+
+	headers, err := ParseHeaders(os.Getenv("GATEWAY_HEADERS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid GATEWAY_HEADERS: %w", err)
+	}
+
+	if err := c.build(headers); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// authFromEnv selects OAuth2 client credentials when GATEWAY_TOKEN_URL is
+// set, a static token when GATEWAY_TOKEN is, and no auth otherwise
+func authFromEnv() (Auth, error) {
+	tokenURL := os.Getenv("GATEWAY_TOKEN_URL")
+	static := os.Getenv("GATEWAY_TOKEN")
+	switch {
+	case tokenURL != "" && static != "":
+		return nil, fmt.Errorf("set GATEWAY_TOKEN or GATEWAY_TOKEN_URL, not both")
+	case static != "":
+		return StaticToken(static), nil
+	case tokenURL == "":
+		return NoAuth{}, nil
+	}
+
+	if _, err := url.ParseRequestURI(tokenURL); err != nil {
+		return nil, fmt.Errorf("invalid GATEWAY_TOKEN_URL: %w", err)
+	}
+	cc := &ClientCredentials{
+		TokenURL:     tokenURL,
+		ClientID:     os.Getenv("GATEWAY_CLIENT_ID"),
+		ClientSecret: os.Getenv("GATEWAY_CLIENT_SECRET"),
+		Scope:        os.Getenv("GATEWAY_SCOPE"),
+		Audience:     os.Getenv("GATEWAY_AUDIENCE"),
+		Transport:    transport.New("Gateway token endpoint", 30*time.Second),
+	}
+	if cc.ClientID == "" || cc.ClientSecret == "" {
+		return nil, fmt.Errorf("GATEWAY_TOKEN_URL needs GATEWAY_CLIENT_ID and GATEWAY_CLIENT_SECRET")
+	}
+	switch mode := os.Getenv("GATEWAY_CLIENT_AUTH"); mode {
+	case "", "basic":
+	case "body":
+		cc.InBody = true
+	default:
+		return nil, fmt.Errorf("invalid GATEWAY_CLIENT_AUTH %q (want basic or body)", mode)
+	}
+	return cc, nil
+}
+
+// build creates the protocol client and routes its requests through the
+// auth transport
+func (c *Client) build(headers map[string]*template.Template) error {
+	name := "Gateway (" + c.Protocol + ")"
+	t := transport.New(name, 5*time.Minute)
+
+	switch c.Protocol {
+	case ProtocolOpenAI:
+		oc := openaicompat.New(name, c.URL, c.Model, 0)
+		oc.Transport = t
+		switch mode := os.Getenv("GATEWAY_STRUCTURED"); mode {
+		case "":
+		case openaicompat.StructuredJSONSchema, openaicompat.StructuredJSONObject, openaicompat.StructuredNone:
+			oc.Structured = mode
+		default:
+			return fmt.Errorf("invalid GATEWAY_STRUCTURED %q (want json_schema, json_object or none)", mode)
+		}
+		c.inner = oc
+	case ProtocolAnthropic, ProtocolGemini:
+		if c.Model == "" {
+			return fmt.Errorf("GATEWAY_MODEL is required for the %s protocol", c.Protocol)
+		}
+		if c.Protocol == ProtocolAnthropic {
+			c.inner = &claude.Client{BaseURL: c.URL, Model: c.Model, Transport: t}
+		} else {
+			c.inner = &gemini.Client{BaseURL: c.URL, Model: c.Model, Transport: t}
+		}
+	default:
+		return fmt.Errorf("invalid GATEWAY_PROTOCOL %q (want openai, anthropic or gemini)", c.Protocol)
+	}
+
+	base := t.HTTPClient.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t.HTTPClient.Transport = &authTransport{
+		base:    base,
+		auth:    c.Auth,
+		headers: headers,
+		data:    HeaderData{Model: c.Model, Protocol: c.Protocol},
+	}
+	return nil
+}
+
+func init() {
+	ai.Register(ai.Backend{
+		Name:        "gateway",
+		Description: "Internal LLM gateway (OpenAI, Anthropic or Gemini protocol with OAuth2 or static auth)",
+		ModelEnv:    "GATEWAY_MODEL",
+		Config: []ai.ConfigKey{
+			{Env: "GATEWAY_URL", Description: "API root, e.g. https://llm.corp.example/v1", Required: true},
+			{Env: "GATEWAY_PROTOCOL", Description: "openai, anthropic or gemini", Default: ProtocolOpenAI},
+			{Env: "GATEWAY_MODEL", Description: "Model id (required for anthropic and gemini)"},
+			{Env: "GATEWAY_TOKEN", Description: "Static bearer token", Secret: true},
+			{Env: "GATEWAY_TOKEN_URL", Description: "OAuth2 token endpoint (client credentials grant)"},
+			{Env: "GATEWAY_CLIENT_ID", Description: "OAuth2 client id"},
+			{Env: "GATEWAY_CLIENT_SECRET", Description: "OAuth2 client secret", Secret: true},
+			{Env: "GATEWAY_SCOPE", Description: "OAuth2 scope"},
+			{Env: "GATEWAY_AUDIENCE", Description: "OAuth2 audience"},
+			{Env: "GATEWAY_CLIENT_AUTH", Description: "basic or body: how the client secret is sent", Default: "basic"},
+			{Env: "GATEWAY_HEADERS", Description: "Header templates, X-Tenant=acme,X-Request-ID={{uuid}}", Secret: true},
+			{Env: "GATEWAY_STRUCTURED", Description: "openai protocol: json_schema, json_object or none", Default: openaicompat.StructuredJSONSchema},
+		},
+		Capabilities: ai.Capabilities{Structured: "per protocol", Seed: true, Tools: true},
+		New: func(cfg ai.Config) (ai.AIClient, error) {
+			return newClient(cfg.Model)
+		},
+	})
+}
+
+// Summon implements the AIClient interface
+func (c *Client) Summon(systemPrompt, userPrompt string) (string, error) {
+	return ai.Summon(c, systemPrompt, userPrompt)
+}
+
+// Chat implements the AIClient interface
+func (c *Client) Chat(ctx context.Context, req *ai.ChatRequest) (*ai.ChatResponse, error) {
+	return c.inner.Chat(ctx, req)
+}
+
+// SupportsTools implements ai.ToolUser for the protocol client
+func (c *Client) SupportsTools() bool {
+	return ai.SupportsTools(c.inner)
+}
+
+// Probe implements ai.Prober. It obtains a token first, so a bad client
+// secret is reported as such rather than as a failed model call, then
+// probes the gateway with the protocol's own check.
+func (c *Client) Probe(ctx context.Context) (*ai.ProbeResult, error) {
+	if _, err := c.Auth.Token(ctx); err != nil {
+		return nil, err
+	}
+	result, err := ai.Probe(ctx, c.inner)
+	if err != nil {
+		return nil, err
+	}
+	result.Notes = append(result.Notes, "protocol "+c.Protocol, "auth: "+c.Auth.Describe())
+	if cc, ok := c.Auth.(*ClientCredentials); ok {
+		if expires := cc.Expires(); !expires.IsZero() {
+			result.Notes = append(result.Notes, fmt.Sprintf("token renews in %s", time.Until(expires).Round(time.Second)))
+		}
+	}
+	return result, nil
+}
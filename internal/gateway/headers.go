@@ -0,0 +1,111 @@
+package gateway
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"ad-necromancer/internal/openaicompat"
+)
+
+// HeaderData is what header templates can refer to, e.g. {{.Model}}
+type HeaderData struct {
+	Model    string
+	Protocol string
+	Token    string // Current access token; "" without auth
+}
+
+var headerFuncs = template.FuncMap{
+	"env":  os.Getenv,
+	"uuid": newUUID,
+	"now":  func() string { return time.Now().UTC().Format(time.RFC3339) },
+}
+
+// ParseHeaders parses "Name=template,Other=template" into header templates.
+// Templates are evaluated for every request, so {{uuid}} yields a fresh
+// request id each time.
+func ParseHeaders(spec string) (map[string]*template.Template, error) {
+	raw, err := openaicompat.ParseHeaders(spec)
+	if err != nil {
+		return nil, err
+	}
+	headers := make(map[string]*template.Template, len(raw))
+	for name, value := range raw {
+		tmpl, err := template.New(name).Funcs(headerFuncs).Option("missingkey=error").Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %w", name, err)
+		}
+		headers[http.CanonicalHeaderKey(name)] = tmpl
+	}
+	return headers, nil
+}
+
+// newUUID returns a random RFC 4122 version 4 UUID
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// authTransport adds the bearer token and the header templates to every
+// request. A 401 invalidates the token and the request is sent once more
+// with a fresh one, as gateways may revoke tokens before they expire.
+type authTransport struct {
+	base    http.RoundTripper
+	auth    Auth
+	headers map[string]*template.Template
+	data    HeaderData
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, resp, err := t.send(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || token == "" || req.GetBody == nil {
+		return resp, err
+	}
+	if !t.auth.Invalidate(token) {
+		return resp, nil
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	retry := req.Clone(req.Context())
+	retry.Body = body
+	_, resp, err = t.send(retry)
+	return resp, err
+}
+
+// send decorates a copy of req, as a RoundTripper must not modify it
+func (t *authTransport) send(req *http.Request) (string, *http.Response, error) {
+	token, err := t.auth.Token(req.Context())
+	if err != nil {
+		return "", nil, err
+	}
+
+	out := req.Clone(req.Context())
+	if token != "" {
+		out.Header.Set("Authorization", "Bearer "+token)
+	}
+	data := t.data
+	data.Token = token
+	for name, tmpl := range t.headers {
+		var value strings.Builder
+		if err := tmpl.Execute(&value, data); err != nil {
+			return "", nil, fmt.Errorf("header %s: %w", name, err)
+		}
+		out.Header.Set(name, value.String())
+	}
+
+	resp, err := t.base.RoundTrip(out)
+	return token, resp, err
+}
@@ -0,0 +1,203 @@
+package gateway
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+
+	"ad-necromancer/internal/ai"
+)
+
+// gatewayServer accepts requests bearing one of the valid tokens and
+// records what it received
+type gatewayServer struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	valid  map[string]bool
+	seen   []http.Header
+	bodies []string
+}
+
+func newGatewayServer(t *testing.T, valid ...string) *gatewayServer {
+	g := &gatewayServer{valid: make(map[string]bool)}
+	for _, token := range valid {
+		g.valid[token] = true
+	}
+	g.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		g.mu.Lock()
+		g.seen = append(g.seen, r.Header.Clone())
+		g.bodies = append(g.bodies, string(body))
+		ok := g.valid[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+		g.mu.Unlock()
+		if !ok {
+			http.Error(w, `{"error":"invalid token"}`, http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"model":"test-model","choices":[{"message":{"role":"assistant","content":"OK"},"finish_reason":"stop"}]}`))
+	}))
+	t.Cleanup(g.Close)
+	return g
+}
+
+func (g *gatewayServer) post(t *testing.T, rt http.RoundTripper, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, g.URL, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp
+}
+
+func TestAuthTransportRetriesOnceAfter401(t *testing.T) {
+	endpoint := newTokenEndpoint(t, 3600)
+	// The gateway has revoked token-1 before its expiry
+	gateway := newGatewayServer(t, "token-2")
+	rt := &authTransport{base: &http.Transport{}, auth: endpoint.credentials()}
+
+	if resp := gateway.post(t, rt, `{"n":1}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200 after the retry", resp.StatusCode)
+	}
+	if len(gateway.seen) != 2 {
+		t.Fatalf("gateway saw %d request(s), want 2", len(gateway.seen))
+	}
+	if got := gateway.seen[1].Get("Authorization"); got != "Bearer token-2" {
+		t.Errorf("retry Authorization = %q, want Bearer token-2", got)
+	}
+	if gateway.bodies[1] != `{"n":1}` {
+		t.Errorf("retry body = %q, want the original body", gateway.bodies[1])
+	}
+	if n := endpoint.fetches.Load(); n != 2 {
+		t.Errorf("%d token fetches, want 2", n)
+	}
+}
+
+func TestAuthTransportGivesUpAfterSecond401(t *testing.T) {
+	endpoint := newTokenEndpoint(t, 3600)
+	gateway := newGatewayServer(t)
+	rt := &authTransport{base: &http.Transport{}, auth: endpoint.credentials()}
+
+	if resp := gateway.post(t, rt, `{}`); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", resp.StatusCode)
+	}
+	if len(gateway.seen) != 2 {
+		t.Errorf("gateway saw %d request(s), want exactly one retry", len(gateway.seen))
+	}
+}
+
+func TestAuthTransportStaticTokenIsNotRetried(t *testing.T) {
+	gateway := newGatewayServer(t)
+	rt := &authTransport{base: &http.Transport{}, auth: StaticToken("revoked")}
+
+	if resp := gateway.post(t, rt, `{}`); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", resp.StatusCode)
+	}
+	if len(gateway.seen) != 1 {
+		t.Errorf("gateway saw %d request(s), want 1", len(gateway.seen))
+	}
+}
+
+func TestAuthTransportHeaderTemplates(t *testing.T) {
+	t.Setenv("NECRO_TEST_TENANT", "acme")
+	headers, err := ParseHeaders("x-tenant={{env \"NECRO_TEST_TENANT\"}},X-Request-ID={{uuid}},X-Route={{.Protocol}}/{{.Model}},X-Token-Echo={{.Token}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := headers["X-Tenant"]; !ok {
+		t.Errorf("header names not canonicalised: %v", headers)
+	}
+
+	gateway := newGatewayServer(t, "static")
+	rt := &authTransport{
+		base:    &http.Transport{},
+		auth:    StaticToken("static"),
+		headers: headers,
+		data:    HeaderData{Model: "test-model", Protocol: ProtocolOpenAI},
+	}
+	gateway.post(t, rt, `{}`)
+	gateway.post(t, rt, `{}`)
+
+	first, second := gateway.seen[0], gateway.seen[1]
+	for name, want := range map[string]string{"X-Tenant": "acme", "X-Route": "openai/test-model", "X-Token-Echo": "static"} {
+		if got := first.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if !uuid.MatchString(first.Get("X-Request-Id")) {
+		t.Errorf("X-Request-ID = %q, want a v4 UUID", first.Get("X-Request-Id"))
+	}
+	if first.Get("X-Request-Id") == second.Get("X-Request-Id") {
+		t.Error("X-Request-ID repeated; templates must be evaluated per request")
+	}
+}
+
+func TestParseHeadersRejectsBadTemplates(t *testing.T) {
+	for _, spec := range []string{"X-Id={{uuid", "X-Id={{nosuchfunc}}", "X-Id"} {
+		if _, err := ParseHeaders(spec); err == nil {
+			t.Errorf("ParseHeaders(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestAuthTransportFailsOnUnknownField(t *testing.T) {
+	gateway := newGatewayServer(t)
+	rt := &authTransport{
+		base:    &http.Transport{},
+		auth:    NoAuth{},
+		headers: map[string]*template.Template{"X-Bad": template.Must(template.New("X-Bad").Parse("{{.Tenant}}"))},
+	}
+	req, _ := http.NewRequest(http.MethodPost, gateway.URL, strings.NewReader(`{}`))
+	if _, err := rt.RoundTrip(req); err == nil || !strings.Contains(err.Error(), "X-Bad") {
+		t.Errorf("err = %v, want one naming the header", err)
+	}
+	if len(gateway.seen) != 0 {
+		t.Error("request sent despite the template error")
+	}
+}
+
+func TestClientSendsGatewayCredentials(t *testing.T) {
+	endpoint := newTokenEndpoint(t, 3600)
+	gateway := newGatewayServer(t, "token-1")
+
+	t.Setenv("GATEWAY_URL", gateway.URL+"/v1")
+	t.Setenv("GATEWAY_PROTOCOL", "")
+	t.Setenv("GATEWAY_MODEL", "test-model")
+	t.Setenv("GATEWAY_TOKEN", "")
+	t.Setenv("GATEWAY_TOKEN_URL", endpoint.URL)
+	t.Setenv("GATEWAY_CLIENT_ID", "necro")
+	t.Setenv("GATEWAY_CLIENT_SECRET", "secret")
+	t.Setenv("GATEWAY_CLIENT_AUTH", "body")
+	t.Setenv("GATEWAY_HEADERS", "X-Tenant=acme")
+	c, err := NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := c.Chat(context.Background(), &ai.ChatRequest{Messages: []ai.Message{{Role: ai.RoleUser, Content: "hi"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text != "OK" {
+		t.Errorf("Text = %q, want OK", resp.Text)
+	}
+	if got := gateway.seen[0].Get("X-Tenant"); got != "acme" {
+		t.Errorf("X-Tenant = %q, want acme", got)
+	}
+	if endpoint.forms[0]["client_id"] != "necro" {
+		t.Errorf("token request form = %v, want client_id in the body", endpoint.forms[0])
+	}
+}
@@ -1,46 +1,96 @@
 package gemini
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"ad-necromancer/internal/ai"
+	"ad-necromancer/internal/transport"
 )
 
 const (
-	apiEndpoint  = "https://generativelanguage.googleapis.com/v1beta/models/%s:generateContent"
-	defaultModel = "gemini-1.5-flash"
+	DefaultBaseURL = "https://generativelanguage.googleapis.com/v1beta"
+	defaultModel   = "gemini-1.5-flash"
 )
 
 type Client struct {
-	APIKey string
-	Model  string
+	BaseURL   string // API root; /models/{model} is appended
+	APIKey    string // Sent as the key query parameter when set
+	Model     string
+	Transport *transport.Client
+
+	schemaUnsupported atomic.Bool // set once the model rejects responseSchema
 }
 
 type Content struct {
+	Role  string `json:"role,omitempty"` // "user" or "model"
 	Parts []Part `json:"parts"`
 }
 
 type Part struct {
-	Text string `json:"text"`
+	Text             string            `json:"text,omitempty"`
+	FunctionCall     *FunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *FunctionResponse `json:"functionResponse,omitempty"`
+}
+
+// FunctionCall is a tool call in a model turn
+type FunctionCall struct {
+	Name string                 `json:"name"`
+	Args map[string]interface{} `json:"args"`
+}
+
+// FunctionResponse answers a FunctionCall in the next user turn
+type FunctionResponse struct {
+	Name     string                 `json:"name"`
+	Response map[string]interface{} `json:"response"`
 }
 
 type GenerateRequest struct {
-	Contents         []Content        `json:"contents"`
-	GenerationConfig GenerationConfig `json:"generationConfig"`
+	SystemInstruction *Content         `json:"systemInstruction,omitempty"`
+	Contents          []Content        `json:"contents"`
+	Tools             []Tool           `json:"tools,omitempty"`
+	GenerationConfig  GenerationConfig `json:"generationConfig"`
+}
+
+type Tool struct {
+	FunctionDeclarations []FunctionDeclaration `json:"functionDeclarations"`
+}
+
+type FunctionDeclaration struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
 }
 
 type GenerationConfig struct {
-	Temperature     float64 `json:"temperature"`
-	MaxOutputTokens int     `json:"maxOutputTokens"`
+	Temperature     float64  `json:"temperature"`
+	MaxOutputTokens int      `json:"maxOutputTokens"`
+	Seed            *int     `json:"seed,omitempty"`
+	StopSequences   []string `json:"stopSequences,omitempty"`
+
+	// Structured output (JSON mode with an OpenAPI-style schema)
+	ResponseMimeType string                 `json:"responseMimeType,omitempty"`
+	ResponseSchema   map[string]interface{} `json:"responseSchema,omitempty"`
 }
 
 type GenerateResponse struct {
 	Candidates []struct {
-		Content Content `json:"content"`
+		Content      Content `json:"content"`
+		FinishReason string  `json:"finishReason"`
 	} `json:"candidates"`
+	UsageMetadata struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+	} `json:"usageMetadata"`
+	ModelVersion string `json:"modelVersion"`
 }
 
 // NewClient creates a new Gemini client
@@ -56,63 +106,256 @@ func NewClient() (*Client, error) {
 	}
 
 	return &Client{
-		APIKey: apiKey,
-		Model:  model,
+		BaseURL:   DefaultBaseURL,
+		APIKey:    apiKey,
+		Model:     model,
+		Transport: transport.New("Gemini", 180*time.Second),
 	}, nil
 }
 
+func init() {
+	ai.Register(ai.Backend{
+		Name:        "gemini",
+		Description: "Google Gemini",
+		ModelEnv:    "GEMINI_MODEL",
+		Config: []ai.ConfigKey{
+			{Env: "GEMINI_API_KEY", Description: "API key", Required: true, Secret: true},
+			{Env: "GEMINI_MODEL", Description: "Model id", Default: defaultModel},
+		},
+		Capabilities: ai.Capabilities{Structured: "responseSchema", Seed: true, Tools: true},
+		New: func(cfg ai.Config) (ai.AIClient, error) {
+			c, err := NewClient()
+			if err != nil {
+				return nil, err
+			}
+			if cfg.Model != "" {
+				c.Model = cfg.Model
+			}
+			return c, nil
+		},
+	})
+}
+
 // Summon implements the AIClient interface
 func (c *Client) Summon(systemPrompt, userPrompt string) (string, error) {
-	// Gemini combines system and user prompts
-	combinedPrompt := fmt.Sprintf("%s\n\n%s", systemPrompt, userPrompt)
+	return ai.Summon(c, systemPrompt, userPrompt)
+}
+
+// Chat implements the AIClient interface. A schema is sent as responseSchema;
+// models without JSON mode fall back to prompt-only output.
+func (c *Client) Chat(ctx context.Context, req *ai.ChatRequest) (*ai.ChatResponse, error) {
+	return ai.ChatWithSchemaFallback(ctx, &c.schemaUnsupported, req, c.chat)
+}
+
+// schemaErrorTerms mark a 400 as a refusal of the response schema, e.g.
+// "Unknown name \"responseSchema\"" or "response_schema: ... not supported"
+var schemaErrorTerms = []string{"response_schema", "responseschema", "response_mime_type", "responsemimetype", "schema"}
+
+func (c *Client) chat(ctx context.Context, req *ai.ChatRequest, schema *ai.Schema) (*ai.ChatResponse, error) {
+	systemPrompt, turns := ai.SplitSystem(req.Messages)
 
 	reqBody := GenerateRequest{
-		Contents: []Content{
-			{
-				Parts: []Part{
-					{Text: combinedPrompt},
-				},
-			},
-		},
+		Contents: contents(turns),
 		GenerationConfig: GenerationConfig{
-			Temperature:     0.7,
-			MaxOutputTokens: 8000,
+			Temperature:     req.Options.TemperatureOrDefault(),
+			MaxOutputTokens: req.Options.MaxTokensOrDefault(),
+			Seed:            req.Options.Seed,
+			StopSequences:   req.Options.Stop,
 		},
 	}
+	if systemPrompt != "" {
+		reqBody.SystemInstruction = &Content{Parts: []Part{{Text: systemPrompt}}}
+	}
+	if len(req.Tools) > 0 {
+		tool := Tool{}
+		for _, t := range req.Tools {
+			tool.FunctionDeclarations = append(tool.FunctionDeclarations, FunctionDeclaration{
+				Name:        t.Name,
+				Description: t.Description,
+				Parameters:  toGeminiSchema(t.Parameters),
+			})
+		}
+		reqBody.Tools = []Tool{tool}
+	}
+	if schema != nil {
+		reqBody.GenerationConfig.ResponseMimeType = "application/json"
+		reqBody.GenerationConfig.ResponseSchema = toGeminiSchema(schema.Definition)
+	}
 
-	jsonData, err := json.Marshal(reqBody)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+	var result GenerateResponse
+	if err := c.Transport.PostJSON(ctx, c.modelURL(":generateContent"), nil, reqBody, &result); err != nil {
+		if schema != nil && transport.IsBadRequestAbout(err, schemaErrorTerms...) {
+			return nil, &ai.SchemaRejectedError{Err: err}
+		}
+		if len(req.Tools) > 0 && transport.IsStatus(err, http.StatusBadRequest) {
+			return nil, &ai.ToolsRejectedError{Err: err}
+		}
+		return nil, err
 	}
 
-	endpoint := fmt.Sprintf(apiEndpoint, c.Model) + "?key=" + c.APIKey
-	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+	if len(result.Candidates) == 0 || len(result.Candidates[0].Content.Parts) == 0 {
+		return nil, fmt.Errorf("no response from Gemini")
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	reply := &ai.ChatResponse{
+		FinishReason: finishReason(result.Candidates[0].FinishReason),
+		Model:        result.ModelVersion,
+		Usage: ai.Usage{
+			PromptTokens:     result.UsageMetadata.PromptTokenCount,
+			CompletionTokens: result.UsageMetadata.CandidatesTokenCount,
+		},
+	}
+	for _, part := range result.Candidates[0].Content.Parts {
+		if part.FunctionCall == nil {
+			reply.Text += part.Text
+			continue
+		}
+		args, _ := json.Marshal(part.FunctionCall.Args) // decoded from JSON, so it encodes
+		reply.ToolCalls = append(reply.ToolCalls, ai.ToolCall{
+			ID:        ai.ToolCallID("", len(reply.ToolCalls)),
+			Name:      part.FunctionCall.Name,
+			Arguments: string(args),
+		})
+	}
+	if len(reply.ToolCalls) > 0 {
+		reply.FinishReason = ai.FinishToolUse // Gemini reports STOP
+	}
+	return reply, nil
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to call Gemini API: %w", err)
+// contents converts conversation turns to Gemini contents. Tool calls
+// become functionCall parts of the model turn, and the results answering
+// them are sent together as functionResponse parts of the next user turn.
+func contents(turns []ai.Message) []Content {
+	out := make([]Content, 0, len(turns))
+	for _, m := range turns {
+		switch {
+		case m.Role == ai.RoleTool:
+			// Results are JSON objects; anything else is wrapped
+			var response map[string]interface{}
+			if err := json.Unmarshal([]byte(m.Content), &response); err != nil {
+				response = map[string]interface{}{"result": m.Content}
+			}
+			part := Part{FunctionResponse: &FunctionResponse{Name: m.ToolName, Response: response}}
+			if last := len(out) - 1; last >= 0 && out[last].Role == "user" && out[last].Parts[0].FunctionResponse != nil {
+				out[last].Parts = append(out[last].Parts, part)
+				continue
+			}
+			out = append(out, Content{Role: "user", Parts: []Part{part}})
+		case m.Role == ai.RoleAssistant:
+			var parts []Part
+			if m.Content != "" || len(m.ToolCalls) == 0 {
+				parts = append(parts, Part{Text: m.Content})
+			}
+			for _, call := range m.ToolCalls {
+				args := map[string]interface{}{}
+				json.Unmarshal([]byte(call.Arguments), &args) // malformed arguments are sent as none
+				parts = append(parts, Part{FunctionCall: &FunctionCall{Name: call.Name, Args: args}})
+			}
+			out = append(out, Content{Role: "model", Parts: parts})
+		default:
+			out = append(out, Content{Role: "user", Parts: []Part{{Text: m.Content}}})
+		}
 	}
-	defer resp.Body.Close()
+	return out
+}
+
+// SupportsTools implements ai.ToolUser
+func (c *Client) SupportsTools() bool {
+	return true
+}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("Gemini API returned status %d: %s", resp.StatusCode, string(body))
+// modelURL returns the URL of the model resource followed by method, with
+// the API key
+func (c *Client) modelURL(method string) string {
+	endpoint := c.BaseURL + "/models/" + url.PathEscape(c.Model) + method
+	if c.APIKey != "" {
+		endpoint += "?key=" + url.QueryEscape(c.APIKey)
 	}
+	return endpoint
+}
 
-	var result GenerateResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
+// finishReason maps Gemini finish reasons onto the ai.Finish* constants
+func finishReason(reason string) string {
+	switch reason {
+	case "STOP":
+		return ai.FinishStop
+	case "MAX_TOKENS":
+		return ai.FinishLength
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII":
+		return ai.FinishContentFilter
 	}
+	return reason
+}
 
-	if len(result.Candidates) == 0 || len(result.Candidates[0].Content.Parts) == 0 {
-		return "", fmt.Errorf("no response from Gemini")
+// toGeminiSchema converts a JSON Schema into Gemini's OpenAPI subset:
+// upper-case type names and no additionalProperties
+func toGeminiSchema(schema map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(schema))
+	for key, value := range schema {
+		switch key {
+		case "additionalProperties":
+			continue
+		case "type":
+			if t, ok := value.(string); ok {
+				out[key] = strings.ToUpper(t)
+				continue
+			}
+		case "items":
+			if items, ok := value.(map[string]interface{}); ok {
+				out[key] = toGeminiSchema(items)
+				continue
+			}
+		case "properties":
+			if props, ok := value.(map[string]interface{}); ok {
+				converted := make(map[string]interface{}, len(props))
+				for name, prop := range props {
+					if p, ok := prop.(map[string]interface{}); ok {
+						converted[name] = toGeminiSchema(p)
+					}
+				}
+				out[key] = converted
+				continue
+			}
+		}
+		out[key] = value
 	}
+	return out
+}
 
-	return result.Candidates[0].Content.Parts[0].Text, nil
+// ModelInfo is the GET models/{model} response
+type ModelInfo struct {
+	Name                       string   `json:"name"`
+	Version                    string   `json:"version"`
+	InputTokenLimit            int      `json:"inputTokenLimit"`
+	OutputTokenLimit           int      `json:"outputTokenLimit"`
+	SupportedGenerationMethods []string `json:"supportedGenerationMethods"`
+}
+
+// Probe implements ai.Prober by fetching the model's metadata, which checks
+// the API key and model id and reports the token limits
+func (c *Client) Probe(ctx context.Context) (*ai.ProbeResult, error) {
+	body, err := c.Transport.Do(ctx, http.MethodGet, c.modelURL(""), nil, nil)
+	if transport.IsStatus(err, http.StatusNotFound) {
+		return nil, fmt.Errorf("%w: Gemini has no model %q", ai.ErrModelNotFound, c.Model)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var info ModelInfo
+	if err := json.Unmarshal(body, &info); err != nil {
+		return nil, fmt.Errorf("failed to decode Gemini model info: %w", err)
+	}
+	result := &ai.ProbeResult{
+		Model:         strings.TrimPrefix(info.Name, "models/"),
+		ContextWindow: info.InputTokenLimit,
+	}
+	if info.OutputTokenLimit > 0 {
+		result.Notes = append(result.Notes, fmt.Sprintf("max output %d tokens", info.OutputTokenLimit))
+	}
+	if !slices.Contains(info.SupportedGenerationMethods, "generateContent") {
+		return nil, fmt.Errorf("Gemini model %q does not support generateContent", c.Model)
+	}
+	return result, nil
 }
@@ -0,0 +1,194 @@
+package gemini
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"ad-necromancer/internal/ai"
+	"ad-necromancer/internal/transport"
+)
+
+// testServer decodes each generateContent request into requests and
+// answers it with handle
+func testServer(t *testing.T, handle func(w http.ResponseWriter, r *http.Request, req GenerateRequest)) (*Client, *[]GenerateRequest) {
+	t.Helper()
+	var requests []GenerateRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req GenerateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		requests = append(requests, req)
+		handle(w, r, req)
+	}))
+	t.Cleanup(srv.Close)
+
+	tr := transport.New("Gemini", 5*time.Second)
+	tr.HTTPClient.Transport = &http.Transport{}
+	tr.BaseDelay = time.Millisecond
+	return &Client{BaseURL: srv.URL, APIKey: "test-key", Model: "gemini-test", Transport: tr}, &requests
+}
+
+func respond(w http.ResponseWriter, finishReason string, parts ...Part) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"candidates":    []map[string]interface{}{{"content": Content{Role: "model", Parts: parts}, "finishReason": finishReason}},
+		"usageMetadata": map[string]int{"promptTokenCount": 120, "candidatesTokenCount": 30},
+		"modelVersion":  "gemini-test-001",
+	})
+}
+
+func schemaRequest() *ai.ChatRequest {
+	seed := 7
+	return &ai.ChatRequest{
+		Messages: []ai.Message{
+			{Role: ai.RoleSystem, Content: "system"},
+			{Role: ai.RoleUser, Content: "user"},
+		},
+		Options: ai.Options{
+			MaxTokens: 1000,
+			Seed:      &seed,
+			Schema: &ai.Schema{
+				Name: "findings",
+				Definition: map[string]interface{}{
+					"type":                 "object",
+					"properties":           map[string]interface{}{"paths": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}}},
+					"additionalProperties": false,
+				},
+				Instructions: "Reply with a findings object.",
+			},
+		},
+	}
+}
+
+func TestChatSendsResponseSchema(t *testing.T) {
+	c, requests := testServer(t, func(w http.ResponseWriter, r *http.Request, req GenerateRequest) {
+		if r.URL.Path != "/models/gemini-test:generateContent" || r.URL.Query().Get("key") != "test-key" {
+			http.Error(w, "bad path or key", http.StatusNotFound)
+			return
+		}
+		respond(w, "STOP", Part{Text: `{"paths":`}, Part{Text: `[]}`})
+	})
+
+	resp, err := c.Chat(context.Background(), schemaRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := (*requests)[0]
+	if req.SystemInstruction == nil || req.SystemInstruction.Parts[0].Text != "system" {
+		t.Errorf("systemInstruction = %+v", req.SystemInstruction)
+	}
+	if len(req.Contents) != 1 || req.Contents[0].Role != "user" || req.Contents[0].Parts[0].Text != "user" {
+		t.Errorf("contents = %+v, want the user turn", req.Contents)
+	}
+	config := req.GenerationConfig
+	if config.MaxOutputTokens != 1000 || config.Seed == nil || *config.Seed != 7 || config.ResponseMimeType != "application/json" {
+		t.Errorf("generationConfig = %+v", config)
+	}
+	wantSchema := map[string]interface{}{
+		"type":       "OBJECT",
+		"properties": map[string]interface{}{"paths": map[string]interface{}{"type": "ARRAY", "items": map[string]interface{}{"type": "STRING"}}},
+	}
+	if !reflect.DeepEqual(config.ResponseSchema, wantSchema) {
+		t.Errorf("responseSchema = %v, want %v", config.ResponseSchema, wantSchema)
+	}
+
+	want := &ai.ChatResponse{
+		Text:         `{"paths":[]}`,
+		FinishReason: ai.FinishStop,
+		Model:        "gemini-test-001",
+		Usage:        ai.Usage{PromptTokens: 120, CompletionTokens: 30},
+	}
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("response = %+v, want %+v", resp, want)
+	}
+}
+
+func TestChatSchemaFallback(t *testing.T) {
+	c, requests := testServer(t, func(w http.ResponseWriter, r *http.Request, req GenerateRequest) {
+		if req.GenerationConfig.ResponseSchema != nil {
+			http.Error(w, `{"error":{"code":400,"message":"Invalid JSON payload received. Unknown name \"responseSchema\""}}`, http.StatusBadRequest)
+			return
+		}
+		respond(w, "MAX_TOKENS", Part{Text: "[{"})
+	})
+
+	for range 2 {
+		resp, err := c.Chat(context.Background(), schemaRequest())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.FinishReason != ai.FinishLength {
+			t.Errorf("finish reason = %q, want %q", resp.FinishReason, ai.FinishLength)
+		}
+	}
+
+	if len(*requests) != 3 {
+		t.Fatalf("%d requests, want the schema once then prompt-only twice", len(*requests))
+	}
+	for _, req := range (*requests)[1:] {
+		if req.GenerationConfig.ResponseMimeType != "" {
+			t.Errorf("prompt-only request still asks for JSON: %+v", req.GenerationConfig)
+		}
+		if !strings.HasSuffix(req.SystemInstruction.Parts[0].Text, "Reply with a findings object.") {
+			t.Errorf("systemInstruction = %q, want the schema instructions", req.SystemInstruction.Parts[0].Text)
+		}
+	}
+}
+
+func TestChatMapsFunctionCalls(t *testing.T) {
+	c, requests := testServer(t, func(w http.ResponseWriter, r *http.Request, req GenerateRequest) {
+		respond(w, "STOP", Part{FunctionCall: &FunctionCall{Name: "lookup", Args: map[string]interface{}{"name": "svc_backup"}}})
+	})
+
+	req := &ai.ChatRequest{
+		Messages: []ai.Message{
+			{Role: ai.RoleUser, Content: "Who is svc_sql?"},
+			{Role: ai.RoleAssistant, ToolCalls: []ai.ToolCall{
+				{ID: "call_0", Name: "lookup", Arguments: `{"name":"svc_sql"}`},
+				{ID: "call_1", Name: "members", Arguments: `{"group":"Domain Admins"}`},
+			}},
+			{Role: ai.RoleTool, ToolCallID: "call_0", ToolName: "lookup", Content: `{"enabled":true}`},
+			{Role: ai.RoleTool, ToolCallID: "call_1", ToolName: "members", Content: `not an object`},
+		},
+		Tools: []ai.Tool{{
+			Name:       "lookup",
+			Parameters: map[string]interface{}{"type": "object", "additionalProperties": false},
+		}},
+	}
+	resp, err := c.Chat(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent := (*requests)[0]
+	if len(sent.Tools) != 1 || len(sent.Tools[0].FunctionDeclarations) != 1 {
+		t.Fatalf("tools = %+v", sent.Tools)
+	}
+	if params := sent.Tools[0].FunctionDeclarations[0].Parameters; !reflect.DeepEqual(params, map[string]interface{}{"type": "OBJECT"}) {
+		t.Errorf("parameters = %v, want Gemini's schema subset", params)
+	}
+	if len(sent.Contents) != 3 || sent.Contents[1].Role != "model" || len(sent.Contents[1].Parts) != 2 {
+		t.Fatalf("contents = %+v, want the calls in one model turn and the results in one user turn", sent.Contents)
+	}
+	results := sent.Contents[2].Parts
+	if len(results) != 2 || results[0].FunctionResponse.Name != "lookup" ||
+		!reflect.DeepEqual(results[1].FunctionResponse.Response, map[string]interface{}{"result": "not an object"}) {
+		t.Errorf("function responses = %+v", results)
+	}
+
+	if resp.FinishReason != ai.FinishToolUse {
+		t.Errorf("finish reason = %q, want %q", resp.FinishReason, ai.FinishToolUse)
+	}
+	if len(resp.ToolCalls) != 1 || resp.ToolCalls[0].Name != "lookup" || resp.ToolCalls[0].Arguments != `{"name":"svc_backup"}` || resp.ToolCalls[0].ID == "" {
+		t.Errorf("tool calls = %+v", resp.ToolCalls)
+	}
+}
@@ -0,0 +1,276 @@
+package necromancy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"ad-necromancer/internal/ai"
+	"ad-necromancer/internal/bloodhound"
+	"ad-necromancer/internal/prompts"
+)
+
+// Defaults for agentic mode
+const (
+	DefaultAgentSteps  = 12
+	DefaultAgentTokens = 150000
+)
+
+// agentTargets and agentCandidates cap the nodes listed in the opening
+// prompt; the model finds the rest with the tools
+const (
+	agentTargets    = 15
+	agentCandidates = 10
+)
+
+// agentLogResult caps each tool result in the report prompt
+const agentLogResult = 2000
+
+// AgentBudget bounds the tool-use loop of one backend; a zero MaxTokens is
+// unlimited. The report call after the loop is bounded only by Budget.
+type AgentBudget struct {
+	MaxSteps  int // Model calls that may request tools
+	MaxTokens int // Prompt plus completion tokens across those calls
+}
+
+// ToolCallStats records one tool call made in agentic mode
+type ToolCallStats struct {
+	Backend   string `json:"backend"`
+	Step      int    `json:"step"`
+	Tool      string `json:"tool"`
+	Arguments string `json:"arguments"` // Real names, also when the model saw tokens
+	Results   int    `json:"results"`   // Nodes, edges or members returned
+	Bytes     int    `json:"bytes"`     // Size of the result sent to the model
+	Error     string `json:"error,omitempty"`
+}
+
+// toolExchange is a tool call and the result the model was given
+type toolExchange struct {
+	call   ai.ToolCall
+	result string
+}
+
+// summonBackend asks b for findings: from the focus neighbourhood in a
+// focused run, through triage and deep dives in a two-phase run, by
+// exploring the graph with tools in agentic mode when b can call them,
+// otherwise from the sampled prompt, which userPrompt builds on first use
+func (e *Engine) summonBackend(ctx context.Context, b Backend, userPrompt func() (string, error), maxEntitiesPerType int) ([]ZombiePath, error) {
+	if e.focus != nil {
+		return e.summonFocus(withChunk(ctx, chunkFocus), b)
+	}
+	if e.Triage {
+		return e.summonTriage(ctx, b)
+	}
+	if e.Agent {
+		if !ai.SupportsTools(b.Client) {
+			e.printf("[!] %s cannot call tools; analysing a sample instead\n", b.Name)
+		} else {
+			paths, err := e.summonAgent(withChunk(ctx, chunkAgent), b, maxEntitiesPerType)
+			if !ai.IsToolsRejected(err) {
+				return paths, err
+			}
+			e.printf("[!] %s rejected the tools (%v); analysing a sample instead\n", b.Name, err)
+		}
+	}
+	prompt, err := userPrompt()
+	if err != nil {
+		return nil, err
+	}
+	return e.summonFindings(ctx, b, prompts.NecromancerSystemPrompt, prompt)
+}
+
+// summonAgent lets b explore the loaded graph with tools until it answers
+// with findings or runs out of steps or tokens. In the latter case, or when
+// the answer does not parse, b is asked to report on the investigation log
+// through the usual self-correction loop. Returned findings are still in
+// tokenized form.
+func (e *Engine) summonAgent(ctx context.Context, b Backend, maxEntitiesPerType int) ([]ZombiePath, error) {
+	tb := e.toolbox()
+	budget := e.AgentBudget
+	if budget.MaxSteps < 1 {
+		budget.MaxSteps = DefaultAgentSteps
+	}
+
+	seed := e.scopeNote() + agentUserPrompt(tb, e.data(), maxEntitiesPerType)
+	messages := []ai.Message{
+		{Role: ai.RoleSystem, Content: prompts.NecromancerSystemPrompt + fmt.Sprintf(prompts.AgentPrompt, budget.MaxSteps)},
+		{Role: ai.RoleUser, Content: seed},
+	}
+	// Tools and a forced output schema do not mix; the report call
+	// applies the schema
+	options := e.Options
+	options.Schema = nil
+
+	e.printf("[*] Agent: %s is exploring the graph (up to %d steps)...\n", b.Name, budget.MaxSteps)
+
+	var log []toolExchange
+	used := 0
+	for step := 1; ; step++ {
+		if step > budget.MaxSteps {
+			e.printf("[~] Agent: step limit reached (%d)\n", budget.MaxSteps)
+			break
+		}
+		req := &ai.ChatRequest{Messages: messages, Options: options, Tools: agentTools}
+		if budget.MaxTokens > 0 && used+worstCase(req).Total() > budget.MaxTokens {
+			e.printf("[~] Agent: token budget reached after %d step(s) (%d of %d tokens used)\n",
+				step-1, used, budget.MaxTokens)
+			break
+		}
+
+		reply, err := e.chat(ctx, b, req)
+		if err != nil {
+			if step == 1 || errors.Is(err, ErrBudgetExceeded) || ctx.Err() != nil {
+				return nil, err
+			}
+			e.printf("[!] Agent: step %d failed (%v); reporting on what was explored\n", step, err)
+			break
+		}
+		e.recordAttempt()
+		e.recordAgentStep()
+		used += reply.Usage.Total()
+
+		if len(reply.ToolCalls) == 0 {
+			paths, problem := e.parseFindings(reply.Text)
+			if problem == nil {
+				return paths, nil
+			}
+			e.printf("[~] Agent: answer rejected (%v); requesting a report\n", problem.Err)
+			break
+		}
+
+		messages = append(messages, ai.Message{Role: ai.RoleAssistant, Content: reply.Text, ToolCalls: reply.ToolCalls})
+		for _, call := range reply.ToolCalls {
+			result := e.runTool(tb, b, step, call)
+			messages = append(messages, ai.Message{
+				Role:       ai.RoleTool,
+				Content:    result,
+				ToolCallID: call.ID,
+				ToolName:   call.Name,
+			})
+			log = append(log, toolExchange{call: call, result: result})
+		}
+	}
+
+	report := fmt.Sprintf(prompts.AgentReportPrompt, seed, investigationLog(log))
+	return e.summonFindings(ctx, b, prompts.NecromancerSystemPrompt, report)
+}
+
+// runTool answers one tool call and records it
+func (e *Engine) runTool(tb *toolbox, b Backend, step int, call ai.ToolCall) string {
+	result, n, err := tb.call(call)
+
+	stats := ToolCallStats{
+		Backend:   b.Name,
+		Step:      step,
+		Tool:      call.Name,
+		Arguments: call.Arguments,
+		Results:   n,
+		Bytes:     len(result),
+	}
+	if err != nil {
+		stats.Error = err.Error()
+	}
+	if tb.tokenizer != nil {
+		stats.Arguments = tb.tokenizer.Detokenize(stats.Arguments)
+		stats.Error = tb.tokenizer.Detokenize(stats.Error)
+	}
+
+	if err != nil {
+		e.printf("[~] Agent step %d: %s %s failed: %s\n", step, call.Name, truncate(stats.Arguments, 120), stats.Error)
+	} else {
+		e.printf("[*] Agent step %d: %s %s (%d result(s))\n", step, call.Name, truncate(stats.Arguments, 120), n)
+	}
+
+	e.statsMu.Lock()
+	defer e.statsMu.Unlock()
+	e.Stats.ToolCalls = append(e.Stats.ToolCalls, stats)
+	return result
+}
+
+// recordAgentStep counts one tool-use turn
+func (e *Engine) recordAgentStep() {
+	e.statsMu.Lock()
+	defer e.statsMu.Unlock()
+	e.Stats.AgentSteps++
+}
+
+// agentUserPrompt opens the conversation with the size of the environment,
+// its Tier-0 objects and sampled starting points, named as the tools name
+// them
+func agentUserPrompt(tb *toolbox, data *bloodhound.BloodHoundData, maxEntitiesPerType int) string {
+	var targets []string
+	for _, n := range tb.graph.Nodes() {
+		id := strings.ToUpper(n.ObjectIdentifier)
+		if !tb.graph.IsTier0(id) {
+			continue
+		}
+		if len(targets) == agentTargets {
+			targets = append(targets, "- ... (search_nodes with highvalue finds more)")
+			break
+		}
+		targets = append(targets, "- "+describeNode(tb.view(id, "")))
+	}
+	if len(targets) == 0 {
+		targets = append(targets, "- (none loaded; edges into privileged builtin groups still lead to Tier 0)")
+	}
+
+	limit := min(maxEntitiesPerType, agentCandidates)
+	var candidates []string
+	for _, nodes := range [][]bloodhound.Node{data.Users, data.Computers} {
+		for _, n := range sampleNodes(nodes, limit) {
+			candidates = append(candidates, "- "+describeNode(tb.view(n.ObjectIdentifier, "")))
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = append(candidates, "- (none; use search_nodes)")
+	}
+
+	return fmt.Sprintf(prompts.AgentUserPrompt,
+		len(data.Users),
+		len(data.Groups),
+		len(data.Computers),
+		len(data.Domains),
+		strings.Join(targets, "\n"),
+		strings.Join(candidates, "\n"))
+}
+
+// describeNode renders a node on one line of a prompt
+func describeNode(v nodeView) string {
+	line := v.ID
+	if v.Name != "" {
+		line += " " + v.Name
+	}
+	return line + " (" + strings.Join(nodeFlags(v), ", ") + ")"
+}
+
+// nodeFlags lists the kind of a node followed by what makes it notable
+func nodeFlags(v nodeView) []string {
+	flags := []string{v.Kind}
+	if v.HighValue {
+		flags = append(flags, "highvalue")
+	}
+	if v.AdminCount {
+		flags = append(flags, "admincount")
+	}
+	if v.Enabled != nil && !*v.Enabled {
+		flags = append(flags, "disabled")
+	}
+	if v.PasswordAgeDays != nil {
+		flags = append(flags, fmt.Sprintf("password %d days old", *v.PasswordAgeDays))
+	}
+	return flags
+}
+
+// investigationLog renders the tool calls of an agentic run for the report
+// prompt
+func investigationLog(log []toolExchange) string {
+	if len(log) == 0 {
+		return "(no tools were called)"
+	}
+	var b strings.Builder
+	for i, ex := range log {
+		fmt.Fprintf(&b, "%d. %s %s\n%s\n\n", i+1, ex.call.Name, ex.call.Arguments, truncate(ex.result, agentLogResult))
+	}
+	return strings.TrimSpace(b.String())
+}
@@ -0,0 +1,270 @@
+package necromancy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"ad-necromancer/internal/ai"
+	"ad-necromancer/internal/pricing"
+)
+
+// ErrBudgetExceeded is returned instead of making a call that could take
+// the run over its Budget
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+// Budget caps what a run may spend; zero fields are unlimited
+type Budget struct {
+	MaxCost   float64 // USD
+	MaxTokens int     // Prompt plus completion tokens across all calls
+}
+
+// CallStats records one model call
+type CallStats struct {
+	Backend   string   `json:"backend"`
+	Model     string   `json:"model,omitempty"`
+	Domain    string   `json:"domain,omitempty"` // Domain analysed, in a per-domain run
+	Chunk     string   `json:"chunk"`            // Part of the run the call served, e.g. "triage"
+	Usage     ai.Usage `json:"usage"`
+	Cost      float64  `json:"cost"`       // USD; 0 when the model has no price
+	LatencyMs int64    `json:"latency_ms"` // Time until the response (or error) arrived
+	Error     string   `json:"error,omitempty"`
+}
+
+// UsageTotal sums the calls of one part of a run
+type UsageTotal struct {
+	Label string   `json:"label"`
+	Calls int      `json:"calls"`
+	Usage ai.Usage `json:"usage"`
+	Cost  float64  `json:"cost"` // USD, for calls to priced models
+}
+
+// Chunks a run's calls are labelled with; deep dives are labelled by rank
+const (
+	chunkAnalysis = "analysis" // Sampled prompt and its correction turns
+	chunkAgent    = "agent"
+	chunkFocus    = "focus"
+	chunkTriage   = "triage"
+)
+
+// deepDiveChunk labels the calls of the deep dive into the candidate of
+// the given rank
+func deepDiveChunk(rank int) string {
+	return fmt.Sprintf("deep dive #%d", rank)
+}
+
+type (
+	chunkKey  struct{}
+	domainKey struct{}
+)
+
+// withChunk labels the calls made under ctx with the part of the run they
+// serve
+func withChunk(ctx context.Context, chunk string) context.Context {
+	return context.WithValue(ctx, chunkKey{}, chunk)
+}
+
+// withDomain labels the calls made under ctx with the domain analysed
+func withDomain(ctx context.Context, domain string) context.Context {
+	return context.WithValue(ctx, domainKey{}, domain)
+}
+
+// callLabels returns the domain and chunk ctx is labelled with
+func callLabels(ctx context.Context) (domain, chunk string) {
+	domain, _ = ctx.Value(domainKey{}).(string)
+	chunk, _ = ctx.Value(chunkKey{}).(string)
+	if chunk == "" {
+		chunk = chunkAnalysis
+	}
+	return domain, chunk
+}
+
+// reservation is the worst case of a call in flight
+type reservation struct {
+	tokens int
+	cost   float64
+}
+
+// charsPerToken is a conservative estimate for JSON-heavy prompts
+const charsPerToken = 3
+
+// reserve checks that the call could not take the run over budget and
+// holds its worst case (estimated prompt plus the completion limit) until
+// settle. Concurrent ensemble calls reserve against the same budget.
+func (e *Engine) reserve(b Backend, req *ai.ChatRequest) (reservation, error) {
+	worst := worstCase(req)
+	r := reservation{tokens: worst.Total()}
+	price, priced := e.priceFor(b, b.Model)
+	if priced {
+		r.cost = price.Cost(worst)
+	}
+
+	e.statsMu.Lock()
+	defer e.statsMu.Unlock()
+
+	if limit := e.Budget.MaxTokens; limit > 0 {
+		left := limit - e.Stats.Usage.Total() - e.reserved.tokens
+		if r.tokens > left {
+			return reservation{}, fmt.Errorf("%w: the next call to %s could use up to %d tokens but only %d of %d are left (lower --max-tokens or --sample-size, or raise --max-tokens-total)",
+				ErrBudgetExceeded, b.Name, r.tokens, max(left, 0), limit)
+		}
+	}
+	if limit := e.Budget.MaxCost; limit > 0 {
+		if !priced && !e.warnedUnpriced[b.Name] {
+			e.printf("[!] No price for %s model %q; its calls do not count toward --max-cost\n", b.Name, b.Model)
+			if e.warnedUnpriced == nil {
+				e.warnedUnpriced = make(map[string]bool)
+			}
+			e.warnedUnpriced[b.Name] = true
+		}
+		left := limit - e.Stats.Cost - e.reserved.cost
+		if r.cost > left {
+			return reservation{}, fmt.Errorf("%w: the next call to %s could cost up to $%.4f but only $%.4f of $%.2f is left",
+				ErrBudgetExceeded, b.Name, r.cost, max(left, 0), limit)
+		}
+	}
+
+	e.reserved.tokens += r.tokens
+	e.reserved.cost += r.cost
+	return r, nil
+}
+
+// release drops a reservation whose call was never made
+func (e *Engine) release(r reservation) {
+	e.statsMu.Lock()
+	defer e.statsMu.Unlock()
+	e.reserved.tokens -= r.tokens
+	e.reserved.cost -= r.cost
+}
+
+// worstCase estimates the prompt of req, tool calls and definitions
+// included, and adds the completion limit
+func worstCase(req *ai.ChatRequest) ai.Usage {
+	chars := 0
+	for _, m := range req.Messages {
+		chars += len(m.Content)
+		for _, call := range m.ToolCalls {
+			chars += len(call.Name) + len(call.Arguments)
+		}
+	}
+	if len(req.Tools) > 0 {
+		raw, _ := json.Marshal(req.Tools)
+		chars += len(raw)
+	}
+	return ai.Usage{
+		PromptTokens:     chars/charsPerToken + 1,
+		CompletionTokens: req.Options.MaxTokensOrDefault(),
+	}
+}
+
+// settle releases a reservation and accounts the call's actual usage under
+// the labels of ctx
+func (e *Engine) settle(ctx context.Context, r reservation, b Backend, resp *ai.ChatResponse, err error, latency time.Duration) {
+	call := CallStats{Backend: b.Name, Model: b.Model, LatencyMs: latency.Milliseconds()}
+	call.Domain, call.Chunk = callLabels(ctx)
+	if err != nil {
+		call.Error = err.Error()
+	} else {
+		call.Usage = resp.Usage
+		if resp.Model != "" {
+			call.Model = resp.Model
+		}
+		if price, ok := e.priceFor(b, call.Model); ok {
+			call.Cost = price.Cost(call.Usage)
+		}
+	}
+
+	e.statsMu.Lock()
+	defer e.statsMu.Unlock()
+
+	e.reserved.tokens -= r.tokens
+	e.reserved.cost -= r.cost
+	e.Stats.Calls = append(e.Stats.Calls, call)
+	e.Stats.Usage.Add(call.Usage)
+	e.Stats.Latency += latency
+	e.Stats.Cost += call.Cost
+	if err == nil {
+		if _, ok := e.priceFor(b, call.Model); !ok && call.Usage.Total() > 0 {
+			e.Stats.addUnpriced(call.Model, b.Name)
+		}
+	}
+}
+
+// priceFor returns the price of model on backend b. Local backends are
+// free unless the price table lists the model.
+func (e *Engine) priceFor(b Backend, model string) (pricing.Price, bool) {
+	if p, ok := e.Prices.Lookup(model); ok {
+		return p, true
+	}
+	if b.Local {
+		return pricing.Price{}, true
+	}
+	return pricing.Price{}, false
+}
+
+// addUnpriced notes a model whose calls are missing from Cost
+func (s *RunStats) addUnpriced(model, backend string) {
+	if model == "" {
+		model = backend
+	}
+	for _, m := range s.Unpriced {
+		if m == model {
+			return
+		}
+	}
+	s.Unpriced = append(s.Unpriced, model)
+	sort.Strings(s.Unpriced)
+}
+
+// usageOf totals the calls match selects; callers hold statsMu
+func (s RunStats) usageOf(match func(CallStats) bool) (ai.Usage, float64) {
+	var usage ai.Usage
+	var cost float64
+	for _, c := range s.Calls {
+		if match(c) {
+			usage.Add(c.Usage)
+			cost += c.Cost
+		}
+	}
+	return usage, cost
+}
+
+// UsageByChunk totals the calls by the part of the run they served, with
+// the domain in a per-domain run, in the order the parts started
+func (s RunStats) UsageByChunk() []UsageTotal {
+	var totals []UsageTotal
+	index := make(map[string]int)
+	for _, c := range s.Calls {
+		label := c.Chunk
+		if c.Domain != "" {
+			label = c.Domain + " / " + c.Chunk
+		}
+		i, ok := index[label]
+		if !ok {
+			i = len(totals)
+			index[label] = i
+			totals = append(totals, UsageTotal{Label: label})
+		}
+		totals[i].Calls++
+		totals[i].Usage.Add(c.Usage)
+		totals[i].Cost += c.Cost
+	}
+	return totals
+}
+
+// SlowestCall returns the call that took longest, if any
+func (s RunStats) SlowestCall() (CallStats, bool) {
+	if len(s.Calls) == 0 {
+		return CallStats{}, false
+	}
+	slowest := s.Calls[0]
+	for _, c := range s.Calls[1:] {
+		if c.LatencyMs > slowest.LatencyMs {
+			slowest = c
+		}
+	}
+	return slowest, true
+}
@@ -0,0 +1,70 @@
+package necromancy
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"ad-necromancer/internal/ai"
+	"ad-necromancer/internal/pricing"
+)
+
+// usageClient answers every call with the same usage
+type usageClient struct{ usage ai.Usage }
+
+func (c *usageClient) Chat(ctx context.Context, req *ai.ChatRequest) (*ai.ChatResponse, error) {
+	return &ai.ChatResponse{Text: "[]", FinishReason: ai.FinishStop, Usage: c.usage}, nil
+}
+
+func (c *usageClient) Summon(systemPrompt, userPrompt string) (string, error) {
+	return ai.Summon(c, systemPrompt, userPrompt)
+}
+
+func TestUsageByChunk(t *testing.T) {
+	client := &usageClient{usage: ai.Usage{PromptTokens: 1000, CompletionTokens: 100}}
+	e := NewEngine(nil, client)
+	e.Progress = io.Discard
+	e.Prices = pricing.Table{"gpt-4o": {Input: 1000, Output: 10000}} // $2 a call
+	b := Backend{Name: "openai", Model: "gpt-4o", Client: client}
+	req := &ai.ChatRequest{Messages: []ai.Message{{Role: ai.RoleUser, Content: "user"}}}
+
+	corp := withDomain(context.Background(), "CORP.LOCAL")
+	for _, ctx := range []context.Context{
+		withChunk(corp, chunkTriage),
+		withChunk(corp, deepDiveChunk(1)),
+		withChunk(corp, deepDiveChunk(1)),
+		withChunk(withDomain(context.Background(), "LAB.LOCAL"), chunkTriage),
+		context.Background(),
+	} {
+		if _, err := e.chat(ctx, b, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if c := e.Stats.Calls[1]; c.Domain != "CORP.LOCAL" || c.Chunk != "deep dive #1" {
+		t.Errorf("call = %+v, want the domain and chunk of its context", c)
+	}
+	if c := e.Stats.Calls[4]; c.Domain != "" || c.Chunk != chunkAnalysis {
+		t.Errorf("unlabelled call = %+v, want the analysis chunk", c)
+	}
+
+	totals := e.Stats.UsageByChunk()
+	labels := []string{"CORP.LOCAL / triage", "CORP.LOCAL / deep dive #1", "LAB.LOCAL / triage", "analysis"}
+	if len(totals) != len(labels) {
+		t.Fatalf("totals = %+v, want %v", totals, labels)
+	}
+	for i, label := range labels {
+		if totals[i].Label != label {
+			t.Errorf("totals[%d] = %q, want %q", i, totals[i].Label, label)
+		}
+	}
+	dive := totals[1]
+	if dive.Calls != 2 || dive.Usage.Total() != 2200 || dive.Cost != 4 {
+		t.Errorf("deep dive total = %+v, want both of its calls", dive)
+	}
+
+	usage, cost := e.Stats.usageOf(func(c CallStats) bool { return c.Domain == "CORP.LOCAL" })
+	if usage.Total() != 3300 || cost != 6 {
+		t.Errorf("CORP.LOCAL used %d tokens $%.4f, want its three calls", usage.Total(), cost)
+	}
+}
@@ -0,0 +1,291 @@
+package necromancy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"ad-necromancer/internal/ai"
+	"ad-necromancer/internal/prompts"
+)
+
+// DefaultMaxAttempts bounds the self-correction loop (first call included)
+const DefaultMaxAttempts = 3
+
+// RunStats records the model calls made during the last run
+type RunStats struct {
+	Attempts  int      // Answered model calls, including self-correction turns
+	Usage     ai.Usage // Tokens consumed across all calls
+	Backend   string   // Backend that produced the findings
+	Failovers int      // Backends abandoned before that one
+
+	Cost     float64       // USD, for calls to priced models
+	Unpriced []string      // Models without a price; their calls are not in Cost
+	Latency  time.Duration // Total time spent waiting for models
+	Calls    []CallStats   // Every call in order of completion, failed ones included
+
+	AgentSteps int             // Tool-use turns in agentic mode
+	ToolCalls  []ToolCallStats // Tools the model called in agentic mode, in order
+	DeepDives  []DeepDiveStats // Candidates analysed in a two-phase run, by backend and rank
+
+	Domains    []DomainStats     // Domains of a per-domain run, in the order analysed
+	CrossTrust []CrossTrustGroup // Paths to Tier 0 across domains, by trust, in a per-domain run
+
+	RateWait time.Duration // Time calls spent waiting for rate limits
+}
+
+// outputProblem describes why (part of) a model response was rejected
+type outputProblem struct {
+	Err      error  // Parse or validation error
+	Fragment string // Excerpt of the output that caused it
+}
+
+// summonFindings chats with b and, when its output fails to parse or
+// validate, continues the conversation with the error and the offending
+// fragment, asking for corrected findings. Findings from every attempt are
+// merged. Returned findings are still in tokenized form. If ctx is
+// cancelled, the findings gathered so far are returned with ctx's error.
+func (e *Engine) summonFindings(ctx context.Context, b Backend, systemPrompt, userPrompt string) ([]ZombiePath, error) {
+	maxAttempts := e.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	options := e.Options
+	options.Schema = FindingsSchema()
+	messages := []ai.Message{
+		{Role: ai.RoleSystem, Content: systemPrompt},
+		{Role: ai.RoleUser, Content: userPrompt},
+	}
+
+	var accepted []ZombiePath
+	seen := make(map[string]bool)
+	var lastProblem *outputProblem
+	attempts := 0
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		reply, err := e.chat(ctx, b, &ai.ChatRequest{Messages: messages, Options: options})
+		if err != nil {
+			if errors.Is(err, ErrBudgetExceeded) {
+				// Partial findings are kept; the caller must not fail over
+				return accepted, err
+			}
+			if ctx.Err() != nil {
+				return accepted, ctx.Err()
+			}
+			if len(accepted) > 0 {
+				// Keep what earlier attempts produced rather than losing the run
+				e.printf("[!] Correction attempt failed (%v); keeping %d finding(s)\n", err, len(accepted))
+				return accepted, nil
+			}
+			return nil, err
+		}
+		attempts++
+		e.recordAttempt()
+
+		if reply.FinishReason == ai.FinishLength {
+			e.printf("[!] Model output hit the max token limit and was truncated\n")
+		}
+
+		paths, problem := e.parseFindings(reply.Text)
+		for _, p := range paths {
+			key := findingKey(p)
+			if !seen[key] {
+				seen[key] = true
+				accepted = append(accepted, p)
+			}
+		}
+
+		lastProblem = problem
+		if problem == nil || attempt == maxAttempts {
+			break
+		}
+
+		e.printf("[~] Model output rejected (%v); requesting a correction (attempt %d/%d)...\n",
+			problem.Err, attempt+1, maxAttempts)
+		messages = append(messages,
+			ai.Message{Role: ai.RoleAssistant, Content: reply.Text},
+			ai.Message{Role: ai.RoleUser, Content: correctionPrompt(problem, accepted)},
+		)
+	}
+
+	if len(accepted) == 0 && lastProblem != nil {
+		return nil, fmt.Errorf("failed to parse LLM response as JSON after %d attempt(s): %w",
+			attempts, lastProblem.Err)
+	}
+	if lastProblem != nil {
+		e.printf("[!] Giving up on corrections after %d attempt(s): %v\n", attempts, lastProblem.Err)
+	}
+
+	return accepted, nil
+}
+
+// recordAttempt counts one answered model call; ensemble members call it
+// concurrently
+func (e *Engine) recordAttempt() {
+	e.statsMu.Lock()
+	defer e.statsMu.Unlock()
+	e.Stats.Attempts++
+}
+
+// chat performs one model call to b bounded by the engine's per-call
+// timeout and budget, once b's rate limits and a worker slot allow it, and
+// accounts its usage, cost and latency
+func (e *Engine) chat(ctx context.Context, b Backend, req *ai.ChatRequest) (*ai.ChatResponse, error) {
+	r, err := e.reserve(b, req)
+	if err != nil {
+		return nil, err
+	}
+
+	limiter := e.limiter(b)
+	waited, err := limiter.Wait(ctx, r.tokens)
+	e.recordRateWait(waited)
+	if err != nil {
+		e.release(r)
+		return nil, err
+	}
+	release, err := e.acquireWorker(ctx)
+	if err != nil {
+		limiter.Settle(r.tokens, 0)
+		e.release(r)
+		return nil, err
+	}
+	defer release()
+
+	if e.CallTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.CallTimeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	resp, err := b.Client.Chat(ctx, req)
+	e.settle(ctx, r, b, resp, err, time.Since(start))
+
+	// Failed calls are not charged to the rate limit; calls that report no
+	// usage keep the estimate
+	switch {
+	case err != nil:
+		limiter.Settle(r.tokens, 0)
+	case resp.Usage.Total() > 0:
+		limiter.Settle(r.tokens, resp.Usage.Total())
+	}
+	return resp, err
+}
+
+// parseFindings extracts valid findings from one model response. A non-nil
+// problem means part of the response was lost and a correction is worthwhile.
+func (e *Engine) parseFindings(response string) ([]ZombiePath, *outputProblem) {
+	cleanJson := cleanJSON(response)
+
+	var problems []string
+	var fragment string
+
+	paths, err := decodeFindings(cleanJson)
+	if err != nil {
+		// Try to fix common JSON issues from LLMs and salvage complete findings
+		var report RepairReport
+		paths, report = repairFindings(cleanJson)
+		e.printf("[~] Repaired malformed model output: %s\n", report)
+
+		if report.Salvaged == 0 || report.Discarded > 0 || report.Truncated {
+			problems = append(problems, err.Error())
+			fragment = errorFragment(cleanJson, err, report.Truncated)
+		}
+	}
+
+	valid := paths[:0]
+	for _, p := range paths {
+		if vErr := validateFinding(p); vErr != nil {
+			problems = append(problems, fmt.Sprintf("finding %q: %v", p.Title, vErr))
+			if fragment == "" {
+				raw, _ := json.Marshal(p)
+				fragment = truncate(string(raw), 600)
+			}
+			continue
+		}
+		valid = append(valid, p)
+	}
+
+	if len(problems) == 0 {
+		return valid, nil
+	}
+	return valid, &outputProblem{
+		Err:      errors.New(strings.Join(problems, "; ")),
+		Fragment: fragment,
+	}
+}
+
+// cleanJSON strips the markdown code fence models often put around JSON
+func cleanJSON(response string) string {
+	clean := strings.TrimSpace(response)
+	clean = strings.TrimPrefix(clean, "```json")
+	clean = strings.TrimPrefix(clean, "```")
+	clean = strings.TrimSuffix(clean, "```")
+	return strings.TrimSpace(clean)
+}
+
+// validateFinding enforces the fields the report cannot do without
+func validateFinding(p ZombiePath) error {
+	var missing []string
+	if strings.TrimSpace(p.Title) == "" {
+		missing = append(missing, "Title")
+	}
+	if strings.TrimSpace(p.Reasoning) == "" {
+		missing = append(missing, "Reasoning")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required field(s): %s", strings.Join(missing, ", "))
+	}
+
+	switch p.Probability {
+	case "Critical", "High", "Medium", "Low":
+		return nil
+	}
+	return fmt.Errorf("Probability must be Critical, High, Medium or Low (got %q)", p.Probability)
+}
+
+// errorFragment returns the part of the output around a syntax error, or the
+// tail of the output when it was truncated
+func errorFragment(text string, err error, truncated bool) string {
+	const window = 300
+
+	var syntaxErr *json.SyntaxError
+	if !truncated && errors.As(err, &syntaxErr) {
+		start := int(syntaxErr.Offset) - window/2
+		if start < 0 {
+			start = 0
+		}
+		end := start + window
+		if end > len(text) {
+			end = len(text)
+		}
+		return "..." + text[start:end] + "..."
+	}
+
+	if len(text) > window {
+		return "..." + text[len(text)-window:]
+	}
+	return text
+}
+
+// correctionPrompt builds the follow-up turn asking for a corrected array
+func correctionPrompt(problem *outputProblem, accepted []ZombiePath) string {
+	titles := "(none)"
+	if len(accepted) > 0 {
+		lines := make([]string, 0, len(accepted))
+		for _, p := range accepted {
+			lines = append(lines, "- "+p.Title)
+		}
+		titles = strings.Join(lines, "\n")
+	}
+	return fmt.Sprintf(prompts.CorrectionPrompt, problem.Err, problem.Fragment, titles)
+}
+
+// findingKey identifies a finding across attempts
+func findingKey(p ZombiePath) string {
+	return strings.ToLower(strings.TrimSpace(p.EntityName)) + "|" + strings.ToLower(strings.TrimSpace(p.Title))
+}
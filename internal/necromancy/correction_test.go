@@ -0,0 +1,84 @@
+package necromancy
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"ad-necromancer/internal/ai"
+)
+
+// scriptedClient answers each Chat call with the next reply and records
+// the conversations it was sent
+type scriptedClient struct {
+	replies  []string
+	requests []*ai.ChatRequest
+}
+
+func (c *scriptedClient) Chat(ctx context.Context, req *ai.ChatRequest) (*ai.ChatResponse, error) {
+	c.requests = append(c.requests, req)
+	reply := c.replies[0]
+	c.replies = c.replies[1:]
+	return &ai.ChatResponse{Text: reply, FinishReason: ai.FinishStop}, nil
+}
+
+func (c *scriptedClient) Summon(systemPrompt, userPrompt string) (string, error) {
+	return ai.Summon(c, systemPrompt, userPrompt)
+}
+
+const validFinding = `{"Title": "A", "Reasoning": "r", "Probability": "High"}`
+
+func TestSummonFindingsCorrectsOverChat(t *testing.T) {
+	client := &scriptedClient{replies: []string{
+		`{"findings": [` + validFinding + `, {"Title": "B", "Reasoning": "r", "Probability": "Severe"}]}`,
+		`{"findings": [{"Title": "B", "Reasoning": "r", "Probability": "Critical"}]}`,
+	}}
+	e := NewEngine(nil, client)
+	e.Progress = io.Discard
+
+	paths, err := e.summonFindings(context.Background(), Backend{Name: "test", Client: client}, "system", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 || paths[0].Title != "A" || paths[1].Title != "B" {
+		t.Errorf("findings = %+v, want A from the first answer and B from the correction", paths)
+	}
+
+	// The correction continues the same conversation
+	if len(client.requests) != 2 {
+		t.Fatalf("%d calls, want 2", len(client.requests))
+	}
+	turns := client.requests[1].Messages
+	roles := make([]string, len(turns))
+	for i, m := range turns {
+		roles[i] = m.Role
+	}
+	if strings.Join(roles, ",") != "system,user,assistant,user" {
+		t.Errorf("correction roles = %v", roles)
+	}
+	if !strings.Contains(turns[3].Content, "Severe") {
+		t.Errorf("correction prompt does not quote the rejected value:\n%s", turns[3].Content)
+	}
+	if client.requests[1].Options.Schema == nil {
+		t.Error("correction sent without the findings schema")
+	}
+	if e.Stats.Attempts != 2 {
+		t.Errorf("Attempts = %d, want 2", e.Stats.Attempts)
+	}
+}
+
+func TestSummonFindingsGivesUpAfterMaxAttempts(t *testing.T) {
+	client := &scriptedClient{replies: []string{"no JSON here", "still none", "nope"}}
+	e := NewEngine(nil, client)
+	e.Progress = io.Discard
+	e.MaxAttempts = 3
+
+	_, err := e.summonFindings(context.Background(), Backend{Name: "test", Client: client}, "system", "user")
+	if err == nil || !strings.Contains(err.Error(), "after 3 attempt(s)") {
+		t.Errorf("err = %v, want a failure after 3 attempts", err)
+	}
+	if len(client.requests) != 3 {
+		t.Errorf("%d calls, want 3", len(client.requests))
+	}
+}
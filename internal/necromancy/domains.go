@@ -0,0 +1,297 @@
+package necromancy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"ad-necromancer/internal/ai"
+	"ad-necromancer/internal/bloodhound"
+	"ad-necromancer/internal/prompts"
+)
+
+// domainBoundaryMax caps the boundary edges listed in a domain's prompts
+const domainBoundaryMax = 200
+
+// NoDomain names the objects of no known domain in per-domain runs
+const NoDomain = "(no domain)"
+
+// DomainStats records the analysis of one domain in a per-domain run
+type DomainStats struct {
+	Domain   string   `json:"domain"`
+	Objects  int      `json:"objects"`
+	Boundary int      `json:"boundary_edges"`   // Edges to or from other domains
+	Trusts   []string `json:"trusts,omitempty"` // e.g. "CHILD.CORP.LOCAL (ParentChild, Bidirectional, transitive, no SID filtering)"
+	Findings int      `json:"findings"`
+	Usage    ai.Usage `json:"usage"`
+	Cost     float64  `json:"cost"`              // USD, for calls to priced models
+	Skipped  bool     `json:"skipped,omitempty"` // No users, groups or computers to analyse
+	Error    string   `json:"error,omitempty"`
+}
+
+// CrossTrustPath is the shortest control path from an object of one
+// domain, across a boundary edge into another domain, to a Tier 0 object
+type CrossTrustPath struct {
+	Source      string   `json:"source"` // Name, or object identifier when not loaded
+	Tier0       string   `json:"tier0"`  // Tier 0 object the path ends at
+	Tier0Domain string   `json:"tier0_domain"`
+	Hops        int      `json:"hops"`
+	Steps       []string `json:"steps"` // One edge per step, e.g. "SVC_OLD -GenericAll-> DOMAIN ADMINS"
+}
+
+// CrossTrustGroup collects the cross-domain paths entering one domain from
+// another, i.e. the control that crosses one trust
+type CrossTrustGroup struct {
+	SourceDomain string           `json:"source_domain"`
+	TargetDomain string           `json:"target_domain"`   // Domain the boundary edges enter
+	Trust        string           `json:"trust,omitempty"` // Direct trust between the domains, if loaded
+	Edges        int              `json:"boundary_edges"`  // From SourceDomain into TargetDomain
+	Paths        []CrossTrustPath `json:"paths"`           // Shortest first; one per source object
+}
+
+// domainScope is the domain a per-domain run is analysing
+type domainScope struct {
+	*bloodhound.Partition
+	graph   *bloodhound.Graph // Of the domain's objects only
+	domains int               // In the collection
+}
+
+// summonDomains runs summon once per domain of the collection, each time
+// on the domain's objects only, with the edges crossing its boundary
+// listed in the prompts. Domains are analysed one after another, as the
+// failover chain may switch the cloak on between them; the calls within a
+// domain still run concurrently. A domain that fails does not fail the
+// others. Findings are tagged with their domain.
+func (e *Engine) summonDomains(ctx context.Context, summon func(context.Context, int) ([]ZombiePath, error), maxEntitiesPerType int) ([]ZombiePath, error) {
+	forest := e.forest()
+	parts := forest.Partition()
+	e.recordCrossTrust(forest, parts)
+	crossing := 0
+	for _, g := range e.Stats.CrossTrust {
+		crossing += len(g.Paths)
+	}
+	e.printf("[*] Per-domain analysis: %d domain(s), %d cross-domain path(s) to Tier 0\n", len(parts), crossing)
+
+	var all []ZombiePath
+	var lastErr, stopErr error
+	failed, analysed := 0, 0
+	for i, p := range parts {
+		stats := DomainStats{
+			Domain:   domainLabel(p.Domain),
+			Objects:  p.Objects(),
+			Boundary: len(p.Boundary),
+		}
+		for _, t := range p.Trusts {
+			stats.Trusts = append(stats.Trusts, fmt.Sprintf("%s (%s)", t.TargetDomainName, t))
+		}
+		if len(p.Data.Users)+len(p.Data.Groups)+len(p.Data.Computers) == 0 {
+			stats.Skipped = true
+			e.Stats.Domains = append(e.Stats.Domains, stats)
+			continue
+		}
+
+		e.printf("\n[*] Domain %d/%d: %s (%d objects, %d boundary edge(s))\n",
+			i+1, len(parts), stats.Domain, stats.Objects, stats.Boundary)
+		e.scope = &domainScope{Partition: p, graph: bloodhound.NewGraph(&p.Data), domains: len(parts)}
+		paths, err := summon(withDomain(ctx, stats.Domain), maxEntitiesPerType)
+		e.scope = nil
+
+		e.statsMu.Lock()
+		stats.Usage, stats.Cost = e.Stats.usageOf(func(call CallStats) bool { return call.Domain == stats.Domain })
+		e.statsMu.Unlock()
+
+		analysed++
+		for j := range paths {
+			paths[j].Domain = stats.Domain
+		}
+		all = append(all, paths...)
+		stats.Findings = len(paths)
+		if err != nil {
+			stats.Error = err.Error()
+			failed++
+			lastErr = err
+		}
+		e.Stats.Domains = append(e.Stats.Domains, stats)
+
+		// Other domains would run into the same budget or deadline
+		if err != nil && (ctx.Err() != nil || errors.Is(err, ErrBudgetExceeded)) {
+			stopErr = err
+			break
+		}
+		if err != nil {
+			e.printf("[!] Domain %s failed: %v\n", stats.Domain, err)
+		}
+	}
+
+	switch {
+	case stopErr != nil:
+		return all, stopErr
+	case analysed == 0:
+		return nil, fmt.Errorf("no domain has users, groups or computers to analyse")
+	case failed == analysed:
+		return nil, fmt.Errorf("all %d domains failed; last error: %w", failed, lastErr)
+	}
+	return all, nil
+}
+
+// scopeNote introduces the domain being analysed in a per-domain run, with
+// its trusts and boundary edges named as the model sees them; it is empty
+// otherwise
+func (e *Engine) scopeNote() string {
+	if e.scope == nil {
+		return ""
+	}
+	// The other end of a boundary edge is only in the forest graph
+	tb := e.toolbox()
+	tb.graph = e.forest()
+
+	var trusts []string
+	for _, t := range e.scope.Trusts {
+		trusts = append(trusts, fmt.Sprintf("- %s: %s", tb.domainRef(t.TargetDomainName), t))
+	}
+	if len(trusts) == 0 {
+		trusts = append(trusts, "- (none loaded)")
+	}
+
+	var boundary []string
+	for i, edge := range e.scope.Boundary {
+		if i == domainBoundaryMax {
+			boundary = append(boundary, fmt.Sprintf("- ... %d more", len(e.scope.Boundary)-i))
+			break
+		}
+		boundary = append(boundary, "- "+tb.boundaryLine(edge))
+	}
+	if len(boundary) == 0 {
+		boundary = append(boundary, "- (none)")
+	}
+
+	return fmt.Sprintf(prompts.DomainScopePrompt, tb.domainRef(e.scope.Domain), e.scope.domains,
+		strings.Join(trusts, "\n"), strings.Join(boundary, "\n"))
+}
+
+// boundaryLine renders an edge with the kind and domain of both ends
+func (tb *toolbox) boundaryLine(edge bloodhound.Edge) string {
+	end := func(id, kind string) string {
+		v := tb.view(id, kind)
+		return fmt.Sprintf("%s (%s, %s)", v.ID, v.Kind, tb.domainRef(tb.graph.DomainOf(id)))
+	}
+	return fmt.Sprintf("%s -%s-> %s", end(edge.Source, edge.SourceKind), edge.Kind, end(edge.Target, ""))
+}
+
+// domainRef is the name the model sees for a domain
+func (tb *toolbox) domainRef(domain string) string {
+	switch {
+	case domain == "":
+		return "unknown domain"
+	case tb.tokenizer != nil:
+		return tb.tokenizer.TokenizeDomain(domain)
+	}
+	return domain
+}
+
+// recordCrossTrust finds, for every object with an edge into another
+// domain, the shortest path through that edge to a Tier 0 object of the
+// forest, and groups the paths by the pair of domains the edge connects.
+// Groups with the shortest paths come first.
+func (e *Engine) recordCrossTrust(forest *bloodhound.Graph, parts []*bloodhound.Partition) {
+	name := func(id string) string {
+		if n, ok := forest.Lookup(id); ok && n.Properties.Name != "" {
+			return n.Properties.Name
+		}
+		return id
+	}
+
+	type pair struct{ from, to string }
+	groups := make(map[pair]*CrossTrustGroup)
+	var order []pair
+	best := make(map[pair]map[string]int) // Source -> index of its path in the group
+
+	seen := make(map[bloodhound.Edge]bool)
+	for _, p := range parts {
+		for _, edge := range p.Boundary {
+			if seen[edge] {
+				continue
+			}
+			seen[edge] = true
+
+			key := pair{forest.DomainOf(edge.Source), forest.DomainOf(edge.Target)}
+			g, ok := groups[key]
+			if !ok {
+				g = &CrossTrustGroup{SourceDomain: key.from, TargetDomain: key.to}
+				if t, ok := forest.TrustBetween(key.from, key.to); ok {
+					g.Trust = t.String()
+				}
+				groups[key] = g
+				best[key] = make(map[string]int)
+				order = append(order, key)
+			}
+			g.Edges++
+
+			// The boundary edge, then the shortest way on from its target
+			rest, ok := forest.ShortestPathToTier0(edge.Target, triageMaxHops-1)
+			if !ok {
+				continue
+			}
+			steps := append([]bloodhound.Edge{edge}, rest...)
+			last := steps[len(steps)-1].Target
+			path := CrossTrustPath{
+				Source:      name(edge.Source),
+				Tier0:       name(last),
+				Tier0Domain: forest.DomainOf(last),
+				Hops:        len(steps),
+			}
+			for _, step := range steps {
+				path.Steps = append(path.Steps, fmt.Sprintf("%s -%s-> %s", name(step.Source), step.Kind, name(step.Target)))
+			}
+
+			if i, ok := best[key][edge.Source]; ok {
+				if path.Hops < g.Paths[i].Hops {
+					g.Paths[i] = path
+				}
+				continue
+			}
+			best[key][edge.Source] = len(g.Paths)
+			g.Paths = append(g.Paths, path)
+		}
+	}
+
+	var out []CrossTrustGroup
+	for _, key := range order {
+		g := groups[key]
+		sort.SliceStable(g.Paths, func(i, j int) bool { return g.Paths[i].Hops < g.Paths[j].Hops })
+		out = append(out, *g)
+	}
+	shortest := func(g CrossTrustGroup) int {
+		if len(g.Paths) == 0 {
+			return triageMaxHops + 1
+		}
+		return g.Paths[0].Hops
+	}
+	sort.SliceStable(out, func(i, j int) bool { return shortest(out[i]) < shortest(out[j]) })
+	e.Stats.CrossTrust = out
+}
+
+// sortByDomain groups findings by domain in the order the domains were
+// analysed, keeping the existing order within each
+func (e *Engine) sortByDomain(paths []ZombiePath) {
+	if !e.PerDomain {
+		return
+	}
+	rank := make(map[string]int, len(e.Stats.Domains))
+	for i, d := range e.Stats.Domains {
+		rank[d.Domain] = i
+	}
+	sort.SliceStable(paths, func(i, j int) bool {
+		return rank[paths[i].Domain] < rank[paths[j].Domain]
+	})
+}
+
+// domainLabel names a partition's domain in stats and findings
+func domainLabel(domain string) string {
+	if domain == "" {
+		return NoDomain
+	}
+	return domain
+}
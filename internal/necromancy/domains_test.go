@@ -0,0 +1,102 @@
+package necromancy
+
+import (
+	"reflect"
+	"testing"
+
+	"ad-necromancer/internal/bloodhound"
+)
+
+// forestFixture is a parent and child domain: EVE in the child reaches the
+// parent's Domain Admins through a foreign group membership, BOB crosses
+// the trust without reaching Tier 0
+func forestFixture() *bloodhound.BloodHoundData {
+	node := func(id, name, domain string) bloodhound.Node {
+		return bloodhound.Node{ObjectIdentifier: id, Properties: bloodhound.Properties{Name: name, Domain: domain}}
+	}
+	trust := func(sid, name string) []bloodhound.Trust {
+		return []bloodhound.Trust{{
+			TargetDomainSid: sid, TargetDomainName: name, IsTransitive: true,
+			TrustDirection: "Bidirectional", TrustType: "ParentChild",
+		}}
+	}
+	ace := func(principal, right string) []bloodhound.Ace {
+		return []bloodhound.Ace{{PrincipalSID: principal, PrincipalType: "User", RightName: right}}
+	}
+	member := func(id string) []bloodhound.Member {
+		return []bloodhound.Member{{ObjectIdentifier: id, ObjectType: "User"}}
+	}
+
+	corp := node("S-1-5-21-100", "CORP.LOCAL", "CORP.LOCAL")
+	corp.Trusts = trust("S-1-5-21-200", "CHILD.CORP.LOCAL")
+	child := node("S-1-5-21-200", "CHILD.CORP.LOCAL", "CHILD.CORP.LOCAL")
+	child.Trusts = trust("S-1-5-21-100", "CORP.LOCAL")
+
+	finance := node("S-1-5-21-100-1200", "FINANCE ADMINS@CORP.LOCAL", "CORP.LOCAL")
+	finance.Members = member("S-1-5-21-200-1105")
+	admins := node("S-1-5-21-100-512", "DOMAIN ADMINS@CORP.LOCAL", "CORP.LOCAL")
+	admins.Aces = ace("S-1-5-21-100-1200", "AddMember")
+	helpdesk := node("S-1-5-21-100-1300", "HELPDESK@CORP.LOCAL", "CORP.LOCAL")
+	helpdesk.Members = member("S-1-5-21-200-1106")
+	helpdesk.Aces = ace("S-1-5-21-200-1105", "GenericWrite")
+
+	return &bloodhound.BloodHoundData{
+		Domains: []bloodhound.Node{corp, child},
+		Users: []bloodhound.Node{
+			node("S-1-5-21-200-1105", "EVE@CHILD.CORP.LOCAL", "CHILD.CORP.LOCAL"),
+			node("S-1-5-21-200-1106", "BOB@CHILD.CORP.LOCAL", "CHILD.CORP.LOCAL"),
+		},
+		Groups: []bloodhound.Node{finance, admins, helpdesk},
+	}
+}
+
+func TestRecordCrossTrust(t *testing.T) {
+	forest := bloodhound.NewGraph(forestFixture())
+	e := &Engine{}
+	e.recordCrossTrust(forest, forest.Partition())
+
+	want := []CrossTrustGroup{{
+		SourceDomain: "CHILD.CORP.LOCAL",
+		TargetDomain: "CORP.LOCAL",
+		Trust:        "ParentChild, Bidirectional, transitive, no SID filtering",
+		Edges:        3,
+		Paths: []CrossTrustPath{{
+			Source:      "EVE@CHILD.CORP.LOCAL",
+			Tier0:       "DOMAIN ADMINS@CORP.LOCAL",
+			Tier0Domain: "CORP.LOCAL",
+			Hops:        2,
+			Steps: []string{
+				"EVE@CHILD.CORP.LOCAL -MemberOf-> FINANCE ADMINS@CORP.LOCAL",
+				"FINANCE ADMINS@CORP.LOCAL -AddMember-> DOMAIN ADMINS@CORP.LOCAL",
+			},
+		}},
+	}}
+	if !reflect.DeepEqual(e.Stats.CrossTrust, want) {
+		t.Errorf("CrossTrust =\n%+v\nwant\n%+v", e.Stats.CrossTrust, want)
+	}
+}
+
+func TestRecordCrossTrustOrdersByShortestPath(t *testing.T) {
+	data := forestFixture()
+	// A parent admin with direct control of the child domain object
+	data.Users = append(data.Users, bloodhound.Node{
+		ObjectIdentifier: "S-1-5-21-100-1107",
+		Properties:       bloodhound.Properties{Name: "CAROL@CORP.LOCAL", Domain: "CORP.LOCAL"},
+	})
+	data.Domains[1].Aces = []bloodhound.Ace{{PrincipalSID: "S-1-5-21-100-1107", PrincipalType: "User", RightName: "GenericAll"}}
+
+	forest := bloodhound.NewGraph(data)
+	e := &Engine{}
+	e.recordCrossTrust(forest, forest.Partition())
+
+	var order []string
+	for _, g := range e.Stats.CrossTrust {
+		order = append(order, g.SourceDomain+" -> "+g.TargetDomain)
+	}
+	if want := []string{"CORP.LOCAL -> CHILD.CORP.LOCAL", "CHILD.CORP.LOCAL -> CORP.LOCAL"}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("groups = %v, want %v", order, want)
+	}
+	if p := e.Stats.CrossTrust[0].Paths[0]; p.Hops != 1 || p.Tier0 != "CHILD.CORP.LOCAL" {
+		t.Errorf("shortest path = %+v, want CAROL's 1-hop GenericAll on the child domain", p)
+	}
+}
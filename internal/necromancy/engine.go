@@ -1,14 +1,20 @@
 package necromancy
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"ad-necromancer/internal/ai"
 	"ad-necromancer/internal/bloodhound"
+	"ad-necromancer/internal/pricing"
 	"ad-necromancer/internal/privacy"
-	"ad-necromancer/internal/prompts"
+	"ad-necromancer/internal/ratelimit"
 )
 
 type Engine struct {
@@ -16,6 +22,62 @@ type Engine struct {
 	AIClient     ai.AIClient
 	Tokenizer    *privacy.Tokenizer
 	CloakEnabled bool
+	Options      ai.Options    // Sampling options for every model call (schema is set by the engine)
+	MaxAttempts  int           // Model calls allowed per backend, including corrections
+	CallTimeout  time.Duration // Deadline for each model call (0 = client default)
+	Stats        RunStats      // Populated by the last run
+	Progress     io.Writer     // Progress messages; os.Stdout when nil
+	statsMu      sync.Mutex
+
+	// Failover chain
+	BackendName string    // Name of AIClient, recorded on its findings
+	Local       bool      // AIClient keeps prompts on this machine/network
+	Fallbacks   []Backend // Tried in order when AIClient fails
+	// AllowUncloakedRemote lets fallbacks receive real data when the cloak
+	// was disabled explicitly; otherwise failing over from a local to a
+	// remote backend turns the cloak on
+	AllowUncloakedRemote bool
+	// TokenSalt salts a tokenizer the engine creates when it turns the
+	// cloak on itself; random when empty
+	TokenSalt string
+
+	// Ensemble members; when set, each analyses the same prompt
+	// concurrently and findings are merged by consensus
+	Ensemble []Backend
+
+	// Agentic mode: backends that call tools explore the loaded graph
+	// instead of analysing a sample
+	Agent       bool
+	AgentBudget AgentBudget
+	graphOnce   sync.Once
+	graph       *bloodhound.Graph
+
+	// Two-phase runs: the model ranks candidates from an overview of all
+	// entities, then analyses the top ones in depth
+	Triage        bool
+	TriageOptions TriageOptions
+
+	// Focused runs: only the neighbourhood of the targets is analysed
+	Focus FocusOptions
+	focus *bloodhound.Subgraph // Extracted at the start of the run
+
+	// Per-domain runs: each domain is analysed on its own, with the edges
+	// crossing into other domains listed explicitly
+	PerDomain bool
+	scope     *domainScope // Domain being analysed; nil outside per-domain runs
+
+	// Scheduling
+	Workers    int                         // Model calls in flight at once across the run (0 = unlimited)
+	RateLimits map[string]ratelimit.Limits // By registered backend name, e.g. "openai"
+	workers    chan struct{}               // Worker slots of the current run
+	limiters   map[string]*ratelimit.Limiter
+
+	// Cost accounting
+	Model          string        // Model of AIClient, for pricing calls before it reports one
+	Prices         pricing.Table // USD per million tokens by model
+	Budget         Budget        // Calls that could exceed it are not made
+	reserved       reservation   // Worst case of calls in flight; guarded by statsMu
+	warnedUnpriced map[string]bool
 }
 
 type ZombiePath struct {
@@ -44,6 +106,15 @@ type ZombiePath struct {
 	// MITRE ATT&CK mapping (1-3 techniques max, output annotation only)
 	MitreAttack []string `json:"MitreAttack,omitempty"` // e.g., ["T1484.001", "T1558.003"]
 
+	// Provenance, set by the engine rather than the model
+	Backend string `json:"Backend,omitempty" schema:"-"` // AI backend(s) that produced the finding
+	Domain  string `json:"Domain,omitempty" schema:"-"`  // Domain analysed, in per-domain runs
+
+	// Ensemble consensus, set by the engine in ensemble mode
+	Agreement          int               `json:"Agreement,omitempty" schema:"-"`          // Models that reported the finding
+	EnsembleSize       int               `json:"EnsembleSize,omitempty" schema:"-"`       // Models that answered
+	ModelProbabilities map[string]string `json:"ModelProbabilities,omitempty" schema:"-"` // Backend -> its Probability
+
 	// Legacy fields for backward compatibility
 	Description  string   `json:"Description,omitempty"`
 	Command      string   `json:"Command,omitempty"`
@@ -54,11 +125,21 @@ type ZombiePath struct {
 // NewEngine creates a new necromancy engine
 func NewEngine(loader *bloodhound.Loader, client ai.AIClient) *Engine {
 	return &Engine{
-		BHLoader: loader,
-		AIClient: client,
+		BHLoader:    loader,
+		AIClient:    client,
+		MaxAttempts: DefaultMaxAttempts,
 	}
 }
 
+// printf writes a progress message
+func (e *Engine) printf(format string, args ...interface{}) {
+	w := e.Progress
+	if w == nil {
+		w = os.Stdout
+	}
+	fmt.Fprintf(w, format, args...)
+}
+
 // Resurrect takes a subset of nodes and asks the LLM to find attack paths
 func (e *Engine) Resurrect() ([]ZombiePath, error) {
 	return e.ResurrectWithSampleSize(20)
@@ -66,6 +147,69 @@ func (e *Engine) Resurrect() ([]ZombiePath, error) {
 
 // ResurrectWithSampleSize allows configurable sample size per entity type
 func (e *Engine) ResurrectWithSampleSize(maxEntitiesPerType int) ([]ZombiePath, error) {
+	return e.ResurrectContext(context.Background(), maxEntitiesPerType)
+}
+
+// ResurrectContext is ResurrectWithSampleSize bounded by ctx. When ctx is
+// cancelled mid-run, the findings gathered so far are returned together
+// with the context error so callers can still report them.
+func (e *Engine) ResurrectContext(ctx context.Context, maxEntitiesPerType int) ([]ZombiePath, error) {
+	e.Stats = RunStats{}
+	e.reserved = reservation{}
+	e.workers = nil
+	if e.Workers > 0 {
+		e.workers = make(chan struct{}, e.Workers)
+	}
+	e.focus = nil
+	if len(e.Focus.Targets) > 0 {
+		focus, err := e.focusSubgraph()
+		if err != nil {
+			return nil, err
+		}
+		e.focus = focus
+	}
+
+	// Summon the AI (structured output where the backend supports it),
+	// asking it to correct itself when the output fails to parse or
+	// validate, and moving down the failover chain when a backend fails.
+	// In ensemble mode every member answers and findings are merged.
+	summon := e.summonWithFailover
+	if len(e.Ensemble) > 0 {
+		summon = e.summonEnsemble
+	}
+	var paths []ZombiePath
+	var err error
+	if e.PerDomain {
+		paths, err = e.summonDomains(ctx, summon, maxEntitiesPerType)
+	} else {
+		paths, err = summon(ctx, maxEntitiesPerType)
+	}
+	if err != nil && len(paths) == 0 {
+		return nil, err
+	}
+
+	// Sort paths by risk level (Critical > High > Medium > Low), then
+	// by ensemble agreement (a no-op outside ensemble mode), then group
+	// them by domain (a no-op outside per-domain runs)
+	sortByRisk(paths)
+	sortByAgreement(paths)
+	e.sortByDomain(paths)
+
+	return paths, err
+}
+
+// sampledPrompt returns a function that builds the sampled user prompt on
+// first use, so runs that never analyse a sample (focus, triage, agent)
+// neither tokenize nor announce one
+func (e *Engine) sampledPrompt(maxEntitiesPerType int) func() (string, error) {
+	return sync.OnceValues(func() (string, error) {
+		return e.buildUserPrompt(maxEntitiesPerType)
+	})
+}
+
+// buildUserPrompt samples the BloodHound data (tokenized when the Privacy
+// Cloak is enabled) and embeds it in the analysis prompt
+func (e *Engine) buildUserPrompt(maxEntitiesPerType int) (string, error) {
 	// 1. Prepare Data Snippet with INTELLIGENT SAMPLING
 	// To avoid API 400 errors from payload size, we sample strategically:
 	// - Prioritize high-value targets (admincount=true, highvalue=true)
@@ -74,59 +218,62 @@ func (e *Engine) ResurrectWithSampleSize(maxEntitiesPerType int) ([]ZombiePath,
 	var dataBytes []byte
 	var err error
 
+	data := e.data()
+
 	// If Privacy Cloak is enabled, use sanitized tokenized data
 	if e.CloakEnabled && e.Tokenizer != nil {
 		// Create sanitized, tokenized data structure
-		sanitized := privacy.SanitizeBloodHoundData(&e.BHLoader.Data, e.Tokenizer, maxEntitiesPerType)
+		sanitized := privacy.SanitizeBloodHoundData(data, e.Tokenizer, maxEntitiesPerType)
 
 		dataBytes, err = json.MarshalIndent(sanitized, "", "  ")
 		if err != nil {
-			return nil, err
+			return "", err
 		}
 
-		fmt.Printf("\n[🔒] Privacy Cloak: %d entities tokenized (%d tokens generated)\n",
+		e.printf("\n[🔒] Privacy Cloak: %d entities tokenized (%d tokens generated)\n",
 			sanitized.Summary.TotalEntities, e.Tokenizer.GetMappingCount())
 	} else {
 		// Original behavior: send raw data
 		snippet := make(map[string]interface{})
 
 		// Sample users intelligently (prioritize high-value)
-		users := sampleNodes(e.BHLoader.Data.Users, maxEntitiesPerType)
+		users := sampleNodes(data.Users, maxEntitiesPerType)
 		snippet["users"] = users
 
 		// Sample groups intelligently
-		groups := sampleNodes(e.BHLoader.Data.Groups, maxEntitiesPerType)
+		// Memberships are left out; the control edges carry the signal
+		groups := withoutMembers(sampleNodes(data.Groups, maxEntitiesPerType))
 		snippet["groups"] = groups
 
 		// Sample computers intelligently
-		computers := sampleNodes(e.BHLoader.Data.Computers, maxEntitiesPerType)
+		computers := sampleNodes(data.Computers, maxEntitiesPerType)
 		snippet["computers"] = computers
 
 		// Include all GPOs (usually small number)
-		snippet["gpos"] = e.BHLoader.Data.GPOs
+		snippet["gpos"] = data.GPOs
 
 		// Include all OUs (usually small number)
-		snippet["ous"] = e.BHLoader.Data.OUs
+		snippet["ous"] = data.OUs
 
 		// Sample CertTemplates (respects --sample-size flag)
-		certTemplates := sampleNodes(e.BHLoader.Data.CertTemplates, maxEntitiesPerType)
+		certTemplates := sampleNodes(data.CertTemplates, maxEntitiesPerType)
 		snippet["certtemplates"] = certTemplates
 
 		// Sample EnterpriseCAs (respects --sample-size flag)
-		enterpriseCAs := sampleNodes(e.BHLoader.Data.EnterpriseCAs, maxEntitiesPerType)
+		enterpriseCAs := sampleNodes(data.EnterpriseCAs, maxEntitiesPerType)
 		snippet["enterprisecas"] = enterpriseCAs
 
-		fmt.Printf("\n[*] Analysis Scope (Sampled): %d Users, %d Groups, %d Computers, %d CertTemplates, %d EnterpriseCAs\n",
+		e.printf("\n[*] Analysis Scope (Sampled): %d Users, %d Groups, %d Computers, %d CertTemplates, %d EnterpriseCAs\n",
 			len(users), len(groups), len(computers), len(certTemplates), len(enterpriseCAs))
 
 		dataBytes, err = json.MarshalIndent(snippet, "", "  ")
 		if err != nil {
-			return nil, err
+			return "", err
 		}
 	}
 
 	// 2. Build User Prompt
-	userPrompt := fmt.Sprintf(`You are analyzing BloodHound data for an Active Directory environment.
+	return e.scopeNote() + fmt.Sprintf(`You are analyzing BloodHound data for an Active Directory environment.
 
 ENVIRONMENT SNAPSHOT:
 - %d Users
@@ -164,58 +311,64 @@ BLOODHOUND DATA (JSON)
 BEGIN RESURRECTION ANALYSIS
 ═══════════════════════════════════════════════════════════════════════════════
 
-Output your findings as a JSON array of ZombiePath objects, SORTED BY RISK (Critical/High first). Be thorough, technical, and creative.`,
-		len(e.BHLoader.Data.Users),
-		len(e.BHLoader.Data.Groups),
-		len(e.BHLoader.Data.Computers),
-		len(e.BHLoader.Data.Domains),
-		len(e.BHLoader.Data.GPOs),
-		len(e.BHLoader.Data.OUs),
-		string(dataBytes))
-
-	// 3. Summon the AI
-	response, err := e.AIClient.Summon(prompts.NecromancerSystemPrompt, userPrompt)
-	if err != nil {
-		return nil, err
-	}
+Output your findings as a JSON array of ZombiePath objects, SORTED BY RISK (Critical/High first). Be thorough, technical, and creative.
+If your output is constrained to a JSON object, wrap the array as {"findings": [ ... ]}.`,
+		len(data.Users),
+		len(data.Groups),
+		len(data.Computers),
+		len(data.Domains),
+		len(data.GPOs),
+		len(data.OUs),
+		string(dataBytes)), nil
+}
 
-	// 3.5. De-tokenize response if Privacy Cloak was enabled
-	if e.CloakEnabled && e.Tokenizer != nil {
-		response = e.Tokenizer.Detokenize(response)
+// tokenSalt returns the salt for a tokenizer the engine creates
+func (e *Engine) tokenSalt() string {
+	if e.TokenSalt == "" {
+		return privacy.NewSalt()
 	}
+	return e.TokenSalt
+}
 
-	// 4. Parse Response (handling potential markdown code blocks and formatting issues)
-	cleanJson := strings.TrimSpace(response)
-	cleanJson = strings.TrimPrefix(cleanJson, "```json")
-	cleanJson = strings.TrimPrefix(cleanJson, "```")
-	cleanJson = strings.TrimSuffix(cleanJson, "```")
-	cleanJson = strings.TrimSpace(cleanJson)
-
-	// Try to fix common JSON issues from LLMs
-	cleanJson = fixLLMJson(cleanJson)
-
-	var paths []ZombiePath
-	if err := json.Unmarshal([]byte(cleanJson), &paths); err != nil {
-		// If parsing fails, return an error instead of debug output
-		return nil, fmt.Errorf("failed to parse LLM response as JSON: %w", err)
+// data returns the objects the current analysis covers: the domain being
+// analysed in a per-domain run, otherwise everything loaded
+func (e *Engine) data() *bloodhound.BloodHoundData {
+	if e.scope != nil {
+		return &e.scope.Data
 	}
+	return &e.BHLoader.Data
+}
 
-	// Sort paths by risk level (Critical > High > Medium > Low)
-	sortByRisk(paths)
+// detokenizeFindings restores real names in every field of the findings
+func (e *Engine) detokenizeFindings(paths []ZombiePath) []ZombiePath {
+	out := make([]ZombiePath, 0, len(paths))
+	for _, p := range paths {
+		raw, err := json.Marshal(p)
+		if err != nil {
+			out = append(out, p)
+			continue
+		}
+		var restored ZombiePath
+		if err := json.Unmarshal([]byte(e.Tokenizer.Detokenize(string(raw))), &restored); err != nil {
+			out = append(out, p)
+			continue
+		}
+		out = append(out, restored)
+	}
+	return out
+}
 
-	return paths, nil
+// riskOrder ranks Probability values; unknown values rank lowest
+var riskOrder = map[string]int{
+	"Critical": 4,
+	"High":     3,
+	"Medium":   2,
+	"Low":      1,
+	"Unknown":  0,
 }
 
 // sortByRisk sorts zombie paths by risk level in descending order
 func sortByRisk(paths []ZombiePath) {
-	riskOrder := map[string]int{
-		"Critical": 4,
-		"High":     3,
-		"Medium":   2,
-		"Low":      1,
-		"Unknown":  0,
-	}
-
 	// Simple bubble sort (good enough for small arrays)
 	for i := 0; i < len(paths)-1; i++ {
 		for j := 0; j < len(paths)-i-1; j++ {
@@ -305,12 +458,14 @@ func sampleNodes(nodes []bloodhound.Node, maxCount int) []bloodhound.Node {
 	return result
 }
 
-// fixLLMJson attempts to fix common JSON formatting issues from LLMs
-func fixLLMJson(jsonStr string) string {
-	// This is a simple approach - just return as-is for now
-	// The prompt instructions should prevent most issues
-	// If needed, we can add more sophisticated cleaning later
-	return jsonStr
+// withoutMembers returns copies of groups with their member lists dropped
+func withoutMembers(groups []bloodhound.Node) []bloodhound.Node {
+	out := make([]bloodhound.Node, len(groups))
+	for i, g := range groups {
+		g.Members = nil
+		out[i] = g
+	}
+	return out
 }
 
 // truncate returns a truncated version of the string if it exceeds maxLen
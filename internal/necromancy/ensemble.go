@@ -0,0 +1,211 @@
+package necromancy
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"ad-necromancer/internal/privacy"
+)
+
+// controlEdgePattern finds BloodHound control edges and ADCS escalations
+// named in a finding, which identify the path independently of wording
+var controlEdgePattern = regexp.MustCompile(`(?i)\b(` + strings.Join([]string{
+	"GenericAll", "GenericWrite", "WriteDacl", "WriteOwner", "Owns",
+	"AddMember", "AddSelf", "ForceChangePassword", "AllExtendedRights",
+	"ReadLAPSPassword", "SyncLAPSPassword", "ReadGMSAPassword", "DumpSMSAPassword",
+	"AddKeyCredentialLink", "WriteSPN", "WriteAccountRestrictions",
+	"AllowedToDelegate", "AllowedToAct", "AddAllowedToAct", "HasSIDHistory",
+	"DCSync", "GetChangesAll", "GetChanges", "AdminTo", "CanRDP", "CanPSRemote",
+	"ExecuteDCOM", "SQLAdmin", "HasSession", "GPLink", "ManageCA",
+	"ManageCertificates", "Enroll", `ESC\d{1,2}`,
+}, "|") + `)\b`)
+
+// summonEnsemble sends the same prompt to every ensemble member
+// concurrently and merges their findings by consensus. Members that fail
+// are left out of the vote; the run fails only if all of them do.
+func (e *Engine) summonEnsemble(ctx context.Context, maxEntitiesPerType int) ([]ZombiePath, error) {
+	// One prepared dataset for all members, so it is tokenized as soon as
+	// any member is remote
+	if !e.CloakEnabled && !e.AllowUncloakedRemote {
+		for _, m := range e.Ensemble {
+			if !m.Local {
+				e.printf("[🔒] Privacy Cloak: ENABLED for remote ensemble member %s\n", m.Name)
+				e.CloakEnabled = true
+				if e.Tokenizer == nil {
+					e.Tokenizer = privacy.NewTokenizerWithSalt(e.tokenSalt())
+				}
+				break
+			}
+		}
+	}
+
+	userPrompt := e.sampledPrompt(maxEntitiesPerType)
+
+	// Every member starts at once; the engine's worker slots and rate
+	// limits bound the calls actually in flight
+	results, errs := runOrdered(ctx, len(e.Ensemble), 0, func(ctx context.Context, i int) ([]ZombiePath, error) {
+		m := e.Ensemble[i]
+		e.printf("[*] Ensemble: summoning %s...\n", m.Name)
+
+		paths, err := e.summonBackend(ctx, m, userPrompt, maxEntitiesPerType)
+		if e.CloakEnabled && e.Tokenizer != nil {
+			paths = e.detokenizeFindings(paths)
+		}
+		for j := range paths {
+			paths[j].Backend = m.Name
+		}
+		return paths, err
+	})
+
+	var answered []string
+	var votes [][]ZombiePath
+	var lastErr error
+	for i, m := range e.Ensemble {
+		if errs[i] != nil && len(results[i]) == 0 {
+			e.printf("[!] Ensemble member %s failed: %v\n", m.Name, errs[i])
+			lastErr = errs[i]
+			continue
+		}
+		answered = append(answered, m.Name)
+		votes = append(votes, results[i])
+	}
+
+	if len(answered) == 0 {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("all %d ensemble members failed; last error: %w", len(e.Ensemble), lastErr)
+	}
+
+	e.Stats.Backend = strings.Join(answered, ", ")
+	e.printf("[*] Ensemble: %d/%d model(s) answered\n", len(answered), len(e.Ensemble))
+
+	return mergeByConsensus(votes, len(answered)), ctx.Err()
+}
+
+// consensusGroup collects matching findings from different models
+type consensusGroup struct {
+	entity   string
+	edges    map[string]bool
+	title    string
+	findings []ZombiePath
+	backends map[string]bool
+}
+
+// mergeByConsensus groups findings that describe the same entity and
+// control edge across models. Each group becomes one finding, taken from
+// the model that rated it highest, annotated with how many models agreed
+// and the Probability each of them gave.
+func mergeByConsensus(votes [][]ZombiePath, size int) []ZombiePath {
+	var groups []*consensusGroup
+
+	for _, paths := range votes {
+		for _, p := range paths {
+			entity, edges := normalizeEntity(p.EntityName), findingEdges(p)
+			title := strings.ToLower(strings.TrimSpace(p.Title))
+
+			var match *consensusGroup
+			for _, g := range groups {
+				// One vote per model per group
+				if !g.backends[p.Backend] && g.matches(entity, edges, title) {
+					match = g
+					break
+				}
+			}
+			if match == nil {
+				match = &consensusGroup{entity: entity, edges: map[string]bool{}, title: title, backends: map[string]bool{}}
+				groups = append(groups, match)
+			}
+			match.findings = append(match.findings, p)
+			match.backends[p.Backend] = true
+			for edge := range edges {
+				match.edges[edge] = true
+			}
+		}
+	}
+
+	merged := make([]ZombiePath, 0, len(groups))
+	for _, g := range groups {
+		best := g.findings[0]
+		probabilities := make(map[string]string, len(g.findings))
+		backends := make([]string, 0, len(g.findings))
+		for _, f := range g.findings {
+			probabilities[f.Backend] = f.Probability
+			backends = append(backends, f.Backend)
+			if riskOrder[f.Probability] > riskOrder[best.Probability] {
+				best = f
+			}
+		}
+		sort.Strings(backends)
+
+		best.Backend = strings.Join(backends, ", ")
+		best.Agreement = len(g.findings)
+		best.EnsembleSize = size
+		best.ModelProbabilities = probabilities
+		merged = append(merged, best)
+	}
+	return merged
+}
+
+// matches reports whether a finding with the given key describes the same
+// path as the group: the same entity with overlapping control edges (or no
+// edges named on either side), or the same title when no entity is given
+func (g *consensusGroup) matches(entity string, edges map[string]bool, title string) bool {
+	if entity == "" || g.entity == "" {
+		return entity == g.entity && title != "" && title == g.title
+	}
+	if entity != g.entity {
+		return false
+	}
+	if len(edges) == 0 || len(g.edges) == 0 {
+		return true
+	}
+	for edge := range edges {
+		if g.edges[edge] {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeEntity reduces "CORP\svc_backup" and "SVC_BACKUP@CORP.LOCAL"
+// to "svc_backup"
+func normalizeEntity(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if i := strings.LastIndex(name, `\`); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.Index(name, "@"); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// findingEdges returns the control edges a finding names
+func findingEdges(p ZombiePath) map[string]bool {
+	text := strings.Join([]string{p.Title, p.Category, p.ResurrectedChain, p.ExploitChain, p.VisualPath}, "\n")
+	edges := make(map[string]bool)
+	for _, m := range controlEdgePattern.FindAllString(text, -1) {
+		edges[strings.ToLower(m)] = true
+	}
+	return edges
+}
+
+// AgreementLabel describes ensemble agreement, e.g. "3/4 models"
+func (p ZombiePath) AgreementLabel() string {
+	if p.EnsembleSize == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d models", p.Agreement, p.EnsembleSize)
+}
+
+// sortByAgreement ranks findings more models agreed on first, keeping the
+// existing (risk) order among equals
+func sortByAgreement(paths []ZombiePath) {
+	sort.SliceStable(paths, func(i, j int) bool {
+		return paths[i].Agreement > paths[j].Agreement
+	})
+}
@@ -0,0 +1,75 @@
+package necromancy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"ad-necromancer/internal/ai"
+	"ad-necromancer/internal/privacy"
+)
+
+// Backend is a named AI client taking part in a failover chain or ensemble
+type Backend struct {
+	Name   string
+	Client ai.AIClient
+	Local  bool   // Prompts stay on this machine/network
+	Model  string // Configured model id, for pricing; empty when unknown
+}
+
+// summonWithFailover asks each backend in the chain (AIClient first, then
+// Fallbacks) for findings until one succeeds. A backend fails when its
+// calls error out after the transport's retries (network errors, rate
+// limits, rejected payloads) or when its output still does not parse after
+// the self-correction attempts. Findings are detokenized and tagged with the
+// backend that produced them.
+func (e *Engine) summonWithFailover(ctx context.Context, maxEntitiesPerType int) ([]ZombiePath, error) {
+	chain := append([]Backend{{Name: e.BackendName, Client: e.AIClient, Local: e.Local, Model: e.Model}}, e.Fallbacks...)
+
+	var userPrompt func() (string, error)
+	promptCloaked := false
+	var lastErr error
+
+	for i, backend := range chain {
+		if i > 0 {
+			e.Stats.Failovers = i
+			e.printf("[~] %s failed (%v); failing over to %s\n", chain[i-1].Name, lastErr, backend.Name)
+		}
+
+		// Real data must not reach a remote backend just because the run
+		// started on a local one
+		if !e.CloakEnabled && !backend.Local && !e.AllowUncloakedRemote {
+			e.printf("[🔒] Privacy Cloak: ENABLED for remote backend %s\n", backend.Name)
+			e.CloakEnabled = true
+			if e.Tokenizer == nil {
+				e.Tokenizer = privacy.NewTokenizerWithSalt(e.tokenSalt())
+			}
+		}
+
+		if userPrompt == nil || promptCloaked != e.CloakEnabled {
+			userPrompt, promptCloaked = e.sampledPrompt(maxEntitiesPerType), e.CloakEnabled
+		}
+
+		paths, err := e.summonBackend(ctx, backend, userPrompt, maxEntitiesPerType)
+
+		// De-tokenize findings if Privacy Cloak was enabled for this backend
+		if promptCloaked && e.Tokenizer != nil {
+			paths = e.detokenizeFindings(paths)
+		}
+		for j := range paths {
+			paths[j].Backend = backend.Name
+		}
+
+		// Another backend would run into the same budget
+		if err == nil || len(paths) > 0 || ctx.Err() != nil || errors.Is(err, ErrBudgetExceeded) {
+			e.Stats.Backend = backend.Name
+			return paths, err
+		}
+		lastErr = err
+	}
+
+	if len(chain) > 1 {
+		return nil, fmt.Errorf("all %d backends failed; last error: %w", len(chain), lastErr)
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,102 @@
+package necromancy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"ad-necromancer/internal/bloodhound"
+	"ad-necromancer/internal/prompts"
+)
+
+// DefaultFocusHops is the radius of a focused run's neighbourhood
+const DefaultFocusHops = 2
+
+// Focus limits
+const (
+	focusMaxNodes = 300 // Objects sent, nearest to the targets first
+	focusTargets  = 20  // Targets listed by name in the prompt
+)
+
+// FocusOptions narrows a run to the neighbourhood of chosen objects
+type FocusOptions struct {
+	Targets []string // SIDs, names, distinguished names or domains
+	Hops    int      // Edges followed away from the targets
+}
+
+// focusView is the subgraph of a focused run as the model sees it
+type focusView struct {
+	Objects   []focusNode `json:"neighbourhood"` // Targets first, at 0 hops
+	Edges     []edgeView  `json:"edges"`
+	Truncated bool        `json:"truncated,omitempty"` // The farthest objects were left out
+}
+
+type focusNode struct {
+	nodeView
+	Hops int `json:"hops"`
+}
+
+// focusHops returns the radius of the focus neighbourhood
+func (e *Engine) focusHops() int {
+	if e.Focus.Hops < 1 {
+		return DefaultFocusHops
+	}
+	return e.Focus.Hops
+}
+
+// focusSubgraph resolves the focus targets against the loaded data and
+// extracts their neighbourhood
+func (e *Engine) focusSubgraph() (*bloodhound.Subgraph, error) {
+	hops := e.focusHops()
+	g := e.forest()
+	var seeds []string
+	for _, target := range e.Focus.Targets {
+		ids, err := g.Select(target)
+		if err != nil {
+			return nil, fmt.Errorf("focus: %w", err)
+		}
+		seeds = append(seeds, ids...)
+	}
+
+	s := g.Neighbourhood(seeds, hops, focusMaxNodes)
+	e.printf("[*] Focus: %d target object(s), %d object(s) within %d hop(s), %d edge(s)\n",
+		len(s.Seeds), len(s.IDs)-len(s.Seeds), hops, len(s.Edges))
+	if s.Truncated {
+		e.printf("[~] Focus: capped at %d objects, nearest first; narrow the focus or lower --focus-hops\n", focusMaxNodes)
+	}
+	return s, nil
+}
+
+// summonFocus asks b for the findings around the focus targets, from
+// their neighbourhood only. Returned findings are still in tokenized form.
+func (e *Engine) summonFocus(ctx context.Context, b Backend) ([]ZombiePath, error) {
+	tb := e.toolbox()
+	raw, err := json.MarshalIndent(tb.focusView(e.focus), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []string
+	for i, id := range e.focus.Seeds {
+		if i == focusTargets {
+			targets = append(targets, fmt.Sprintf("- ... and %d more, at 0 hops in the neighbourhood", len(e.focus.Seeds)-i))
+			break
+		}
+		targets = append(targets, "- "+describeNode(tb.view(id, "")))
+	}
+	prompt := fmt.Sprintf(prompts.FocusPrompt, strings.Join(targets, "\n"), e.focusHops(), string(raw))
+	return e.summonFindings(ctx, b, prompts.NecromancerSystemPrompt, prompt)
+}
+
+// focusView renders s with names tokenized as the cloak requires
+func (tb *toolbox) focusView(s *bloodhound.Subgraph) focusView {
+	v := focusView{Objects: []focusNode{}, Edges: []edgeView{}, Truncated: s.Truncated}
+	for _, id := range s.IDs {
+		v.Objects = append(v.Objects, focusNode{nodeView: tb.view(id, ""), Hops: s.Hops[id]})
+	}
+	for _, e := range s.Edges {
+		v.Edges = append(v.Edges, tb.edgeView(e))
+	}
+	return v
+}
@@ -0,0 +1,358 @@
+package necromancy
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// RepairReport describes how a malformed model response was recovered
+type RepairReport struct {
+	Repairs   []string // Fixes applied to the raw text, in the order first seen
+	Salvaged  int      // Complete findings recovered
+	Discarded int      // Complete objects that still failed to decode
+	Truncated bool     // Output ended in the middle of a finding (e.g. max_tokens)
+}
+
+func (r RepairReport) String() string {
+	msg := fmt.Sprintf("salvaged %d finding(s)", r.Salvaged)
+	if r.Discarded > 0 {
+		msg += fmt.Sprintf(", discarded %d malformed", r.Discarded)
+	}
+	if r.Truncated {
+		msg += ", dropped a truncated trailing finding"
+	}
+	if len(r.Repairs) > 0 {
+		msg += " (" + strings.Join(r.Repairs, "; ") + ")"
+	}
+	return msg
+}
+
+// repairFindings recovers every complete ZombiePath from output that
+// json.Unmarshal rejected
+func repairFindings(raw string) ([]ZombiePath, RepairReport) {
+	fixed, repairs := fixLLMJson(raw)
+	report := RepairReport{Repairs: repairs}
+
+	// The text fixes alone may have been enough
+	if paths, err := decodeFindings(fixed); err == nil {
+		report.Salvaged = len(paths)
+		return paths, report
+	}
+
+	objects, truncated := splitFindingObjects(fixed)
+	report.Truncated = truncated
+
+	var paths []ZombiePath
+	for _, obj := range objects {
+		p, err := decodeFindingLenient(obj)
+		if err != nil {
+			report.Discarded++
+			continue
+		}
+		paths = append(paths, p)
+	}
+	report.Salvaged = len(paths)
+
+	return paths, report
+}
+
+// decodeFindingLenient decodes one finding, accepting a plain string where
+// ZombiePath expects a list (e.g. "Impact": "Full domain compromise")
+func decodeFindingLenient(obj string) (ZombiePath, error) {
+	var p ZombiePath
+	err := json.Unmarshal([]byte(obj), &p)
+	if err == nil {
+		return p, nil
+	}
+
+	var fields map[string]interface{}
+	if json.Unmarshal([]byte(obj), &fields) != nil {
+		return p, err
+	}
+	for key, value := range fields {
+		if str, ok := value.(string); ok && listFields[key] {
+			fields[key] = []string{str}
+		}
+	}
+	coerced, mErr := json.Marshal(fields)
+	if mErr != nil {
+		return p, err
+	}
+	p = ZombiePath{}
+	if json.Unmarshal(coerced, &p) != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// listFields are the ZombiePath JSON keys holding []string
+var listFields = map[string]bool{
+	"ExecutionVectors": true,
+	"HumanBlindSpot":   true,
+	"Impact":           true,
+	"DetectionRules":   true,
+	"MitreAttack":      true,
+	"Commands":         true,
+}
+
+// fixLLMJson rewrites the common ways LLMs break JSON into valid syntax:
+// prose around the document, literal control characters inside strings,
+// single-quoted strings, unescaped inner quotes, invalid escapes, trailing
+// commas and unterminated strings. It returns the fixed text and a list of
+// the repairs that were needed.
+func fixLLMJson(jsonStr string) (string, []string) {
+	var repairs []string
+	seen := make(map[string]bool)
+	note := func(repair string) {
+		if !seen[repair] {
+			seen[repair] = true
+			repairs = append(repairs, repair)
+		}
+	}
+
+	body := jsonStr
+	start := strings.IndexAny(body, "[{")
+	if start < 0 {
+		return jsonStr, nil
+	}
+	// Only cut trailing text that cannot be part of the document; if the
+	// output was truncated mid-object we keep the tail so it is reported
+	end := strings.LastIndexAny(body, "]}")
+	if end < start || strings.ContainsAny(body[end+1:], "{\"") {
+		end = len(body) - 1
+	}
+	if strings.TrimSpace(body[:start]) != "" || strings.TrimSpace(body[end+1:]) != "" {
+		note("discarded text around the JSON")
+	}
+	body = body[start : end+1]
+
+	var out strings.Builder
+	out.Grow(len(body) + 64)
+
+	inString := false
+	var quote byte
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+
+		if !inString {
+			switch c {
+			case '"':
+				inString, quote = true, '"'
+				out.WriteByte('"')
+			case '\'':
+				note("converted single-quoted strings")
+				inString, quote = true, '\''
+				out.WriteByte('"')
+			case ',':
+				if next := nextSignificant(body, i+1); next == '}' || next == ']' {
+					note("removed trailing commas")
+					continue
+				}
+				out.WriteByte(c)
+			default:
+				out.WriteByte(c)
+			}
+			continue
+		}
+
+		switch {
+		case c == '\\':
+			if i+1 >= len(body) {
+				continue
+			}
+			next := body[i+1]
+			switch next {
+			case '"', '\\', '/', 'b', 'f', 'n', 'r', 't':
+				out.WriteByte(c)
+				out.WriteByte(next)
+				i++
+			case 'u':
+				if i+5 < len(body) && isHex(body[i+2:i+6]) {
+					out.WriteString(body[i : i+6])
+					i += 5
+				} else {
+					note("escaped invalid backslashes")
+					out.WriteString(`\\`)
+				}
+			case '\'':
+				out.WriteByte('\'')
+				i++
+			default:
+				note("escaped invalid backslashes")
+				out.WriteString(`\\`)
+			}
+		case c == quote:
+			if closesString(body, i+1) {
+				inString = false
+				out.WriteByte('"')
+			} else if quote == '"' {
+				note("escaped unbalanced inner quotes")
+				out.WriteString(`\"`)
+			} else {
+				out.WriteByte('\'') // apostrophe inside a single-quoted string
+			}
+		case c == '"':
+			out.WriteString(`\"`) // double quote inside a single-quoted string
+		case c == '\n':
+			note("escaped literal control characters in strings")
+			out.WriteString(`\n`)
+		case c == '\r':
+			note("escaped literal control characters in strings")
+			out.WriteString(`\r`)
+		case c == '\t':
+			note("escaped literal control characters in strings")
+			out.WriteString(`\t`)
+		case c < 0x20:
+			note("escaped literal control characters in strings")
+			fmt.Fprintf(&out, `\u%04x`, c)
+		default:
+			out.WriteByte(c)
+		}
+	}
+
+	if inString {
+		note("closed an unterminated string")
+		out.WriteByte('"')
+	}
+
+	return out.String(), repairs
+}
+
+// splitFindingObjects returns every balanced finding object in a (possibly
+// truncated) array or {"findings": [...]} envelope, and whether the text
+// ended inside an unfinished object. The input must already have valid
+// string quoting (see fixLLMJson).
+func splitFindingObjects(text string) ([]string, bool) {
+	var objects []string
+	var stack []byte
+	objStart := -1
+	inString := false
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if inString {
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			inString = true
+		case '[':
+			stack = append(stack, c)
+		case '{':
+			if len(stack) == 0 && isEnvelope(text[i+1:]) {
+				stack = append(stack, c)
+				continue
+			}
+			if objStart < 0 && isFindingLevel(stack) {
+				objStart = i
+			}
+			stack = append(stack, c)
+		case '}', ']':
+			if len(stack) == 0 {
+				continue
+			}
+			stack = stack[:len(stack)-1]
+			if c == '}' && objStart >= 0 && isFindingLevel(stack) {
+				objects = append(objects, text[objStart:i+1])
+				objStart = -1
+			}
+		}
+	}
+
+	return objects, objStart >= 0
+}
+
+// isFindingLevel reports whether an object opened at this nesting is a
+// finding: a bare top-level object, an element of the top-level array, or an
+// element of the envelope's findings array
+func isFindingLevel(stack []byte) bool {
+	switch len(stack) {
+	case 0:
+		return true
+	case 1:
+		return stack[0] == '['
+	case 2:
+		return stack[0] == '{' && stack[1] == '['
+	}
+	return false
+}
+
+// isEnvelope reports whether an object body starts with the findings key
+func isEnvelope(body string) bool {
+	return strings.HasPrefix(body[skipSpace(body, 0):], `"findings"`)
+}
+
+// closesString decides whether a quote at body[pos-1] ends the string, by
+// checking that what follows is valid JSON structure rather than more prose
+func closesString(body string, pos int) bool {
+	i := skipSpace(body, pos)
+	if i >= len(body) {
+		return true
+	}
+	switch body[i] {
+	case ':', '}', ']':
+		return true
+	case ',':
+		j := skipSpace(body, i+1)
+		if j >= len(body) {
+			return true
+		}
+		switch body[j] {
+		case '"', '\'', '{', '[', '}', ']', '-':
+			return true
+		}
+		if body[j] >= '0' && body[j] <= '9' {
+			return true
+		}
+		for _, keyword := range []string{"true", "false", "null"} {
+			if strings.HasPrefix(body[j:], keyword) {
+				k := j + len(keyword)
+				if k >= len(body) || !isLetter(body[k]) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func nextSignificant(body string, pos int) byte {
+	i := skipSpace(body, pos)
+	if i >= len(body) {
+		return 0
+	}
+	return body[i]
+}
+
+func skipSpace(body string, pos int) int {
+	for pos < len(body) {
+		switch body[pos] {
+		case ' ', '\t', '\n', '\r':
+			pos++
+		default:
+			return pos
+		}
+	}
+	return pos
+}
+
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
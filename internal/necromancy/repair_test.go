@@ -0,0 +1,186 @@
+package necromancy
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Malformed findings as models have returned them
+func TestRepairFindings(t *testing.T) {
+	tests := []struct {
+		name      string
+		raw       string
+		fixed     string   // fixLLMJson output
+		repairs   []string // in the order first seen
+		titles    []string // salvaged findings
+		discarded int
+		truncated bool
+	}{
+		{
+			name:    "unescaped inner quotes",
+			raw:     `[{"Title": "Abuse of the "legacy" backup SPN", "Artifact": "svc_backup"}]`,
+			fixed:   `[{"Title": "Abuse of the \"legacy\" backup SPN", "Artifact": "svc_backup"}]`,
+			repairs: []string{"escaped unbalanced inner quotes"},
+			titles:  []string{`Abuse of the "legacy" backup SPN`},
+		},
+		{
+			name:    "trailing commas",
+			raw:     "[\n  {\"Title\": \"A\", \"Impact\": [\"DCSync\", \"Golden ticket\",],},\n]",
+			fixed:   "[\n  {\"Title\": \"A\", \"Impact\": [\"DCSync\", \"Golden ticket\"]}\n]",
+			repairs: []string{"removed trailing commas"},
+			titles:  []string{"A"},
+		},
+		{
+			name:    "single quotes",
+			raw:     `[{'Title': 'Owner's forgotten GPO', 'Reasoning': 'linked as "Default"'}]`,
+			fixed:   `[{"Title": "Owner's forgotten GPO", "Reasoning": "linked as \"Default\""}]`,
+			repairs: []string{"converted single-quoted strings"},
+			titles:  []string{"Owner's forgotten GPO"},
+		},
+		{
+			name:      "truncated last object",
+			raw:       `[{"Title": "A", "Impact": ["x"]}, {"Title": "B", "Reasoning": "The account was cre`,
+			fixed:     `[{"Title": "A", "Impact": ["x"]}, {"Title": "B", "Reasoning": "The account was cre"`,
+			repairs:   []string{"closed an unterminated string"},
+			titles:    []string{"A"},
+			truncated: true,
+		},
+		{
+			name:      "truncated envelope",
+			raw:       `{"findings": [{"Title": "A"}, {"Title": "B"}, {"Title": "C", "Impact": ["x"`,
+			fixed:     `{"findings": [{"Title": "A"}, {"Title": "B"}, {"Title": "C", "Impact": ["x"`,
+			titles:    []string{"A", "B"},
+			truncated: true,
+		},
+		{
+			name:    "prose around the array",
+			raw:     "Here are the findings:\n```json\n[{\"Title\": \"A\"}]\n```\nLet me know if you need more detail.",
+			fixed:   `[{"Title": "A"}]`,
+			repairs: []string{"discarded text around the JSON"},
+			titles:  []string{"A"},
+		},
+		{
+			name:    "literal newlines and bad escapes",
+			raw:     "[{\"Title\": \"A\", \"Artifact\": \"CORP\\svc_sql\", \"VisualPath\": \"svc_sql\n  -> DA\"}]",
+			fixed:   `[{"Title": "A", "Artifact": "CORP\\svc_sql", "VisualPath": "svc_sql\n  -> DA"}]`,
+			repairs: []string{"escaped invalid backslashes", "escaped literal control characters in strings"},
+			titles:  []string{"A"},
+		},
+		{
+			name:      "one malformed object among good ones",
+			raw:       `[{"Title": "A"}, {"Title": 42}, {"Title": "C", "Impact": "Full domain compromise"}]`,
+			fixed:     `[{"Title": "A"}, {"Title": 42}, {"Title": "C", "Impact": "Full domain compromise"}]`,
+			titles:    []string{"A", "C"},
+			discarded: 1,
+		},
+		{
+			name:    "several faults at once",
+			raw:     "Sure! [{'Title': 'Kerberoastable \"svc_old\"', \"Impact\": [\"DA\",],}] Hope this helps.",
+			fixed:   `[{"Title": "Kerberoastable \"svc_old\"", "Impact": ["DA"]}]`,
+			repairs: []string{"discarded text around the JSON", "converted single-quoted strings", "removed trailing commas"},
+			titles:  []string{`Kerberoastable "svc_old"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fixed, repairs := fixLLMJson(tt.raw)
+			if fixed != tt.fixed {
+				t.Errorf("fixed:\n got %s\nwant %s", fixed, tt.fixed)
+			}
+			if !reflect.DeepEqual(repairs, tt.repairs) {
+				t.Errorf("repairs = %q, want %q", repairs, tt.repairs)
+			}
+
+			paths, report := repairFindings(tt.raw)
+			var titles []string
+			for _, p := range paths {
+				titles = append(titles, p.Title)
+			}
+			if !reflect.DeepEqual(titles, tt.titles) {
+				t.Errorf("salvaged %q, want %q", titles, tt.titles)
+			}
+			if report.Salvaged != len(tt.titles) || report.Discarded != tt.discarded || report.Truncated != tt.truncated {
+				t.Errorf("report = %+v, want %d salvaged, %d discarded, truncated %v",
+					report, len(tt.titles), tt.discarded, tt.truncated)
+			}
+		})
+	}
+}
+
+func TestRepairFindingsCoercesListFields(t *testing.T) {
+	paths, report := repairFindings(`[{"Title": "A", "Impact": "Full domain compromise", "MitreAttack": "T1558.003"},]`)
+	if len(paths) != 1 || report.Discarded != 0 {
+		t.Fatalf("salvaged %d, discarded %d; want 1 and 0", len(paths), report.Discarded)
+	}
+	if !reflect.DeepEqual(paths[0].Impact, []string{"Full domain compromise"}) ||
+		!reflect.DeepEqual(paths[0].MitreAttack, []string{"T1558.003"}) {
+		t.Errorf("Impact = %q, MitreAttack = %q", paths[0].Impact, paths[0].MitreAttack)
+	}
+}
+
+func TestRepairFindingsWithoutJSON(t *testing.T) {
+	fixed, repairs := fixLLMJson("I could not find any zombie paths.")
+	if fixed != "I could not find any zombie paths." || repairs != nil {
+		t.Errorf("fixLLMJson changed prose: %q, %q", fixed, repairs)
+	}
+	paths, report := repairFindings("I could not find any zombie paths.")
+	if len(paths) != 0 || report.Salvaged != 0 || report.Discarded != 0 || report.Truncated {
+		t.Errorf("salvaged %v, report %+v", paths, report)
+	}
+}
+
+func TestClosesString(t *testing.T) {
+	tests := []struct {
+		after string // text following the quote
+		want  bool
+	}{
+		{`, "Artifact": "x"}`, true},
+		{`}]`, true},
+		{`: "value"`, true},
+		{`, 42]`, true},
+		{`, -1]`, true},
+		{`, true}`, true},
+		{`, null]`, true},
+		{``, true},
+		{` backup SPN", "Artifact": "x"`, false},
+		{`, which is bad", "x": 1`, false},
+		{`, trueish", "x": 1`, false},
+		{`s backup'`, false},
+	}
+	for _, tt := range tests {
+		if got := closesString(`"`+tt.after, 1); got != tt.want {
+			t.Errorf("closesString(%q) = %v, want %v", tt.after, got, tt.want)
+		}
+	}
+}
+
+func TestSplitFindingObjects(t *testing.T) {
+	tests := []struct {
+		text      string
+		objects   []string
+		truncated bool
+	}{
+		{`[{"a": {"b": 1}}, {"c": "}"}]`, []string{`{"a": {"b": 1}}`, `{"c": "}"}`}, false},
+		{`{"findings": [{"a": 1}, {"b": [`, []string{`{"a": 1}`}, true},
+		{`{"Title": "only one"}`, []string{`{"Title": "only one"}`}, false},
+		{`[{"a": "x\"}"}, {"b`, []string{`{"a": "x\"}"}`}, true},
+	}
+	for _, tt := range tests {
+		objects, truncated := splitFindingObjects(tt.text)
+		if !reflect.DeepEqual(objects, tt.objects) || truncated != tt.truncated {
+			t.Errorf("splitFindingObjects(%s) = %q, %v; want %q, %v", tt.text, objects, truncated, tt.objects, tt.truncated)
+		}
+	}
+}
+
+func TestRepairReportString(t *testing.T) {
+	report := RepairReport{Repairs: []string{"removed trailing commas"}, Salvaged: 2, Discarded: 1, Truncated: true}
+	want := "salvaged 2 finding(s), discarded 1 malformed, dropped a truncated trailing finding (removed trailing commas)"
+	if got := report.String(); got != want {
+		t.Errorf("String() = %q", got)
+	}
+	if got := (RepairReport{Salvaged: 3}).String(); got != "salvaged 3 finding(s)" {
+		t.Errorf("String() = %q", got)
+	}
+}
@@ -0,0 +1,101 @@
+package necromancy
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"ad-necromancer/internal/ratelimit"
+)
+
+// DefaultWorkers caps the model calls in flight at once across a run
+const DefaultWorkers = 8
+
+// runOrdered runs task for every index in [0, n) on at most workers
+// goroutines at once (all at once when workers < 1) and returns results and
+// errors by index, whatever order the tasks finish in. Tasks not started
+// when ctx ends get ctx's error.
+func runOrdered[T any](ctx context.Context, n, workers int, task func(ctx context.Context, i int) (T, error)) ([]T, []error) {
+	results := make([]T, n)
+	errs := make([]error, n)
+	if workers < 1 || workers > n {
+		workers = n
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if err := ctx.Err(); err != nil {
+					errs[i] = err
+					continue
+				}
+				results[i], errs[i] = task(ctx, i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	return results, errs
+}
+
+// acquireWorker waits for one of the run's worker slots and returns the
+// function that frees it
+func (e *Engine) acquireWorker(ctx context.Context) (func(), error) {
+	if e.workers == nil {
+		return func() {}, nil
+	}
+	select {
+	case e.workers <- struct{}{}:
+		return func() { <-e.workers }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// limiter returns the rate limiter shared by every backend of b's provider,
+// or nil when the provider has no limits
+func (e *Engine) limiter(b Backend) *ratelimit.Limiter {
+	provider := b.provider()
+	limits, ok := e.RateLimits[provider]
+	if !ok || limits.IsZero() {
+		return nil
+	}
+
+	e.statsMu.Lock()
+	defer e.statsMu.Unlock()
+	if e.limiters == nil {
+		e.limiters = make(map[string]*ratelimit.Limiter)
+	}
+	l, ok := e.limiters[provider]
+	if !ok {
+		l = ratelimit.New(limits)
+		e.limiters[provider] = l
+	}
+	return l
+}
+
+// recordRateWait adds time a call spent waiting for its rate limits
+func (e *Engine) recordRateWait(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	e.statsMu.Lock()
+	defer e.statsMu.Unlock()
+	e.Stats.RateWait += d
+}
+
+// provider returns the registered backend b's client comes from; names are
+// the backend, then "/model" when a model was given
+func (b Backend) provider() string {
+	provider, _, _ := strings.Cut(b.Name, "/")
+	return provider
+}
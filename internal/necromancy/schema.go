@@ -0,0 +1,83 @@
+package necromancy
+
+import (
+	"encoding/json"
+
+	"ad-necromancer/internal/ai"
+	"ad-necromancer/internal/prompts"
+)
+
+// findingsEnvelope is the structured-output document. Most providers require
+// the schema root to be an object, so the ZombiePath array is wrapped.
+type findingsEnvelope struct {
+	Findings []ZombiePath `json:"findings"`
+}
+
+// FindingsSchema returns the structured-output schema for a resurrection run,
+// generated from ZombiePath so the two can never drift apart
+func FindingsSchema() *ai.Schema {
+	return &ai.Schema{
+		Name:        "zombie_paths",
+		Description: "Forgotten Active Directory control paths, sorted by risk",
+		Definition:  ai.SchemaFor(findingsEnvelope{}),
+
+		Instructions: prompts.FindingsFormatPrompt,
+	}
+}
+
+// decodeFindings accepts either a bare ZombiePath array (prompt-only mode)
+// or the {"findings": [...]} envelope (structured-output mode)
+func decodeFindings(data string) ([]ZombiePath, error) {
+	var paths []ZombiePath
+	arrayErr := json.Unmarshal([]byte(data), &paths)
+	if arrayErr == nil {
+		return paths, nil
+	}
+
+	var envelope findingsEnvelope
+	if err := json.Unmarshal([]byte(data), &envelope); err == nil && envelope.Findings != nil {
+		return envelope.Findings, nil
+	}
+
+	return nil, arrayErr
+}
+
+// TriageCandidate is an entity the triage phase ranked as worth a deep dive
+type TriageCandidate struct {
+	ID        string `json:"Id"`        // Entity id exactly as the overview gives it
+	Suspicion string `json:"Suspicion"` // Critical, High, Medium or Low
+	Reason    string `json:"Reason"`    // Why the entity looks dangerous
+}
+
+// triageEnvelope is the structured-output document of the triage phase
+type triageEnvelope struct {
+	Candidates []TriageCandidate `json:"candidates"`
+}
+
+// TriageSchema returns the structured-output schema of the triage phase
+func TriageSchema() *ai.Schema {
+	return &ai.Schema{
+		Name:        "triage_candidates",
+		Description: "Active Directory entities most likely to hold forgotten control paths, most suspicious first",
+		Definition:  ai.SchemaFor(triageEnvelope{}),
+
+		Instructions: prompts.TriageFormatPrompt,
+	}
+}
+
+// decodeCandidates accepts either a bare candidate array or the
+// {"candidates": [...]} envelope
+func decodeCandidates(data string) ([]TriageCandidate, error) {
+	var candidates []TriageCandidate
+	arrayErr := json.Unmarshal([]byte(data), &candidates)
+	if arrayErr == nil {
+		return candidates, nil
+	}
+
+	var envelope triageEnvelope
+	if err := json.Unmarshal([]byte(data), &envelope); err == nil && envelope.Candidates != nil {
+		return envelope.Candidates, nil
+	}
+
+	return nil, arrayErr
+}
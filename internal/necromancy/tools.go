@@ -0,0 +1,572 @@
+package necromancy
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"ad-necromancer/internal/ai"
+	"ad-necromancer/internal/bloodhound"
+	"ad-necromancer/internal/privacy"
+)
+
+// Tool result limits; larger results report how many were left out
+const (
+	defaultToolLimit = 25
+	maxToolLimit     = 100
+	defaultMaxHops   = 6
+	maxMaxHops       = 10
+)
+
+// agentTools are the graph queries offered to the model in agentic mode
+var agentTools = []ai.Tool{
+	{
+		Name:        "get_node",
+		Description: "Properties of one node and how many edges lead into and out of it.",
+		Parameters:  toolParams([]string{"id"}, map[string]interface{}{"id": idParam}),
+	},
+	{
+		Name:        "inbound_edges",
+		Description: "Edges into a node: the principals that control it (ACE rights such as GenericAll or WriteDacl) and, for a group, its direct members (MemberOf).",
+		Parameters: toolParams([]string{"id"}, map[string]interface{}{
+			"id":           idParam,
+			"relationship": relationshipParam,
+			"limit":        limitParam,
+		}),
+	},
+	{
+		Name:        "outbound_edges",
+		Description: "Edges out of a node: the objects it controls through ACE rights and the groups it is a direct member of (MemberOf).",
+		Parameters: toolParams([]string{"id"}, map[string]interface{}{
+			"id":           idParam,
+			"relationship": relationshipParam,
+			"limit":        limitParam,
+		}),
+	},
+	{
+		Name:        "group_members",
+		Description: "Members of a group. With recursive, members of nested groups are included and each member names the group it belongs to.",
+		Parameters: toolParams([]string{"id"}, map[string]interface{}{
+			"id":        idParam,
+			"recursive": map[string]interface{}{"type": "boolean", "description": "Include members of nested groups"},
+			"limit":     limitParam,
+		}),
+	},
+	{
+		Name:        "shortest_path_to_tier0",
+		Description: "The fewest edges (ACE rights and memberships) leading from a node to a Tier-0 object: a domain, a high-value node or a privileged builtin group.",
+		Parameters: toolParams([]string{"id"}, map[string]interface{}{
+			"id":       idParam,
+			"max_hops": map[string]interface{}{"type": "integer", "description": fmt.Sprintf("Longest path to consider (default %d, at most %d)", defaultMaxHops, maxMaxHops)},
+		}),
+	},
+	{
+		Name:        "search_nodes",
+		Description: "Find nodes by kind and flags, optionally matching a name. All filters are optional and combined.",
+		Parameters: toolParams(nil, map[string]interface{}{
+			"query":      map[string]interface{}{"type": "string", "description": "Part of the node name, or an id exactly as tools return it"},
+			"kind":       map[string]interface{}{"type": "string", "enum": []string{bloodhound.KindUser, bloodhound.KindGroup, bloodhound.KindComputer, bloodhound.KindDomain, bloodhound.KindGPO, bloodhound.KindOU, bloodhound.KindContainer, bloodhound.KindCertTemplate, bloodhound.KindEnterpriseCA}},
+			"highvalue":  map[string]interface{}{"type": "boolean"},
+			"admincount": map[string]interface{}{"type": "boolean"},
+			"enabled":    map[string]interface{}{"type": "boolean"},
+			"limit":      limitParam,
+		}),
+	},
+}
+
+var (
+	idParam           = map[string]interface{}{"type": "string", "description": "Node id exactly as tools or the prompt give it"}
+	relationshipParam = map[string]interface{}{"type": "string", "description": "Only edges of this kind, e.g. GenericAll or MemberOf"}
+	limitParam        = map[string]interface{}{"type": "integer", "description": fmt.Sprintf("Most results to return (default %d, at most %d)", defaultToolLimit, maxToolLimit)}
+)
+
+// toolParams builds the JSON Schema of a tool's arguments object
+func toolParams(required []string, properties map[string]interface{}) map[string]interface{} {
+	params := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		params["required"] = required
+	}
+	return params
+}
+
+// toolbox answers tool calls from the loaded graph. With the Privacy Cloak
+// on, every name in a result is tokenized, descriptions and DNs are left
+// out, and ids passed in must be tokens, so the model cannot probe for real
+// names.
+type toolbox struct {
+	graph     *bloodhound.Graph
+	tokenizer *privacy.Tokenizer // nil when the cloak is off
+}
+
+// toolbox returns the tools over the data of the current analysis (see
+// data) as currently cloaked
+func (e *Engine) toolbox() *toolbox {
+	tb := &toolbox{graph: e.forest()}
+	if e.scope != nil {
+		tb.graph = e.scope.graph
+	}
+	if e.CloakEnabled && e.Tokenizer != nil {
+		tb.tokenizer = e.Tokenizer
+	}
+	return tb
+}
+
+// forest returns the graph of everything loaded
+func (e *Engine) forest() *bloodhound.Graph {
+	e.graphOnce.Do(func() {
+		e.graph = bloodhound.NewGraph(&e.BHLoader.Data)
+	})
+	return e.graph
+}
+
+// toolError is returned to the model in place of a result
+type toolError struct {
+	Error string `json:"error"`
+}
+
+// call runs one tool call and returns the result for the model, the number
+// of items it holds and the error, if any, that the result reports
+func (tb *toolbox) call(call ai.ToolCall) (string, int, error) {
+	result, n, err := tb.run(call)
+	if err != nil {
+		raw, _ := json.Marshal(toolError{Error: err.Error()})
+		return string(raw), 0, err
+	}
+	raw, err := json.Marshal(result)
+	if err != nil {
+		return `{"error":"failed to encode result"}`, 0, err
+	}
+	return string(raw), n, nil
+}
+
+// toolArgs are the arguments of every tool; each uses a subset
+type toolArgs struct {
+	ID           string `json:"id"`
+	Relationship string `json:"relationship"`
+	Limit        int    `json:"limit"`
+	Recursive    bool   `json:"recursive"`
+	MaxHops      int    `json:"max_hops"`
+	Query        string `json:"query"`
+	Kind         string `json:"kind"`
+	HighValue    *bool  `json:"highvalue"`
+	AdminCount   *bool  `json:"admincount"`
+	Enabled      *bool  `json:"enabled"`
+}
+
+func (tb *toolbox) run(call ai.ToolCall) (interface{}, int, error) {
+	var args toolArgs
+	if raw := strings.TrimSpace(call.Arguments); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &args); err != nil {
+			return nil, 0, fmt.Errorf("arguments are not a valid JSON object: %v", err)
+		}
+	}
+	limit := clamp(args.Limit, defaultToolLimit, maxToolLimit)
+
+	switch call.Name {
+	case "get_node":
+		r, err := tb.getNode(args.ID)
+		return r, 1, err
+	case "inbound_edges":
+		r, err := tb.edges(args.ID, args.Relationship, limit, true)
+		return r, len(r.Edges), err
+	case "outbound_edges":
+		r, err := tb.edges(args.ID, args.Relationship, limit, false)
+		return r, len(r.Edges), err
+	case "group_members":
+		r, err := tb.groupMembers(args.ID, args.Recursive, limit)
+		return r, len(r.Members), err
+	case "shortest_path_to_tier0":
+		r, err := tb.shortestPath(args.ID, clamp(args.MaxHops, defaultMaxHops, maxMaxHops))
+		return r, len(r.Path), err
+	case "search_nodes":
+		r, err := tb.search(args, limit)
+		return r, len(r.Nodes), err
+	}
+	return nil, 0, fmt.Errorf("unknown tool %q", call.Name)
+}
+
+// clamp returns n within [1, max], or def when n is unset
+func clamp(n, def, max int) int {
+	if n <= 0 {
+		return def
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
+
+// resolve maps an id from the model to an object identifier and the
+// loaded node, which is nil for principals only known from edges
+func (tb *toolbox) resolve(ref string) (string, *bloodhound.GraphNode, error) {
+	if strings.TrimSpace(ref) == "" {
+		return "", nil, fmt.Errorf("id is required")
+	}
+	if tb.tokenizer != nil {
+		real, ok := tb.tokenizer.Resolve(ref)
+		if !ok {
+			return "", nil, fmt.Errorf("unknown id %q; pass ids exactly as tools return them", ref)
+		}
+		ref = real
+	}
+	if n, ok := tb.graph.Node(ref); ok {
+		return strings.ToUpper(n.ObjectIdentifier), n, nil
+	}
+	id := strings.ToUpper(strings.TrimSpace(ref))
+	if len(tb.graph.Outbound(id)) > 0 || len(tb.graph.Inbound(id)) > 0 {
+		return id, nil, nil
+	}
+	return "", nil, fmt.Errorf("no node %q in the loaded data", ref)
+}
+
+// nodeView is a node as the model sees it
+type nodeView struct {
+	ID              string `json:"id"`
+	Name            string `json:"name,omitempty"` // Cloak off only; with it on, the id is the token
+	Kind            string `json:"kind"`
+	Domain          string `json:"domain,omitempty"`
+	Tier0           bool   `json:"tier0,omitempty"`
+	HighValue       bool   `json:"highvalue,omitempty"`
+	AdminCount      bool   `json:"admincount,omitempty"`
+	Enabled         *bool  `json:"enabled,omitempty"` // Users and computers
+	PasswordAgeDays *int64 `json:"password_age_days,omitempty"`
+	OperatingSystem string `json:"operating_system,omitempty"`
+	Description     string `json:"description,omitempty"` // Cloak off only
+}
+
+// view describes the node with object identifier id; kind is used when
+// the node is not loaded
+func (tb *toolbox) view(id, kind string) nodeView {
+	n, ok := tb.graph.Lookup(id)
+	if !ok {
+		if kind == "" {
+			kind = "Unknown"
+		}
+		return nodeView{ID: tb.ref(id, nil), Kind: kind, Tier0: tb.graph.IsTier0(id)}
+	}
+
+	v := nodeView{
+		ID:         tb.ref(id, n),
+		Kind:       n.Kind,
+		Domain:     n.Properties.Domain,
+		Tier0:      tb.graph.IsTier0(id),
+		HighValue:  n.Properties.HighValue,
+		AdminCount: n.Properties.AdminCount,
+	}
+	if n.Kind == bloodhound.KindUser || n.Kind == bloodhound.KindComputer {
+		enabled := n.Properties.Enabled
+		v.Enabled = &enabled
+		v.OperatingSystem = n.Properties.OperatingSystem
+	}
+	if n.Properties.PasswordLastSet > 0 {
+		days := int64(time.Since(time.Unix(n.Properties.PasswordLastSet, 0)).Hours() / 24)
+		v.PasswordAgeDays = &days
+	}
+	if tb.tokenizer != nil {
+		v.Domain = tb.tokenizer.TokenizeDomain(v.Domain)
+	} else {
+		v.Name = n.Properties.Name
+		v.Description = n.Properties.Description
+	}
+	return v
+}
+
+// ref is the id the model sees for an object: its identifier with the
+// cloak off, otherwise a token of its name by kind
+func (tb *toolbox) ref(id string, n *bloodhound.GraphNode) string {
+	if tb.tokenizer == nil {
+		return id
+	}
+	if n == nil || n.Properties.Name == "" {
+		return tb.tokenizer.TokenizeSID(id)
+	}
+	name := n.Properties.Name
+	switch n.Kind {
+	case bloodhound.KindUser:
+		return tb.tokenizer.TokenizeUser(name)
+	case bloodhound.KindGroup:
+		return tb.tokenizer.TokenizeGroup(name)
+	case bloodhound.KindComputer:
+		tier := 2
+		if tb.graph.IsTier0(id) {
+			tier = 0
+		}
+		return tb.tokenizer.TokenizeComputer(name, tier)
+	case bloodhound.KindDomain:
+		return tb.tokenizer.TokenizeDomain(name)
+	case bloodhound.KindGPO:
+		return tb.tokenizer.TokenizeGPO(name)
+	case bloodhound.KindOU, bloodhound.KindContainer:
+		return tb.tokenizer.TokenizeOU(name)
+	case bloodhound.KindCertTemplate:
+		return tb.tokenizer.TokenizeTemplate(name)
+	case bloodhound.KindEnterpriseCA:
+		return tb.tokenizer.TokenizeCA(name)
+	}
+	return tb.tokenizer.TokenizeSID(id)
+}
+
+// edgeView is an edge as the model sees it
+type edgeView struct {
+	Source       string `json:"source"`
+	SourceKind   string `json:"source_kind,omitempty"`
+	Target       string `json:"target"`
+	TargetKind   string `json:"target_kind,omitempty"`
+	Relationship string `json:"relationship"`
+	Inherited    bool   `json:"inherited,omitempty"`
+}
+
+func (tb *toolbox) edgeView(e bloodhound.Edge) edgeView {
+	source := tb.view(e.Source, e.SourceKind)
+	target := tb.view(e.Target, "")
+	return edgeView{
+		Source:       source.ID,
+		SourceKind:   source.Kind,
+		Target:       target.ID,
+		TargetKind:   target.Kind,
+		Relationship: e.Kind,
+		Inherited:    e.Inherited,
+	}
+}
+
+type nodeResult struct {
+	Node     nodeView `json:"node"`
+	Loaded   bool     `json:"loaded"` // False for principals only named by ACEs or memberships
+	Inbound  int      `json:"inbound_edges"`
+	Outbound int      `json:"outbound_edges"`
+}
+
+func (tb *toolbox) getNode(ref string) (nodeResult, error) {
+	id, n, err := tb.resolve(ref)
+	if err != nil {
+		return nodeResult{}, err
+	}
+	return nodeResult{
+		Node:     tb.view(id, ""),
+		Loaded:   n != nil,
+		Inbound:  len(tb.graph.Inbound(id)),
+		Outbound: len(tb.graph.Outbound(id)),
+	}, nil
+}
+
+type edgesResult struct {
+	Total     int        `json:"total"`
+	Truncated bool       `json:"truncated,omitempty"`
+	Edges     []edgeView `json:"edges"`
+}
+
+func (tb *toolbox) edges(ref, relationship string, limit int, inbound bool) (edgesResult, error) {
+	id, _, err := tb.resolve(ref)
+	if err != nil {
+		return edgesResult{}, err
+	}
+	all := tb.graph.Outbound(id)
+	if inbound {
+		all = tb.graph.Inbound(id)
+	}
+
+	result := edgesResult{Edges: []edgeView{}}
+	for _, e := range all {
+		if relationship != "" && !strings.EqualFold(e.Kind, relationship) {
+			continue
+		}
+		result.Total++
+		if len(result.Edges) < limit {
+			result.Edges = append(result.Edges, tb.edgeView(e))
+		}
+	}
+	result.Truncated = result.Total > len(result.Edges)
+	return result, nil
+}
+
+type memberView struct {
+	nodeView
+	Via string `json:"via,omitempty"` // Nested group the member belongs to
+}
+
+type membersResult struct {
+	Group     string       `json:"group"`
+	Total     int          `json:"total"`
+	Truncated bool         `json:"truncated,omitempty"`
+	Members   []memberView `json:"members"`
+}
+
+func (tb *toolbox) groupMembers(ref string, recursive bool, limit int) (membersResult, error) {
+	id, n, err := tb.resolve(ref)
+	if err != nil {
+		return membersResult{}, err
+	}
+	if n == nil || n.Kind != bloodhound.KindGroup {
+		return membersResult{}, fmt.Errorf("%s is not a loaded group", ref)
+	}
+
+	result := membersResult{Group: tb.ref(id, n), Members: []memberView{}}
+	seen := map[string]bool{id: true}
+	queue := []string{id}
+	for len(queue) > 0 {
+		group := queue[0]
+		queue = queue[1:]
+		for _, e := range tb.graph.Inbound(group) {
+			if e.Kind != bloodhound.EdgeMemberOf || seen[e.Source] {
+				continue
+			}
+			seen[e.Source] = true
+			result.Total++
+			member := memberView{nodeView: tb.view(e.Source, e.SourceKind)}
+			if group != id {
+				member.Via = tb.view(group, "").ID
+			}
+			if len(result.Members) < limit {
+				result.Members = append(result.Members, member)
+			}
+			if recursive && member.Kind == bloodhound.KindGroup {
+				queue = append(queue, e.Source)
+			}
+		}
+	}
+	result.Truncated = result.Total > len(result.Members)
+	return result, nil
+}
+
+type pathResult struct {
+	From  string     `json:"from"`
+	Found bool       `json:"found"`
+	Hops  int        `json:"hops"`
+	Path  []edgeView `json:"path"`
+	Note  string     `json:"note,omitempty"`
+}
+
+func (tb *toolbox) shortestPath(ref string, maxHops int) (pathResult, error) {
+	id, _, err := tb.resolve(ref)
+	if err != nil {
+		return pathResult{}, err
+	}
+	path, found := tb.graph.ShortestPathToTier0(id, maxHops)
+
+	result := pathResult{From: tb.view(id, "").ID, Found: found, Hops: len(path), Path: []edgeView{}}
+	for _, e := range path {
+		result.Path = append(result.Path, tb.edgeView(e))
+	}
+	switch {
+	case found && len(path) == 0:
+		result.Note = "the node is Tier 0 itself"
+	case !found:
+		result.Note = fmt.Sprintf("no path within %d hops", maxHops)
+	}
+	return result, nil
+}
+
+type searchResult struct {
+	Total     int        `json:"total"`
+	Truncated bool       `json:"truncated,omitempty"`
+	Nodes     []nodeView `json:"nodes"`
+}
+
+func (tb *toolbox) search(args toolArgs, limit int) (searchResult, error) {
+	// With the cloak on a query can only be a known token; matching parts
+	// of real names would let the model guess them
+	query := strings.ToUpper(strings.TrimSpace(args.Query))
+	var match string
+	if query != "" && tb.tokenizer != nil {
+		id, _, err := tb.resolve(args.Query)
+		if err != nil {
+			return searchResult{}, fmt.Errorf("with the Privacy Cloak on, query must be an id as tools return it: %v", err)
+		}
+		match = id
+	}
+
+	result := searchResult{Nodes: []nodeView{}}
+	for _, n := range tb.graph.Nodes() {
+		id := strings.ToUpper(n.ObjectIdentifier)
+		switch {
+		case match != "" && id != match:
+			continue
+		case match == "" && query != "" && !strings.Contains(strings.ToUpper(n.Properties.Name), query) && id != query:
+			continue
+		case args.Kind != "" && !strings.EqualFold(n.Kind, args.Kind):
+			continue
+		case args.HighValue != nil && n.Properties.HighValue != *args.HighValue:
+			continue
+		case args.AdminCount != nil && n.Properties.AdminCount != *args.AdminCount:
+			continue
+		case args.Enabled != nil && n.Properties.Enabled != *args.Enabled:
+			continue
+		}
+		result.Total++
+		if len(result.Nodes) < limit {
+			result.Nodes = append(result.Nodes, tb.view(id, ""))
+		}
+	}
+	result.Truncated = result.Total > len(result.Nodes)
+	return result, nil
+}
+
+// dossier is everything the deep dive of one entity is based on
+type dossier struct {
+	Node        nodeResult     `json:"entity"`
+	Inbound     edgesResult    `json:"inbound_edges"`
+	Outbound    edgesResult    `json:"outbound_edges"`
+	MemberOf    []memberView   `json:"member_of"` // Through nesting; via names the group in between
+	MemberOfCut bool           `json:"member_of_truncated,omitempty"`
+	Members     *membersResult `json:"members,omitempty"`
+	PathToTier0 pathResult     `json:"path_to_tier0"`
+}
+
+// dossier gathers the neighbourhood of the entity ref, as the tools would
+// report it
+func (tb *toolbox) dossier(ref string, limit int) (*dossier, error) {
+	id, n, err := tb.resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &dossier{MemberOf: []memberView{}}
+	if d.Node, err = tb.getNode(ref); err != nil {
+		return nil, err
+	}
+	if d.Inbound, err = tb.edges(ref, "", limit, true); err != nil {
+		return nil, err
+	}
+	if d.Outbound, err = tb.edges(ref, "", limit, false); err != nil {
+		return nil, err
+	}
+	if d.PathToTier0, err = tb.shortestPath(ref, maxMaxHops); err != nil {
+		return nil, err
+	}
+	if n != nil && n.Kind == bloodhound.KindGroup {
+		members, err := tb.groupMembers(ref, true, limit)
+		if err != nil {
+			return nil, err
+		}
+		d.Members = &members
+	}
+
+	// Groups the entity belongs to, directly or through nested groups
+	seen := map[string]bool{id: true}
+	queue := []string{id}
+	for len(queue) > 0 && !d.MemberOfCut {
+		member := queue[0]
+		queue = queue[1:]
+		for _, e := range tb.graph.Outbound(member) {
+			if e.Kind != bloodhound.EdgeMemberOf || seen[e.Target] {
+				continue
+			}
+			if len(d.MemberOf) >= limit {
+				d.MemberOfCut = true
+				break
+			}
+			seen[e.Target] = true
+			group := memberView{nodeView: tb.view(e.Target, bloodhound.KindGroup)}
+			if member != id {
+				group.Via = tb.view(member, "").ID
+			}
+			d.MemberOf = append(d.MemberOf, group)
+			queue = append(queue, e.Target)
+		}
+	}
+	return d, nil
+}
@@ -0,0 +1,37 @@
+package necromancy
+
+import (
+	"testing"
+
+	"ad-necromancer/internal/bloodhound"
+)
+
+func TestDossierLimitsMemberOf(t *testing.T) {
+	user := bloodhound.Node{ObjectIdentifier: "S-1-5-21-100-1105", Properties: bloodhound.Properties{Name: "EVE@CORP.LOCAL"}}
+	data := &bloodhound.BloodHoundData{Users: []bloodhound.Node{user}}
+	for _, id := range []string{"S-1-5-21-100-1201", "S-1-5-21-100-1202", "S-1-5-21-100-1203"} {
+		data.Groups = append(data.Groups, bloodhound.Node{
+			ObjectIdentifier: id,
+			Members:          []bloodhound.Member{{ObjectIdentifier: user.ObjectIdentifier, ObjectType: "User"}},
+		})
+	}
+	tb := &toolbox{graph: bloodhound.NewGraph(data)}
+
+	tests := []struct {
+		limit int
+		want  int
+		cut   bool
+	}{
+		{limit: 2, want: 2, cut: true},
+		{limit: 3, want: 3, cut: false},
+	}
+	for _, tt := range tests {
+		d, err := tb.dossier(user.ObjectIdentifier, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(d.MemberOf) != tt.want || d.MemberOfCut != tt.cut {
+			t.Errorf("limit %d: %d groups, truncated %v; want %d, %v", tt.limit, len(d.MemberOf), d.MemberOfCut, tt.want, tt.cut)
+		}
+	}
+}
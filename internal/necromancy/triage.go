@@ -0,0 +1,346 @@
+package necromancy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"ad-necromancer/internal/ai"
+	"ad-necromancer/internal/bloodhound"
+	"ad-necromancer/internal/prompts"
+)
+
+// Defaults for two-phase runs
+const (
+	DefaultDeepDives           = 5
+	DefaultDeepDiveConcurrency = 3
+)
+
+// Triage overview and dossier limits
+const (
+	triageOverviewMax = 1500 // Entities listed; the ones left out are the farthest from Tier 0
+	triageMaxHops     = 6    // Longest path to Tier 0 shown in the overview
+	overviewEdges     = 3    // Control edges sampled per entity
+	dossierLimit      = 50   // Edges, members and groups per dossier section
+)
+
+// TriageOptions configures two-phase runs
+type TriageOptions struct {
+	DeepDives   int // Top candidates analysed in depth
+	Concurrency int // Deep dives in flight at once, per backend
+}
+
+// DeepDiveStats records one deep dive of a two-phase run
+type DeepDiveStats struct {
+	Backend   string   `json:"backend"`
+	Rank      int      `json:"rank"`
+	Candidate string   `json:"candidate"` // Real name, also when the model saw a token
+	Suspicion string   `json:"suspicion"`
+	Reason    string   `json:"reason"`
+	Findings  int      `json:"findings"`
+	Usage     ai.Usage `json:"usage"`
+	Cost      float64  `json:"cost"` // USD, for calls to priced models
+	Error     string   `json:"error,omitempty"`
+}
+
+// summonTriage runs the two-phase pipeline on b: a compact overview of
+// every entity from which the model ranks suspicious candidates, then a
+// focused prompt per top candidate with its full neighbourhood. Deep dives
+// run concurrently; one failing does not fail the others. Returned findings
+// are still in tokenized form.
+func (e *Engine) summonTriage(ctx context.Context, b Backend) ([]ZombiePath, error) {
+	tb := e.toolbox()
+	opts := e.TriageOptions
+	if opts.DeepDives < 1 {
+		opts.DeepDives = DefaultDeepDives
+	}
+	if opts.Concurrency < 1 {
+		opts.Concurrency = DefaultDeepDiveConcurrency
+	}
+
+	candidates, err := e.triage(withChunk(ctx, chunkTriage), b, tb, opts.DeepDives)
+	if err != nil {
+		return nil, err
+	}
+	e.printf("[*] Triage: %s picked %d candidate(s); deep-diving %d at a time...\n",
+		b.Name, len(candidates), opts.Concurrency)
+
+	results, errs := runOrdered(ctx, len(candidates), opts.Concurrency, func(ctx context.Context, i int) ([]ZombiePath, error) {
+		return e.deepDive(withChunk(ctx, deepDiveChunk(i+1)), b, tb, candidates[i])
+	})
+
+	// Collect in rank order, so findings and stats do not depend on which
+	// deep dive finished first
+	var accepted []ZombiePath
+	seen := make(map[string]bool)
+	var lastErr, budgetErr error
+	failed := 0
+	for i, c := range candidates {
+		e.recordDeepDive(ctx, tb, b, i+1, c, len(results[i]), errs[i])
+		if errs[i] != nil {
+			failed++
+			lastErr = errs[i]
+			if errors.Is(errs[i], ErrBudgetExceeded) {
+				budgetErr = errs[i]
+			}
+			if ctx.Err() == nil && !errors.Is(errs[i], ErrBudgetExceeded) {
+				e.printf("[!] Deep dive %d failed: %v\n", i+1, errs[i])
+			}
+		}
+		for _, p := range results[i] {
+			key := findingKey(p)
+			if !seen[key] {
+				seen[key] = true
+				accepted = append(accepted, p)
+			}
+		}
+	}
+
+	switch {
+	case ctx.Err() != nil:
+		return accepted, ctx.Err()
+	case budgetErr != nil:
+		// Partial findings are kept; the caller must not fail over
+		return accepted, budgetErr
+	case failed == len(candidates):
+		return nil, fmt.Errorf("all %d deep dives failed; last error: %w", failed, lastErr)
+	}
+	return accepted, nil
+}
+
+// triage asks b to rank the entities of the overview and returns up to
+// want candidates whose ids resolve, most suspicious first
+func (e *Engine) triage(ctx context.Context, b Backend, tb *toolbox, want int) ([]TriageCandidate, error) {
+	maxAttempts := e.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	data := e.data()
+	overview, listed := triageOverview(tb)
+	e.printf("[*] Triage: %s is ranking %d entities...\n", b.Name, listed)
+
+	options := e.Options
+	options.Schema = TriageSchema()
+	messages := []ai.Message{
+		{Role: ai.RoleSystem, Content: prompts.TriageSystemPrompt},
+		{Role: ai.RoleUser, Content: e.scopeNote() + fmt.Sprintf(prompts.TriagePrompt,
+			len(data.Users), len(data.Groups), len(data.Computers), len(data.Domains), want, overview)},
+	}
+
+	for attempt := 1; ; attempt++ {
+		reply, err := e.chat(ctx, b, &ai.ChatRequest{Messages: messages, Options: options})
+		if err != nil {
+			return nil, err
+		}
+		e.recordAttempt()
+
+		candidates, ignored, err := tb.candidates(reply.Text, want)
+		if err == nil {
+			if ignored > 0 {
+				e.printf("[~] Triage: ignoring %d candidate(s) not in the overview\n", ignored)
+			}
+			return candidates, nil
+		}
+		if attempt == maxAttempts {
+			return nil, fmt.Errorf("failed to parse triage response after %d attempt(s): %w", attempt, err)
+		}
+
+		e.printf("[~] Triage output rejected (%v); requesting a correction (attempt %d/%d)...\n",
+			err, attempt+1, maxAttempts)
+		messages = append(messages,
+			ai.Message{Role: ai.RoleAssistant, Content: reply.Text},
+			ai.Message{Role: ai.RoleUser, Content: fmt.Sprintf(prompts.TriageCorrectionPrompt, err)},
+		)
+	}
+}
+
+// candidates parses a triage response, keeping the first want candidates
+// that name a distinct entity of the graph. It also returns how many
+// candidates named no entity.
+func (tb *toolbox) candidates(text string, want int) ([]TriageCandidate, int, error) {
+	ranked, err := decodeCandidates(cleanJSON(text))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var out []TriageCandidate
+	seen := make(map[string]bool)
+	var unknown []string
+	for _, c := range ranked {
+		c.ID = strings.TrimSpace(c.ID)
+		id, _, err := tb.resolve(c.ID)
+		if err != nil {
+			unknown = append(unknown, c.ID)
+			continue
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		out = append(out, c)
+		if len(out) == want {
+			break
+		}
+	}
+
+	if len(out) == 0 {
+		return nil, 0, fmt.Errorf("no candidate id matches the overview (got %s)", strings.Join(unknown, ", "))
+	}
+	return out, len(unknown), nil
+}
+
+// deepDive asks b for the findings around one candidate, from its dossier
+func (e *Engine) deepDive(ctx context.Context, b Backend, tb *toolbox, c TriageCandidate) ([]ZombiePath, error) {
+	d, err := tb.dossier(c.ID, dossierLimit)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	prompt := fmt.Sprintf(prompts.DeepDivePrompt, c.ID, c.Reason, string(raw))
+	return e.summonFindings(ctx, b, prompts.NecromancerSystemPrompt, prompt)
+}
+
+// recordDeepDive records the outcome of the deep dive into candidate c,
+// with real names and what its calls used
+func (e *Engine) recordDeepDive(ctx context.Context, tb *toolbox, b Backend, rank int, c TriageCandidate, findings int, err error) {
+	stats := DeepDiveStats{
+		Backend:   b.Name,
+		Rank:      rank,
+		Candidate: c.ID,
+		Suspicion: c.Suspicion,
+		Reason:    c.Reason,
+		Findings:  findings,
+	}
+	if err != nil {
+		stats.Error = err.Error()
+	}
+	if tb.tokenizer != nil {
+		stats.Candidate = tb.tokenizer.Detokenize(stats.Candidate)
+		stats.Reason = tb.tokenizer.Detokenize(stats.Reason)
+	}
+
+	domain, _ := callLabels(ctx)
+	chunk := deepDiveChunk(rank)
+
+	e.statsMu.Lock()
+	defer e.statsMu.Unlock()
+	stats.Usage, stats.Cost = e.Stats.usageOf(func(call CallStats) bool {
+		return call.Backend == b.Name && call.Domain == domain && call.Chunk == chunk
+	})
+	e.Stats.DeepDives = append(e.Stats.DeepDives, stats)
+}
+
+// overviewRow is one entity of the triage overview
+type overviewRow struct {
+	id      string
+	node    *bloodhound.GraphNode
+	hops    int // To Tier 0; -1 without a path
+	control []bloodhound.Edge
+	groups  int
+}
+
+// signal reports whether the entity shows anything worth ranking
+func (r overviewRow) signal() bool {
+	return r.hops >= 0 || len(r.control) > 0 || r.node.Properties.AdminCount || r.node.Properties.HighValue
+}
+
+// distance orders rows by hops to Tier 0, those without a path last
+func (r overviewRow) distance() int {
+	if r.hops < 0 {
+		return triageMaxHops + 1
+	}
+	return r.hops
+}
+
+// triageOverview lists every entity on one line, those with a control
+// signal first and, among them, the closest to Tier 0 first. It returns the
+// listing and the number of entities in it.
+func triageOverview(tb *toolbox) (string, int) {
+	dist := tb.graph.DistancesToTier0(triageMaxHops)
+
+	rows := make([]overviewRow, 0, len(tb.graph.Nodes()))
+	for _, n := range tb.graph.Nodes() {
+		r := overviewRow{id: strings.ToUpper(n.ObjectIdentifier), node: n, hops: -1}
+		if hops, ok := dist[r.id]; ok {
+			r.hops = hops
+		}
+		for _, e := range tb.graph.Outbound(r.id) {
+			if e.Kind == bloodhound.EdgeMemberOf {
+				r.groups++
+			} else {
+				r.control = append(r.control, e)
+			}
+		}
+		rows = append(rows, r)
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].signal() != rows[j].signal() {
+			return rows[i].signal()
+		}
+		return rows[i].distance() < rows[j].distance()
+	})
+
+	lines := make([]string, 0, min(len(rows), triageOverviewMax)+1)
+	for i, r := range rows {
+		if i == triageOverviewMax {
+			lines = append(lines, overviewCut(rows[i:]))
+			break
+		}
+		lines = append(lines, tb.overviewLine(r))
+	}
+	return strings.Join(lines, "\n"), min(len(rows), triageOverviewMax)
+}
+
+// overviewCut describes the rows left out of the overview
+func overviewCut(rest []overviewRow) string {
+	signal := 0
+	for _, r := range rest {
+		if r.signal() {
+			signal++
+		}
+	}
+	if signal == 0 {
+		return fmt.Sprintf("... %d more without control edges or a path to Tier 0", len(rest))
+	}
+	return fmt.Sprintf("... %d more: %d with a control signal but farther from Tier 0, %d without", len(rest), signal, len(rest)-signal)
+}
+
+// overviewLine renders one row of the triage overview
+func (tb *toolbox) overviewLine(r overviewRow) string {
+	v := tb.view(r.id, "")
+	// The id stays alone in its column: the model copies it back verbatim
+	name := v.Name
+	if name == "" {
+		name = "-"
+	}
+
+	flags := strings.Join(nodeFlags(v)[1:], ", ")
+	if flags == "" {
+		flags = "-"
+	}
+
+	control := "-"
+	if len(r.control) > 0 {
+		var sample []string
+		for _, e := range r.control[:min(len(r.control), overviewEdges)] {
+			sample = append(sample, e.Kind+"→"+tb.view(e.Target, "").ID)
+		}
+		control = strings.Join(sample, ", ")
+		if more := len(r.control) - len(sample); more > 0 {
+			control += fmt.Sprintf(" (+%d)", more)
+		}
+	}
+
+	hops := "-"
+	if r.hops >= 0 {
+		hops = fmt.Sprint(r.hops)
+	}
+	return fmt.Sprintf("%s | %s | %s | %s | %s | %d | %s", v.ID, name, v.Kind, flags, control, r.groups, hops)
+}
@@ -0,0 +1,56 @@
+package necromancy
+
+import (
+	"strings"
+	"testing"
+
+	"ad-necromancer/internal/bloodhound"
+)
+
+func TestTriageOverviewRanksByHopsToTier0(t *testing.T) {
+	data := forestFixture()
+	// Control over a group with no path to Tier 0, listed before EVE
+	mallory := bloodhound.Node{
+		ObjectIdentifier: "S-1-5-21-200-1107",
+		Properties:       bloodhound.Properties{Name: "MALLORY@CHILD.CORP.LOCAL", Domain: "CHILD.CORP.LOCAL"},
+	}
+	data.Users = append([]bloodhound.Node{mallory}, data.Users...)
+	data.Groups[2].Aces = append(data.Groups[2].Aces, bloodhound.Ace{PrincipalSID: mallory.ObjectIdentifier, PrincipalType: "User", RightName: "GenericAll"})
+
+	overview, n := triageOverview(&toolbox{graph: bloodhound.NewGraph(data)})
+	lines := strings.Split(overview, "\n")
+	if n != len(lines) {
+		t.Fatalf("triageOverview reported %d entities for %d lines", n, len(lines))
+	}
+
+	var order []string
+	for _, line := range lines {
+		cols := strings.Split(line, " | ")
+		order = append(order, cols[1]+" "+cols[len(cols)-1])
+	}
+	want := []string{
+		"CORP.LOCAL 0",
+		"CHILD.CORP.LOCAL 0",
+		"DOMAIN ADMINS@CORP.LOCAL 0",
+		"FINANCE ADMINS@CORP.LOCAL 1",
+		"EVE@CHILD.CORP.LOCAL 2",
+		"MALLORY@CHILD.CORP.LOCAL -", // A control signal, but no path
+		"BOB@CHILD.CORP.LOCAL -",
+		"HELPDESK@CORP.LOCAL -",
+	}
+	if strings.Join(order, "\n") != strings.Join(want, "\n") {
+		t.Errorf("overview order:\n%s\nwant:\n%s", strings.Join(order, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestOverviewCut(t *testing.T) {
+	signal := overviewRow{hops: 4, node: &bloodhound.GraphNode{Node: &bloodhound.Node{}}}
+	quiet := overviewRow{hops: -1, node: &bloodhound.GraphNode{Node: &bloodhound.Node{}}}
+
+	if got, want := overviewCut([]overviewRow{quiet, quiet}), "... 2 more without control edges or a path to Tier 0"; got != want {
+		t.Errorf("overviewCut = %q, want %q", got, want)
+	}
+	if got, want := overviewCut([]overviewRow{signal, quiet, quiet}), "... 3 more: 1 with a control signal but farther from Tier 0, 2 without"; got != want {
+		t.Errorf("overviewCut = %q, want %q", got, want)
+	}
+}
@@ -1,12 +1,19 @@
 package ollama
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ad-necromancer/internal/ai"
+	"ad-necromancer/internal/transport"
 )
 
 const (
@@ -14,20 +21,107 @@ const (
 	defaultModel    = "llama3"
 )
 
+// Format modes for OLLAMA_FORMAT
+const (
+	FormatSchema = "schema" // JSON Schema as the format (Ollama 0.5+), falling back to prompt-only
+	FormatJSON   = "json"   // Any valid JSON
+	FormatNone   = "none"   // No constraint
+)
+
+// Ollama's context window when neither the request nor the Modelfile sets
+// num_ctx
+const defaultNumCtx = 2048
+
+// charsPerToken estimates prompt tokens before the server counts them
+const charsPerToken = 3
+
 type Client struct {
-	Endpoint string
-	Model    string
+	Endpoint  string
+	Model     string
+	NumCtx    int    // Context window in tokens; 0 = the model's Modelfile or Ollama default
+	KeepAlive string // How long the model stays loaded, e.g. "10m" or "-1"; empty = server default
+	Format    string // One of the Format* modes
+	Transport *transport.Client
+
+	formatUnsupported atomic.Bool // set once the server rejects a schema format
+
+	showOnce sync.Once
+	show     *ModelInfo // nil when /api/show failed
+}
+
+type ChatRequest struct {
+	Model     string      `json:"model"`
+	Messages  []Message   `json:"messages"`
+	Stream    bool        `json:"stream"`
+	Options   Options     `json:"options"`
+	KeepAlive interface{} `json:"keep_alive,omitempty"` // Duration string or seconds
+	Tools     []Tool      `json:"tools,omitempty"`
+
+	// Format is either the string "json" or a JSON Schema object (Ollama 0.5+)
+	Format interface{} `json:"format,omitempty"`
+}
+
+type Message struct {
+	Role      string     `json:"role"`
+	Content   string     `json:"content"`
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	ToolName  string     `json:"tool_name,omitempty"` // Tool whose result a "tool" message carries
+}
+
+// Tool declares a function the model may call (Ollama 0.3+, models with
+// tool support such as llama3.1, qwen2.5 or mistral-nemo)
+type Tool struct {
+	Type     string       `json:"type"` // Always "function"
+	Function FunctionSpec `json:"function"`
+}
+
+type FunctionSpec struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters"`
+}
+
+// ToolCall is a function call in an assistant message; unlike OpenAI,
+// Ollama passes the arguments as a JSON object
+type ToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+// Options are Ollama model parameters
+type Options struct {
+	Temperature float64  `json:"temperature"`
+	NumPredict  int      `json:"num_predict,omitempty"`
+	NumCtx      int      `json:"num_ctx,omitempty"`
+	Seed        *int     `json:"seed,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+}
+
+type ChatResponse struct {
+	Model           string  `json:"model"`
+	Message         Message `json:"message"`
+	Done            bool    `json:"done"`
+	DoneReason      string  `json:"done_reason"`
+	PromptEvalCount int     `json:"prompt_eval_count"`
+	EvalCount       int     `json:"eval_count"`
 }
 
-type GenerateRequest struct {
-	Model  string `json:"model"`
-	Prompt string `json:"prompt"`
-	Stream bool   `json:"stream"`
+type ShowRequest struct {
+	Model string `json:"model"`
+	Name  string `json:"name"` // Field name before Ollama 0.3
 }
 
-type GenerateResponse struct {
-	Response string `json:"response"`
-	Done     bool   `json:"done"`
+type ShowResponse struct {
+	Parameters string                 `json:"parameters"` // Modelfile PARAMETER lines
+	ModelInfo  map[string]interface{} `json:"model_info"`
+}
+
+// ModelInfo is what /api/show tells us about the model's context
+type ModelInfo struct {
+	ContextLength int // Longest context the model was trained for; 0 if not reported
+	NumCtx        int // Context window the Modelfile sets; 0 if unset
 }
 
 // NewClient creates a new Ollama client
@@ -42,47 +136,342 @@ func NewClient() (*Client, error) {
 		model = defaultModel
 	}
 
-	return &Client{
-		Endpoint: endpoint,
-		Model:    model,
-	}, nil
+	c := &Client{
+		Endpoint:  strings.TrimRight(endpoint, "/"),
+		Model:     model,
+		Format:    FormatSchema,
+		Transport: transport.New("Ollama", 10*time.Minute), // Local inference on large prompts is slow
+	}
+
+	if v := os.Getenv("OLLAMA_NUM_CTX"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid OLLAMA_NUM_CTX %q: want a token count", v)
+		}
+		c.NumCtx = n
+	}
+
+	if v := os.Getenv("OLLAMA_KEEP_ALIVE"); v != "" {
+		if _, err := strconv.Atoi(v); err != nil {
+			if _, err := time.ParseDuration(v); err != nil {
+				return nil, fmt.Errorf("invalid OLLAMA_KEEP_ALIVE %q: want a duration such as 10m, or seconds (-1 = forever)", v)
+			}
+		}
+		c.KeepAlive = v
+	}
+
+	if v := os.Getenv("OLLAMA_FORMAT"); v != "" {
+		switch v {
+		case FormatSchema, FormatJSON, FormatNone:
+			c.Format = v
+		default:
+			return nil, fmt.Errorf("invalid OLLAMA_FORMAT %q: want schema, json or none", v)
+		}
+	}
+
+	return c, nil
+}
+
+func init() {
+	ai.Register(ai.Backend{
+		Name:        "ollama",
+		Description: "Ollama (on-premise)",
+		ModelEnv:    "OLLAMA_MODEL",
+		Config: []ai.ConfigKey{
+			{Env: "OLLAMA_ENDPOINT", Description: "Server URL", Default: defaultEndpoint},
+			{Env: "OLLAMA_MODEL", Description: "Model name", Default: defaultModel},
+			{Env: "OLLAMA_NUM_CTX", Description: "Context window in tokens (num_ctx)", Default: "Modelfile or 2048"},
+			{Env: "OLLAMA_KEEP_ALIVE", Description: "How long the model stays loaded, e.g. 30m or -1", Default: "5m"},
+			{Env: "OLLAMA_FORMAT", Description: "Output constraint: schema, json or none", Default: FormatSchema},
+		},
+		Capabilities: ai.Capabilities{Local: true, Structured: "format schema", Seed: true, Tools: true},
+		New: func(cfg ai.Config) (ai.AIClient, error) {
+			c, err := NewClient()
+			if err != nil {
+				return nil, err
+			}
+			if cfg.Model != "" {
+				c.Model = cfg.Model
+			}
+			return c, nil
+		},
+	})
 }
 
 // Summon implements the AIClient interface
 func (c *Client) Summon(systemPrompt, userPrompt string) (string, error) {
-	// Combine system and user prompts for Ollama
-	combinedPrompt := fmt.Sprintf("%s\n\n%s", systemPrompt, userPrompt)
+	return ai.Summon(c, systemPrompt, userPrompt)
+}
+
+// Chat implements the AIClient interface. A schema is passed as the request
+// format; older Ollama releases that only understand format "json" (or
+// nothing at all) fall back to prompt-only output.
+func (c *Client) Chat(ctx context.Context, req *ai.ChatRequest) (*ai.ChatResponse, error) {
+	c.checkContext(ctx, req)
+	if c.Format != FormatSchema {
+		return c.chat(ctx, ai.PromptOnly(req), nil)
+	}
+	return ai.ChatWithSchemaFallback(ctx, &c.formatUnsupported, req, c.chat)
+}
+
+// schemaErrorTerms mark a 400 as a refusal of the format field, e.g. an
+// Ollama older than 0.5 expecting a string there
+var schemaErrorTerms = []string{"format", "schema"}
 
-	reqBody := GenerateRequest{
-		Model:  c.Model,
-		Prompt: combinedPrompt,
-		Stream: false,
+func (c *Client) chat(ctx context.Context, req *ai.ChatRequest, schema *ai.Schema) (*ai.ChatResponse, error) {
+	messages := make([]Message, 0, len(req.Messages))
+	for _, m := range req.Messages {
+		msg := Message{Role: m.Role, Content: m.Content, ToolName: m.ToolName}
+		for _, call := range m.ToolCalls {
+			var tc ToolCall
+			tc.Function.Name = call.Name
+			tc.Function.Arguments = json.RawMessage(call.Arguments)
+			if !json.Valid(tc.Function.Arguments) {
+				tc.Function.Arguments = json.RawMessage("{}")
+			}
+			msg.ToolCalls = append(msg.ToolCalls, tc)
+		}
+		messages = append(messages, msg)
 	}
 
-	jsonData, err := json.Marshal(reqBody)
+	reqBody := ChatRequest{
+		Model:    c.Model,
+		Messages: messages,
+		Stream:   false,
+		Options: Options{
+			Temperature: req.Options.TemperatureOrDefault(),
+			NumPredict:  req.Options.MaxTokensOrDefault(),
+			NumCtx:      c.NumCtx,
+			Seed:        req.Options.Seed,
+			Stop:        req.Options.Stop,
+		},
+		KeepAlive: c.keepAlive(),
+	}
+	for _, tool := range req.Tools {
+		reqBody.Tools = append(reqBody.Tools, Tool{
+			Type:     "function",
+			Function: FunctionSpec{Name: tool.Name, Description: tool.Description, Parameters: tool.Parameters},
+		})
+	}
+	switch {
+	case schema != nil:
+		reqBody.Format = schema.Definition
+	case c.Format == FormatJSON && req.Options.Schema != nil:
+		reqBody.Format = "json"
+	}
+
+	var result ChatResponse
+	if err := c.Transport.PostJSON(ctx, c.Endpoint+"/api/chat", nil, reqBody, &result); err != nil {
+		if schema != nil && transport.IsBadRequestAbout(err, schemaErrorTerms...) {
+			return nil, &ai.SchemaRejectedError{Err: err}
+		}
+		if len(req.Tools) > 0 && transport.IsStatus(err, http.StatusBadRequest) {
+			// e.g. "llama3 does not support tools"
+			return nil, &ai.ToolsRejectedError{Err: err}
+		}
+		return nil, err
+	}
+
+	if window := c.contextWindow(); result.PromptEvalCount >= window {
+		fmt.Fprintf(os.Stderr, "[!] Ollama: the prompt filled the %d-token context of %s and was probably truncated; raise OLLAMA_NUM_CTX\n", window, c.Model)
+	}
+
+	reply := &ai.ChatResponse{
+		Text:         result.Message.Content,
+		FinishReason: result.DoneReason,
+		Model:        result.Model,
+		Usage: ai.Usage{
+			PromptTokens:     result.PromptEvalCount,
+			CompletionTokens: result.EvalCount,
+		},
+	}
+	for i, call := range result.Message.ToolCalls {
+		reply.ToolCalls = append(reply.ToolCalls, ai.ToolCall{
+			ID:        ai.ToolCallID("", i),
+			Name:      call.Function.Name,
+			Arguments: string(call.Function.Arguments),
+		})
+	}
+	if len(reply.ToolCalls) > 0 {
+		reply.FinishReason = ai.FinishToolUse // Ollama reports stop
+	}
+	return reply, nil
+}
+
+// SupportsTools implements ai.ToolUser. Models without tool support are
+// rejected by the server, which ends the tool-use loop.
+func (c *Client) SupportsTools() bool {
+	return true
+}
+
+// keepAlive returns KeepAlive as Ollama expects it: plain numbers are
+// seconds, anything else a duration string
+func (c *Client) keepAlive() interface{} {
+	if c.KeepAlive == "" {
+		return nil
+	}
+	if seconds, err := strconv.Atoi(c.KeepAlive); err == nil {
+		return seconds
+	}
+	return c.KeepAlive
+}
+
+// checkContext warns when the request will not fit the context window, which
+// Ollama handles by silently dropping the start of the prompt
+func (c *Client) checkContext(ctx context.Context, req *ai.ChatRequest) {
+	c.showOnce.Do(func() {
+		info, err := c.Show(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[!] Ollama: could not read the context length of %s: %v\n", c.Model, err)
+			return
+		}
+		c.show = info
+		if c.NumCtx > 0 && info.ContextLength > 0 && c.NumCtx > info.ContextLength {
+			fmt.Fprintf(os.Stderr, "[!] Ollama: OLLAMA_NUM_CTX=%d exceeds the %d tokens %s was trained for\n", c.NumCtx, info.ContextLength, c.Model)
+		}
+	})
+
+	chars := 0
+	for _, m := range req.Messages {
+		chars += len(m.Content)
+	}
+	needed := chars/charsPerToken + req.Options.MaxTokensOrDefault()
+	window := c.contextWindow()
+	if needed <= window {
+		return
+	}
+
+	hint := "set OLLAMA_NUM_CTX"
+	if c.show != nil && c.show.ContextLength > 0 {
+		if needed > c.show.ContextLength {
+			hint = fmt.Sprintf("lower --sample-size; %s supports at most %d tokens", c.Model, c.show.ContextLength)
+		} else {
+			hint = fmt.Sprintf("set OLLAMA_NUM_CTX=%d or more (%s supports up to %d)", roundUpCtx(needed), c.Model, c.show.ContextLength)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "[!] Ollama: prompt plus completion need ~%d tokens but the context window is %d; %s\n", needed, window, hint)
+}
+
+// contextWindow returns the num_ctx the server will use
+func (c *Client) contextWindow() int {
+	switch {
+	case c.NumCtx > 0:
+		return c.NumCtx
+	case c.show != nil && c.show.NumCtx > 0:
+		return c.show.NumCtx
+	}
+	return defaultNumCtx
+}
+
+// roundUpCtx rounds a token count up to the next multiple of 4096
+func roundUpCtx(tokens int) int {
+	return (tokens + 4095) / 4096 * 4096
+}
+
+// Show asks the server for the model's context length and Modelfile num_ctx
+func (c *Client) Show(ctx context.Context) (*ModelInfo, error) {
+	var result ShowResponse
+	if err := c.Transport.PostJSON(ctx, c.Endpoint+"/api/show", nil, ShowRequest{Model: c.Model, Name: c.Model}, &result); err != nil {
+		return nil, err
+	}
+
+	info := &ModelInfo{}
+	for key, value := range result.ModelInfo {
+		// e.g. "llama.context_length", "qwen2.context_length"
+		if n, ok := value.(float64); ok && strings.HasSuffix(key, ".context_length") {
+			info.ContextLength = int(n)
+		}
+	}
+	for _, line := range strings.Split(result.Parameters, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "num_ctx" {
+			info.NumCtx, _ = strconv.Atoi(fields[1])
+		}
+	}
+	return info, nil
+}
+
+// Probe implements ai.Prober: it checks the server answers, the model has
+// been pulled and reads its context window, without loading the model
+func (c *Client) Probe(ctx context.Context) (*ai.ProbeResult, error) {
+	var version struct {
+		Version string `json:"version"`
+	}
+	body, err := c.Transport.Do(ctx, http.MethodGet, c.Endpoint+"/api/version", nil, nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+		return nil, err
+	}
+	if err := json.Unmarshal(body, &version); err != nil {
+		return nil, fmt.Errorf("failed to decode Ollama version: %w", err)
 	}
 
-	resp, err := http.Post(
-		c.Endpoint+"/api/generate",
-		"application/json",
-		bytes.NewBuffer(jsonData),
-	)
+	var tags struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	body, err = c.Transport.Do(ctx, http.MethodGet, c.Endpoint+"/api/tags", nil, nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to call Ollama API: %w", err)
+		return nil, err
+	}
+	if err := json.Unmarshal(body, &tags); err != nil {
+		return nil, fmt.Errorf("failed to decode Ollama model list: %w", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("Ollama API returned status %d: %s", resp.StatusCode, string(body))
+	result := &ai.ProbeResult{Notes: []string{"Ollama " + version.Version}}
+	var names []string
+	for _, m := range tags.Models {
+		names = append(names, m.Name)
+		if m.Name == c.Model || m.Name == c.Model+":latest" {
+			result.Model = m.Name
+		}
+	}
+	if result.Model == "" {
+		available := "none"
+		if len(names) > 0 {
+			available = strings.Join(names, ", ")
+		}
+		return nil, fmt.Errorf("%w: %q has not been pulled (run: ollama pull %s; available: %s)", ai.ErrModelNotFound, c.Model, c.Model, available)
 	}
 
-	var result GenerateResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
+	info, err := c.Show(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result.ContextWindow = info.ContextLength
+	window := c.NumCtx
+	source := "OLLAMA_NUM_CTX"
+	switch {
+	case window > 0:
+	case info.NumCtx > 0:
+		window, source = info.NumCtx, "Modelfile"
+	default:
+		window, source = defaultNumCtx, "Ollama default"
 	}
+	result.Notes = append(result.Notes, fmt.Sprintf("num_ctx %d (%s)", window, source))
 
-	return result.Response, nil
+	switch {
+	case c.Format != FormatSchema:
+		result.Structured = "format " + c.Format
+	case !versionAtLeast(version.Version, 0, 5):
+		result.Structured = "format json (schemas need Ollama 0.5+)"
+	default:
+		result.Structured = "format schema"
+	}
+	return result, nil
+}
+
+// versionAtLeast reports whether a "major.minor.patch" version is at least
+// major.minor; unparsable versions (e.g. dev builds) pass
+func versionAtLeast(version string, major, minor int) bool {
+	parts := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 3)
+	if len(parts) < 2 {
+		return true
+	}
+	gotMajor, err1 := strconv.Atoi(parts[0])
+	gotMinor, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil {
+		return true
+	}
+	return gotMajor > major || (gotMajor == major && gotMinor >= minor)
 }
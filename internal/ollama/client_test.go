@@ -0,0 +1,237 @@
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"ad-necromancer/internal/ai"
+	"ad-necromancer/internal/transport"
+)
+
+// testServer stands in for an Ollama server running llama3 with an 8k
+// context. Each /api/chat request is decoded into requests and answered
+// with handle.
+func testServer(t *testing.T, handle func(w http.ResponseWriter, req ChatRequest)) (*Client, *[]ChatRequest) {
+	t.Helper()
+	var requests []ChatRequest
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/chat", func(w http.ResponseWriter, r *http.Request) {
+		var req ChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		requests = append(requests, req)
+		handle(w, req)
+	})
+	mux.HandleFunc("POST /api/show", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(ShowResponse{
+			Parameters: "num_ctx 8192\nstop \"<|eot_id|>\"",
+			ModelInfo:  map[string]interface{}{"llama.context_length": 8192},
+		})
+	})
+	mux.HandleFunc("GET /api/version", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"version":"0.5.7"}`))
+	})
+	mux.HandleFunc("GET /api/tags", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"models":[{"name":"llama3:latest"},{"name":"qwen2.5:32b"}]}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	tr := transport.New("Ollama", 5*time.Second)
+	tr.HTTPClient.Transport = &http.Transport{}
+	tr.BaseDelay = time.Millisecond
+	return &Client{Endpoint: srv.URL, Model: "llama3", Format: FormatSchema, Transport: tr}, &requests
+}
+
+func respond(w http.ResponseWriter, message Message) {
+	json.NewEncoder(w).Encode(ChatResponse{
+		Model:           "llama3",
+		Message:         message,
+		Done:            true,
+		DoneReason:      "stop",
+		PromptEvalCount: 120,
+		EvalCount:       30,
+	})
+}
+
+func schemaRequest() *ai.ChatRequest {
+	seed := 7
+	return &ai.ChatRequest{
+		Messages: []ai.Message{
+			{Role: ai.RoleSystem, Content: "system"},
+			{Role: ai.RoleUser, Content: "user"},
+		},
+		Options: ai.Options{
+			MaxTokens: 1000,
+			Seed:      &seed,
+			Schema: &ai.Schema{
+				Name:         "findings",
+				Definition:   map[string]interface{}{"type": "object"},
+				Instructions: "Reply with a findings object.",
+			},
+		},
+	}
+}
+
+func TestChatSendsSchemaFormat(t *testing.T) {
+	c, requests := testServer(t, func(w http.ResponseWriter, req ChatRequest) {
+		respond(w, Message{Role: ai.RoleAssistant, Content: "[]"})
+	})
+	c.NumCtx = 4096
+	c.KeepAlive = "-1"
+
+	resp, err := c.Chat(context.Background(), schemaRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := (*requests)[0]
+	if req.Model != "llama3" || req.Stream || len(req.Messages) != 2 || req.Messages[0].Role != ai.RoleSystem {
+		t.Errorf("request = %+v, want the system prompt kept as a message", req)
+	}
+	if req.Options.NumPredict != 1000 || req.Options.NumCtx != 4096 || req.Options.Seed == nil || *req.Options.Seed != 7 {
+		t.Errorf("options = %+v", req.Options)
+	}
+	if req.KeepAlive != float64(-1) {
+		t.Errorf("keep_alive = %#v, want -1 seconds", req.KeepAlive)
+	}
+	if !reflect.DeepEqual(req.Format, map[string]interface{}{"type": "object"}) {
+		t.Errorf("format = %#v, want the schema", req.Format)
+	}
+
+	want := &ai.ChatResponse{
+		Text:         "[]",
+		FinishReason: ai.FinishStop,
+		Model:        "llama3",
+		Usage:        ai.Usage{PromptTokens: 120, CompletionTokens: 30},
+	}
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("response = %+v, want %+v", resp, want)
+	}
+}
+
+func TestChatSchemaFallback(t *testing.T) {
+	c, requests := testServer(t, func(w http.ResponseWriter, req ChatRequest) {
+		if _, ok := req.Format.(map[string]interface{}); ok {
+			http.Error(w, `{"error":"json: cannot unmarshal object into Go struct field ChatRequest.format of type string"}`, http.StatusBadRequest)
+			return
+		}
+		respond(w, Message{Role: ai.RoleAssistant, Content: "[]"})
+	})
+
+	for range 2 {
+		if _, err := c.Chat(context.Background(), schemaRequest()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(*requests) != 3 {
+		t.Fatalf("%d requests, want the schema once then prompt-only twice", len(*requests))
+	}
+	for _, req := range (*requests)[1:] {
+		if req.Format != nil {
+			t.Errorf("prompt-only request still has format %#v", req.Format)
+		}
+		if !strings.HasSuffix(req.Messages[0].Content, "Reply with a findings object.") {
+			t.Errorf("system prompt = %q, want the schema instructions", req.Messages[0].Content)
+		}
+	}
+}
+
+func TestChatFormatJSON(t *testing.T) {
+	c, requests := testServer(t, func(w http.ResponseWriter, req ChatRequest) {
+		respond(w, Message{Role: ai.RoleAssistant, Content: "[]"})
+	})
+	c.Format = FormatJSON
+
+	if _, err := c.Chat(context.Background(), schemaRequest()); err != nil {
+		t.Fatal(err)
+	}
+	req := (*requests)[0]
+	if req.Format != "json" || !strings.HasSuffix(req.Messages[0].Content, "Reply with a findings object.") {
+		t.Errorf("format = %#v, system = %q; want json and the schema instructions", req.Format, req.Messages[0].Content)
+	}
+}
+
+func TestChatMapsToolCalls(t *testing.T) {
+	c, requests := testServer(t, func(w http.ResponseWriter, req ChatRequest) {
+		var call ToolCall
+		call.Function.Name = "lookup"
+		call.Function.Arguments = json.RawMessage(`{"name":"svc_backup"}`)
+		respond(w, Message{Role: ai.RoleAssistant, ToolCalls: []ToolCall{call}})
+	})
+
+	req := &ai.ChatRequest{
+		Messages: []ai.Message{
+			{Role: ai.RoleUser, Content: "Who is svc_sql?"},
+			{Role: ai.RoleAssistant, ToolCalls: []ai.ToolCall{{ID: "call_0", Name: "lookup", Arguments: `not json`}}},
+			{Role: ai.RoleTool, ToolCallID: "call_0", ToolName: "lookup", Content: `{"enabled":true}`},
+		},
+		Tools: []ai.Tool{{Name: "lookup", Parameters: map[string]interface{}{"type": "object"}}},
+	}
+	resp, err := c.Chat(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent := (*requests)[0]
+	if len(sent.Tools) != 1 || sent.Tools[0].Type != "function" || sent.Tools[0].Function.Name != "lookup" {
+		t.Errorf("tools = %+v", sent.Tools)
+	}
+	if args := sent.Messages[1].ToolCalls[0].Function.Arguments; string(args) != "{}" {
+		t.Errorf("malformed arguments sent as %s, want {}", args)
+	}
+	if sent.Messages[2].Role != ai.RoleTool || sent.Messages[2].ToolName != "lookup" {
+		t.Errorf("tool result = %+v", sent.Messages[2])
+	}
+
+	if resp.FinishReason != ai.FinishToolUse || len(resp.ToolCalls) != 1 ||
+		resp.ToolCalls[0].Name != "lookup" || resp.ToolCalls[0].Arguments != `{"name":"svc_backup"}` {
+		t.Errorf("response = %+v", resp)
+	}
+}
+
+func TestChatToolsRejected(t *testing.T) {
+	c, _ := testServer(t, func(w http.ResponseWriter, req ChatRequest) {
+		http.Error(w, `{"error":"registry.ollama.ai/library/llama3:latest does not support tools"}`, http.StatusBadRequest)
+	})
+
+	req := &ai.ChatRequest{
+		Messages: []ai.Message{{Role: ai.RoleUser, Content: "Who is svc_sql?"}},
+		Tools:    []ai.Tool{{Name: "lookup", Parameters: map[string]interface{}{"type": "object"}}},
+	}
+	_, err := c.Chat(context.Background(), req)
+	var rejected *ai.ToolsRejectedError
+	if !errors.As(err, &rejected) {
+		t.Errorf("err = %v, want ToolsRejectedError", err)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	c, _ := testServer(t, nil)
+
+	result, err := c.Probe(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Model != "llama3:latest" || result.ContextWindow != 8192 || result.Structured != "format schema" {
+		t.Errorf("probe = %+v", result)
+	}
+	if !reflect.DeepEqual(result.Notes, []string{"Ollama 0.5.7", "num_ctx 8192 (Modelfile)"}) {
+		t.Errorf("notes = %v", result.Notes)
+	}
+
+	c.Model = "mistral"
+	if _, err := c.Probe(context.Background()); !errors.Is(err, ai.ErrModelNotFound) {
+		t.Errorf("err = %v, want ErrModelNotFound for a model not pulled", err)
+	}
+}
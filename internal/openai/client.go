@@ -1,40 +1,23 @@
 package openai
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
+	"time"
+
+	"ad-necromancer/internal/ai"
+	"ad-necromancer/internal/openaicompat"
 )
 
 const (
-	apiEndpoint  = "https://api.openai.com/v1/chat/completions"
+	apiBaseURL   = "https://api.openai.com/v1"
 	defaultModel = "gpt-4o-mini"
 )
 
+// Client is the OpenAI backend: the generic OpenAI-compatible client pointed
+// at api.openai.com with structured outputs via json_schema
 type Client struct {
-	APIKey string
-	Model  string
-}
-
-type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
-}
-
-type ChatRequest struct {
-	Model       string    `json:"model"`
-	Messages    []Message `json:"messages"`
-	MaxTokens   int       `json:"max_tokens"`
-	Temperature float64   `json:"temperature"`
-}
-
-type ChatResponse struct {
-	Choices []struct {
-		Message Message `json:"message"`
-	} `json:"choices"`
+	*openaicompat.Client
 }
 
 // NewClient creates a new OpenAI client
@@ -49,57 +32,32 @@ func NewClient() (*Client, error) {
 		model = defaultModel
 	}
 
-	return &Client{
-		APIKey: apiKey,
-		Model:  model,
-	}, nil
+	c := openaicompat.New("OpenAI", apiBaseURL, model, 180*time.Second)
+	c.APIKey = apiKey
+	c.MaxCompletionTokens = true // max_tokens is deprecated, and refused by o-series models
+
+	return &Client{Client: c}, nil
 }
 
-// Summon implements the AIClient interface
-func (c *Client) Summon(systemPrompt, userPrompt string) (string, error) {
-	reqBody := ChatRequest{
-		Model: c.Model,
-		Messages: []Message{
-			{Role: "system", Content: systemPrompt},
-			{Role: "user", Content: userPrompt},
+func init() {
+	ai.Register(ai.Backend{
+		Name:        "openai",
+		Description: "OpenAI",
+		ModelEnv:    "OPENAI_MODEL",
+		Config: []ai.ConfigKey{
+			{Env: "OPENAI_API_KEY", Description: "API key", Required: true, Secret: true},
+			{Env: "OPENAI_MODEL", Description: "Model id", Default: defaultModel},
 		},
-		MaxTokens:   8000,
-		Temperature: 0.7,
-	}
-
-	jsonData, err := json.Marshal(reqBody)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	req, err := http.NewRequest("POST", apiEndpoint, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.APIKey)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to call OpenAI API: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("OpenAI API returned status %d: %s", resp.StatusCode, string(body))
-	}
-
-	var result ChatResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
-	}
-
-	if len(result.Choices) == 0 {
-		return "", fmt.Errorf("no response from OpenAI")
-	}
-
-	return result.Choices[0].Message.Content, nil
+		Capabilities: ai.Capabilities{Structured: "json_schema", Seed: true, Tools: true},
+		New: func(cfg ai.Config) (ai.AIClient, error) {
+			c, err := NewClient()
+			if err != nil {
+				return nil, err
+			}
+			if cfg.Model != "" {
+				c.Model = cfg.Model
+			}
+			return c, nil
+		},
+	})
 }
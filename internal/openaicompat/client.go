@@ -0,0 +1,496 @@
+package openaicompat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"ad-necromancer/internal/ai"
+	"ad-necromancer/internal/transport"
+)
+
+const defaultPath = "/chat/completions"
+
+// Structured-output modes for servers with different levels of support
+const (
+	StructuredJSONSchema = "json_schema" // response_format json_schema (OpenAI, vLLM, llama.cpp)
+	StructuredJSONObject = "json_object" // JSON mode without a schema (DeepSeek, LM Studio)
+	StructuredNone       = "none"        // Prompt-only
+)
+
+// Client speaks the OpenAI Chat Completions protocol to any compatible
+// server: OpenAI itself, DeepSeek, vLLM, LM Studio, llama.cpp, ...
+type Client struct {
+	Name       string            // Provider label used in errors
+	BaseURL    string            // e.g. "http://localhost:8000/v1"
+	Path       string            // Appended to BaseURL; default "/chat/completions"
+	Model      string            // Omitted from the request when empty
+	APIKey     string            // Optional; sent as a Bearer token
+	Headers    map[string]string // Extra headers sent with every request
+	Structured string            // One of the Structured* modes
+	Transport  *transport.Client
+
+	// MaxCompletionTokens sends the output limit as max_completion_tokens,
+	// which OpenAI's reasoning models require, instead of max_tokens
+	MaxCompletionTokens bool
+
+	// ClassifyError, if set, may replace a transport error with a
+	// provider-specific one before the schema fallback inspects it
+	ClassifyError func(err error) error
+
+	downgrades atomic.Int32 // response_format rejections seen so far
+
+	completionTokens atomic.Bool // set once the server asked for max_completion_tokens
+	noTemperature    atomic.Bool // set once the model refused a temperature
+}
+
+// structuredLadder orders the modes from strictest to plainest; a server
+// that rejects one is retried with the next
+var structuredLadder = []string{StructuredJSONSchema, StructuredJSONObject, StructuredNone}
+
+type Message struct {
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+}
+
+// Tool declares a function the model may call
+type Tool struct {
+	Type     string       `json:"type"` // Always "function"
+	Function FunctionSpec `json:"function"`
+}
+
+type FunctionSpec struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters"`
+}
+
+// ToolCall is a function call in an assistant message
+type ToolCall struct {
+	ID       string       `json:"id"`
+	Type     string       `json:"type"`
+	Function FunctionCall `json:"function"`
+}
+
+type FunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"` // JSON object encoded as a string
+}
+
+type ChatRequest struct {
+	Model       string    `json:"model,omitempty"`
+	Messages    []Message `json:"messages"`
+	MaxTokens   int       `json:"max_tokens,omitempty"`
+	Temperature *float64  `json:"temperature,omitempty"` // nil for models that only accept their default
+
+	// MaxCompletionTokens replaces MaxTokens for OpenAI's reasoning models
+	MaxCompletionTokens int      `json:"max_completion_tokens,omitempty"`
+	Seed                *int     `json:"seed,omitempty"`
+	Stop                []string `json:"stop,omitempty"`
+	Tools               []Tool   `json:"tools,omitempty"`
+
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+}
+
+// ResponseFormat selects structured outputs
+type ResponseFormat struct {
+	Type       string      `json:"type"`
+	JSONSchema *JSONSchema `json:"json_schema,omitempty"`
+}
+
+// JSONSchema is the json_schema member of response_format
+type JSONSchema struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Schema      map[string]interface{} `json:"schema"`
+	Strict      bool                   `json:"strict"`
+}
+
+type ChatResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message      Message `json:"message"`
+		FinishReason string  `json:"finish_reason"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+// New creates a client for an OpenAI-compatible server at baseURL
+func New(name, baseURL, model string, timeout time.Duration) *Client {
+	return &Client{
+		Name:       name,
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		Path:       defaultPath,
+		Model:      model,
+		Headers:    map[string]string{},
+		Structured: StructuredJSONSchema,
+		Transport:  transport.New(name, timeout),
+	}
+}
+
+// NewClient creates a generic OpenAI-compatible client from the environment:
+//
+//	OPENAI_COMPAT_BASE_URL    required, e.g. http://localhost:8000/v1
+//	OPENAI_COMPAT_MODEL       model id (optional for single-model servers)
+//	OPENAI_COMPAT_API_KEY     optional Bearer token
+//	OPENAI_COMPAT_HEADERS     optional extra headers, "Name=value,Other=value"
+//	OPENAI_COMPAT_PATH        optional path override (default /chat/completions)
+//	OPENAI_COMPAT_STRUCTURED  json_schema (default), json_object or none
+func NewClient() (*Client, error) {
+	baseURL := os.Getenv("OPENAI_COMPAT_BASE_URL")
+	if baseURL == "" {
+		return nil, fmt.Errorf("OPENAI_COMPAT_BASE_URL environment variable not set")
+	}
+	if _, err := url.ParseRequestURI(baseURL); err != nil {
+		return nil, fmt.Errorf("invalid OPENAI_COMPAT_BASE_URL: %w", err)
+	}
+
+	// Self-hosted models can be slow on large prompts
+	c := New("OpenAI-compatible", baseURL, os.Getenv("OPENAI_COMPAT_MODEL"), 10*time.Minute)
+	c.APIKey = os.Getenv("OPENAI_COMPAT_API_KEY")
+
+	if path := os.Getenv("OPENAI_COMPAT_PATH"); path != "" {
+		c.Path = path
+	}
+
+	headers, err := ParseHeaders(os.Getenv("OPENAI_COMPAT_HEADERS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid OPENAI_COMPAT_HEADERS: %w", err)
+	}
+	c.Headers = headers
+
+	switch mode := os.Getenv("OPENAI_COMPAT_STRUCTURED"); mode {
+	case "":
+	case StructuredJSONSchema, StructuredJSONObject, StructuredNone:
+		c.Structured = mode
+	default:
+		return nil, fmt.Errorf("invalid OPENAI_COMPAT_STRUCTURED %q (want json_schema, json_object or none)", mode)
+	}
+
+	return c, nil
+}
+
+// ParseHeaders parses "Name=value,Other=value" into a header map
+func ParseHeaders(spec string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("expected Name=value, got %q", pair)
+		}
+		headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return headers, nil
+}
+
+// Endpoint returns the full chat completions URL
+func (c *Client) Endpoint() string {
+	return c.BaseURL + c.Path
+}
+
+// IsLocal reports whether the server runs on this machine or a private
+// network, i.e. prompts never leave the organisation
+func (c *Client) IsLocal() bool {
+	u, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return false
+	}
+	host := u.Hostname()
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && (ip.IsLoopback() || ip.IsPrivate())
+}
+
+func init() {
+	ai.Register(ai.Backend{
+		Name:        "openai-compatible",
+		Description: "Generic OpenAI-compatible server (vLLM, LM Studio, llama.cpp, ...)",
+		ModelEnv:    "OPENAI_COMPAT_MODEL",
+		Config: []ai.ConfigKey{
+			{Env: "OPENAI_COMPAT_BASE_URL", Description: "Server base URL, e.g. http://localhost:8000/v1", Required: true},
+			{Env: "OPENAI_COMPAT_MODEL", Description: "Model id (optional for single-model servers)"},
+			{Env: "OPENAI_COMPAT_API_KEY", Description: "Bearer token", Secret: true},
+			{Env: "OPENAI_COMPAT_HEADERS", Description: "Extra headers, Name=value,Other=value", Secret: true},
+			{Env: "OPENAI_COMPAT_PATH", Description: "Path override", Default: defaultPath},
+			{Env: "OPENAI_COMPAT_STRUCTURED", Description: "json_schema, json_object or none", Default: StructuredJSONSchema},
+		},
+		Capabilities: ai.Capabilities{AutoLocal: true, Structured: "json_schema / json_object", Seed: true, Tools: true},
+		New: func(cfg ai.Config) (ai.AIClient, error) {
+			c, err := NewClient()
+			if err != nil {
+				return nil, err
+			}
+			if cfg.Model != "" {
+				c.Model = cfg.Model
+			}
+			return c, nil
+		},
+	})
+}
+
+// Summon implements the AIClient interface
+func (c *Client) Summon(systemPrompt, userPrompt string) (string, error) {
+	return ai.Summon(c, systemPrompt, userPrompt)
+}
+
+// Chat implements the AIClient interface. A schema is sent according to the
+// Structured mode; a server that rejects json_schema is retried with
+// json_object, and one that rejects that too with prompt-only output. The
+// downgrade sticks for the rest of the run.
+func (c *Client) Chat(ctx context.Context, req *ai.ChatRequest) (*ai.ChatResponse, error) {
+	if req.Options.Schema == nil {
+		return c.chat(ctx, req, StructuredNone)
+	}
+	for {
+		step := c.downgrades.Load()
+		mode := c.structured(step)
+		resp, err := c.chat(ctx, req, mode)
+		if mode == StructuredNone || !ai.IsSchemaRejected(err) {
+			return resp, err
+		}
+		// Concurrent callers may hit the same rejection; step down once
+		c.downgrades.CompareAndSwap(step, step+1)
+	}
+}
+
+// structured returns the mode in effect after the given number of
+// response_format rejections
+func (c *Client) structured(downgrades int32) string {
+	start := len(structuredLadder) - 1
+	for i, mode := range structuredLadder {
+		if mode == c.Structured {
+			start = i
+		}
+	}
+	return structuredLadder[min(start+int(downgrades), len(structuredLadder)-1)]
+}
+
+// schemaErrorTerms mark a 400 as a refusal of response_format, as worded
+// by OpenAI, Azure, vLLM, LM Studio and llama.cpp
+var schemaErrorTerms = []string{"response_format", "json_schema", "json_object", "guided_json", "schema"}
+
+func (c *Client) chat(ctx context.Context, req *ai.ChatRequest, mode string) (*ai.ChatResponse, error) {
+	schema := req.Options.Schema
+	// JSON mode guarantees an object, not its shape
+	if mode != StructuredJSONSchema {
+		req = ai.PromptOnly(req)
+	}
+	messages := make([]Message, 0, len(req.Messages))
+	for _, m := range req.Messages {
+		msg := Message{Role: m.Role, Content: m.Content, ToolCallID: m.ToolCallID}
+		for _, call := range m.ToolCalls {
+			msg.ToolCalls = append(msg.ToolCalls, ToolCall{
+				ID:       call.ID,
+				Type:     "function",
+				Function: FunctionCall{Name: call.Name, Arguments: call.Arguments},
+			})
+		}
+		messages = append(messages, msg)
+	}
+
+	reqBody := ChatRequest{
+		Model:    c.Model,
+		Messages: messages,
+		Seed:     req.Options.Seed,
+		Stop:     req.Options.Stop,
+	}
+	if c.MaxCompletionTokens || c.completionTokens.Load() {
+		reqBody.MaxCompletionTokens = req.Options.MaxTokensOrDefault()
+	} else {
+		reqBody.MaxTokens = req.Options.MaxTokensOrDefault()
+	}
+	if !c.noTemperature.Load() {
+		temperature := req.Options.TemperatureOrDefault()
+		reqBody.Temperature = &temperature
+	}
+	for _, tool := range req.Tools {
+		reqBody.Tools = append(reqBody.Tools, Tool{
+			Type:     "function",
+			Function: FunctionSpec{Name: tool.Name, Description: tool.Description, Parameters: tool.Parameters},
+		})
+	}
+	if schema != nil {
+		switch mode {
+		case StructuredNone:
+		case StructuredJSONObject:
+			reqBody.ResponseFormat = &ResponseFormat{Type: "json_object"}
+		default:
+			// Strict mode would require every optional field to be listed as
+			// required, so we ask for best-effort adherence instead
+			reqBody.ResponseFormat = &ResponseFormat{
+				Type: "json_schema",
+				JSONSchema: &JSONSchema{
+					Name:        schema.Name,
+					Description: schema.Description,
+					Schema:      schema.Definition,
+					Strict:      false,
+				},
+			}
+		}
+	}
+
+	var result ChatResponse
+	err := c.Transport.PostJSON(ctx, c.Endpoint(), c.headers(), reqBody, &result)
+	for err != nil && c.adapt(err, &reqBody) {
+		err = c.Transport.PostJSON(ctx, c.Endpoint(), c.headers(), reqBody, &result)
+	}
+	if err != nil {
+		if c.ClassifyError != nil {
+			if classified := c.ClassifyError(err); classified != nil {
+				return nil, classified
+			}
+		}
+		if reqBody.ResponseFormat != nil && transport.IsBadRequestAbout(err, schemaErrorTerms...) {
+			return nil, &ai.SchemaRejectedError{Err: err}
+		}
+		if len(req.Tools) > 0 && transport.IsStatus(err, http.StatusBadRequest) {
+			return nil, &ai.ToolsRejectedError{Err: err}
+		}
+		return nil, err
+	}
+
+	if len(result.Choices) == 0 {
+		return nil, fmt.Errorf("no response from %s", c.Name)
+	}
+
+	choice := result.Choices[0]
+	reply := &ai.ChatResponse{
+		Text:         choice.Message.Content,
+		FinishReason: choice.FinishReason,
+		Model:        result.Model,
+		Usage: ai.Usage{
+			PromptTokens:     result.Usage.PromptTokens,
+			CompletionTokens: result.Usage.CompletionTokens,
+		},
+	}
+	for i, call := range choice.Message.ToolCalls {
+		reply.ToolCalls = append(reply.ToolCalls, ai.ToolCall{
+			ID:        ai.ToolCallID(call.ID, i),
+			Name:      call.Function.Name,
+			Arguments: call.Function.Arguments,
+		})
+	}
+	if len(reply.ToolCalls) > 0 {
+		reply.FinishReason = ai.FinishToolUse // "tool_calls", or "stop" on some servers
+	}
+	return reply, nil
+}
+
+// adapt adjusts body to a 400 refusing one of its parameters, as OpenAI's
+// reasoning models refuse max_tokens ("use 'max_completion_tokens'
+// instead") and any temperature but their default. The adjustment sticks
+// for the rest of the run; adapt reports whether body changed, so each
+// parameter is retried once.
+func (c *Client) adapt(err error, body *ChatRequest) bool {
+	switch {
+	case body.MaxTokens > 0 && transport.IsBadRequestAbout(err, "max_completion_tokens"):
+		c.completionTokens.Store(true)
+		body.MaxCompletionTokens, body.MaxTokens = body.MaxTokens, 0
+		return true
+	case body.Temperature != nil && transport.IsBadRequestAbout(err, "'temperature'"):
+		c.noTemperature.Store(true)
+		body.Temperature = nil
+		return true
+	}
+	return false
+}
+
+// SupportsTools implements ai.ToolUser. Servers whose model cannot call
+// functions reject the request, which ends the tool-use loop.
+func (c *Client) SupportsTools() bool {
+	return true
+}
+
+// headers returns the extra headers plus the Bearer token
+func (c *Client) headers() map[string]string {
+	headers := make(map[string]string, len(c.Headers)+1)
+	for name, value := range c.Headers {
+		headers[name] = value
+	}
+	if c.APIKey != "" {
+		headers["Authorization"] = "Bearer " + c.APIKey
+	}
+	return headers
+}
+
+// ModelList is the GET /models response
+type ModelList struct {
+	Data []struct {
+		ID string `json:"id"`
+		// Context length, as reported by vLLM, LM Studio and others
+		MaxModelLen   int `json:"max_model_len"`
+		ContextLength int `json:"context_length"`
+	} `json:"data"`
+}
+
+// Probe implements ai.Prober by listing the server's models, which needs
+// valid credentials but no completion. Servers without GET /models are
+// probed with a one-token completion.
+func (c *Client) Probe(ctx context.Context) (*ai.ProbeResult, error) {
+	body, err := c.Transport.Do(ctx, http.MethodGet, c.BaseURL+"/models", c.headers(), nil)
+	if transport.IsStatus(err, http.StatusNotFound) || transport.IsStatus(err, http.StatusMethodNotAllowed) ||
+		transport.IsStatus(err, http.StatusNotImplemented) {
+		result, err := ai.ProbeChat(ctx, c)
+		if result != nil {
+			result.Structured = c.structured(c.downgrades.Load())
+		}
+		return result, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list ModelList
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, fmt.Errorf("failed to decode %s model list: %w", c.Name, err)
+	}
+
+	result := &ai.ProbeResult{Structured: c.structured(c.downgrades.Load())}
+	ids := make([]string, 0, len(list.Data))
+	for _, m := range list.Data {
+		ids = append(ids, m.ID)
+		if m.ID == c.Model || (c.Model == "" && len(list.Data) == 1) {
+			result.Model = m.ID
+			result.ContextWindow = max(m.MaxModelLen, m.ContextLength)
+		}
+	}
+	if result.Model == "" {
+		if c.Model == "" {
+			return nil, fmt.Errorf("no model configured and the server offers %d: %s", len(ids), summarizeIDs(ids))
+		}
+		return nil, fmt.Errorf("%w: %s does not serve %q (available: %s)", ai.ErrModelNotFound, c.Name, c.Model, summarizeIDs(ids))
+	}
+	return result, nil
+}
+
+// summarizeIDs lists up to ten model ids
+func summarizeIDs(ids []string) string {
+	const limit = 10
+	if len(ids) == 0 {
+		return "none"
+	}
+	sort.Strings(ids)
+	if len(ids) > limit {
+		return strings.Join(ids[:limit], ", ") + fmt.Sprintf(", ... (%d more)", len(ids)-limit)
+	}
+	return strings.Join(ids, ", ")
+}
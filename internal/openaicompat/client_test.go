@@ -0,0 +1,447 @@
+package openaicompat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ad-necromancer/internal/ai"
+)
+
+// testServer records the chat requests it receives and answers each with
+// handle; a nil handle replies "[]"
+type testServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []ChatRequest
+	handle   func(w http.ResponseWriter, r *http.Request, req ChatRequest)
+}
+
+func newTestServer(t *testing.T, mux *http.ServeMux) *testServer {
+	s := &testServer{}
+	if mux == nil {
+		mux = http.NewServeMux()
+	}
+	mux.HandleFunc("POST /", func(w http.ResponseWriter, r *http.Request) {
+		var req ChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, req)
+		s.mu.Unlock()
+		if s.handle != nil {
+			s.handle(w, r, req)
+			return
+		}
+		reply(w, "[]")
+	})
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func reply(w http.ResponseWriter, content string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"model":   "test-model",
+		"choices": []map[string]interface{}{{"message": map[string]string{"role": "assistant", "content": content}, "finish_reason": "stop"}},
+		"usage":   map[string]int{"prompt_tokens": 10, "completion_tokens": 1},
+	})
+}
+
+func testClient(baseURL string) *Client {
+	c := New("Test", baseURL, "test-model", 5*time.Second)
+	c.Transport.HTTPClient.Transport = &http.Transport{}
+	c.Transport.BaseDelay = time.Millisecond
+	c.Transport.MaxDelay = 10 * time.Millisecond
+	return c
+}
+
+func schemaRequest() *ai.ChatRequest {
+	return &ai.ChatRequest{
+		Messages: []ai.Message{
+			{Role: ai.RoleSystem, Content: "system"},
+			{Role: ai.RoleUser, Content: "user"},
+		},
+		Options: ai.Options{Schema: &ai.Schema{
+			Name:         "findings",
+			Definition:   map[string]interface{}{"type": "object"},
+			Instructions: "Reply with a findings object.",
+		}},
+	}
+}
+
+func TestNewClientPathAndHeaders(t *testing.T) {
+	var got http.Header
+	var path string
+	srv := newTestServer(t, nil)
+	srv.handle = func(w http.ResponseWriter, r *http.Request, req ChatRequest) {
+		got, path = r.Header.Clone(), r.URL.Path
+		reply(w, "OK")
+	}
+
+	t.Setenv("OPENAI_COMPAT_BASE_URL", srv.URL+"/v1/")
+	t.Setenv("OPENAI_COMPAT_MODEL", "test-model")
+	t.Setenv("OPENAI_COMPAT_API_KEY", "secret")
+	t.Setenv("OPENAI_COMPAT_HEADERS", "X-Team = red, X-Query=a=b")
+	t.Setenv("OPENAI_COMPAT_PATH", "/openai/chat")
+	t.Setenv("OPENAI_COMPAT_STRUCTURED", "")
+	c, err := NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Transport.HTTPClient.Transport = &http.Transport{}
+
+	if _, err := c.Chat(context.Background(), &ai.ChatRequest{Messages: []ai.Message{{Role: ai.RoleUser, Content: "hi"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/v1/openai/chat" {
+		t.Errorf("path = %q, want /v1/openai/chat", path)
+	}
+	for name, want := range map[string]string{"Authorization": "Bearer secret", "X-Team": "red", "X-Query": "a=b"} {
+		if got.Get(name) != want {
+			t.Errorf("%s = %q, want %q", name, got.Get(name), want)
+		}
+	}
+	if model := srv.requests[0].Model; model != "test-model" {
+		t.Errorf("model = %q, want test-model", model)
+	}
+}
+
+func TestNewClientRejectsBadConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+	}{
+		{"missing base URL", map[string]string{"OPENAI_COMPAT_BASE_URL": ""}},
+		{"relative base URL", map[string]string{"OPENAI_COMPAT_BASE_URL": "v1/chat"}},
+		{"malformed headers", map[string]string{"OPENAI_COMPAT_HEADERS": "X-Team"}},
+		{"unknown structured mode", map[string]string{"OPENAI_COMPAT_STRUCTURED": "grammar"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OPENAI_COMPAT_BASE_URL", "http://localhost:8000/v1")
+			t.Setenv("OPENAI_COMPAT_HEADERS", "")
+			t.Setenv("OPENAI_COMPAT_STRUCTURED", "")
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			if _, err := NewClient(); err == nil {
+				t.Error("NewClient succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{spec: "", want: map[string]string{}},
+		{spec: "A=1,B=2", want: map[string]string{"A": "1", "B": "2"}},
+		{spec: " A = 1 , ,B=", want: map[string]string{"A": "1", "B": ""}},
+		{spec: "Cookie=a=b", want: map[string]string{"Cookie": "a=b"}},
+		{spec: "A", wantErr: true},
+		{spec: " =1", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseHeaders(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseHeaders(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseHeaders(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestIsLocal(t *testing.T) {
+	tests := []struct {
+		baseURL string
+		want    bool
+	}{
+		{"http://localhost:8000/v1", true},
+		{"http://127.0.0.1:1234/v1", true},
+		{"http://[::1]:8080/v1", true},
+		{"http://10.1.2.3/v1", true},
+		{"http://172.16.0.5:8000/v1", true},
+		{"http://192.168.1.20:11434/v1", true},
+		{"http://[fd00::1]/v1", true},
+		{"https://api.openai.com/v1", false},
+		{"https://8.8.8.8/v1", false},
+		{"http://172.32.0.1/v1", false},
+		{"http://gpu-box.corp/v1", false},
+	}
+	for _, tt := range tests {
+		c := New("Test", tt.baseURL, "", time.Second)
+		if got := c.IsLocal(); got != tt.want {
+			t.Errorf("IsLocal(%s) = %v, want %v", tt.baseURL, got, tt.want)
+		}
+	}
+}
+
+func TestProbeListsModels(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/models", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"data":[{"id":"other"},{"id":"test-model","max_model_len":32768}]}`))
+	})
+	srv := newTestServer(t, mux)
+
+	c := testClient(srv.URL + "/v1")
+	c.APIKey = "secret"
+	result, err := c.Probe(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Model != "test-model" || result.ContextWindow != 32768 || result.Structured != StructuredJSONSchema {
+		t.Errorf("Probe = %+v", result)
+	}
+	if len(srv.requests) != 0 {
+		t.Errorf("Probe sent %d completion(s), want none", len(srv.requests))
+	}
+
+	c.Model = "missing"
+	if _, err := c.Probe(context.Background()); !errors.Is(err, ai.ErrModelNotFound) {
+		t.Errorf("Probe for an unserved model: err = %v, want ErrModelNotFound", err)
+	}
+
+	c.APIKey = "wrong"
+	if _, err := c.Probe(context.Background()); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Probe with bad credentials: err = %v, want a 401", err)
+	}
+}
+
+func TestProbeFallsBackToCompletion(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/models", http.NotFound)
+	srv := newTestServer(t, mux)
+
+	c := testClient(srv.URL + "/v1")
+	result, err := c.Probe(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Model != "test-model" {
+		t.Errorf("Probe model = %q, want test-model", result.Model)
+	}
+	if len(srv.requests) != 1 || srv.requests[0].MaxTokens != 1 {
+		t.Errorf("Probe sent %+v, want one 1-token completion", srv.requests)
+	}
+}
+
+func TestChatStructuredFallback(t *testing.T) {
+	srv := newTestServer(t, nil)
+	srv.handle = func(w http.ResponseWriter, r *http.Request, req ChatRequest) {
+		if req.ResponseFormat != nil {
+			http.Error(w, `{"error":{"message":"response_format type `+req.ResponseFormat.Type+` is not supported"}}`, http.StatusBadRequest)
+			return
+		}
+		reply(w, `{"findings":[]}`)
+	}
+
+	c := testClient(srv.URL + "/v1")
+	for range 2 {
+		resp, err := c.Chat(context.Background(), schemaRequest())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Text != `{"findings":[]}` {
+			t.Errorf("Text = %q", resp.Text)
+		}
+	}
+
+	// json_schema, json_object, none; then none straight away
+	var formats []string
+	for _, req := range srv.requests {
+		format := "none"
+		if req.ResponseFormat != nil {
+			format = req.ResponseFormat.Type
+		}
+		formats = append(formats, format)
+	}
+	if want := []string{"json_schema", "json_object", "none", "none"}; !reflect.DeepEqual(formats, want) {
+		t.Errorf("response formats = %v, want %v", formats, want)
+	}
+
+	// Only the schema-less modes describe the envelope in the prompt
+	for i, req := range srv.requests {
+		described := strings.Contains(req.Messages[0].Content, "Reply with a findings object.")
+		if described != (i > 0) {
+			t.Errorf("request %d (%s): instructions in system prompt = %v", i, formats[i], described)
+		}
+	}
+}
+
+func TestChatStopsAtJSONObject(t *testing.T) {
+	srv := newTestServer(t, nil)
+	srv.handle = func(w http.ResponseWriter, r *http.Request, req ChatRequest) {
+		if req.ResponseFormat != nil && req.ResponseFormat.Type == "json_schema" {
+			http.Error(w, `{"error":"json_schema is not supported"}`, http.StatusBadRequest)
+			return
+		}
+		reply(w, `{"findings":[]}`)
+	}
+
+	c := testClient(srv.URL + "/v1")
+	c.Chat(context.Background(), schemaRequest())
+	c.Chat(context.Background(), schemaRequest())
+
+	last := srv.requests[len(srv.requests)-1]
+	if len(srv.requests) != 3 || last.ResponseFormat == nil || last.ResponseFormat.Type != "json_object" {
+		t.Errorf("sent %d request(s), last format %+v; want 3 ending in json_object", len(srv.requests), last.ResponseFormat)
+	}
+}
+
+func TestChatKeepsSchemaOnOtherBadRequests(t *testing.T) {
+	srv := newTestServer(t, nil)
+	srv.handle = func(w http.ResponseWriter, r *http.Request, req ChatRequest) {
+		http.Error(w, `{"error":"maximum context length is 8192 tokens"}`, http.StatusBadRequest)
+	}
+
+	c := testClient(srv.URL + "/v1")
+	if _, err := c.Chat(context.Background(), schemaRequest()); err == nil {
+		t.Fatal("Chat succeeded, want the 400")
+	}
+	if len(srv.requests) != 1 {
+		t.Errorf("sent %d request(s), want 1", len(srv.requests))
+	}
+	if mode := c.structured(c.downgrades.Load()); mode != StructuredJSONSchema {
+		t.Errorf("mode after an unrelated 400 = %s, want json_schema", mode)
+	}
+}
+
+func TestChatAdaptsToReasoningModels(t *testing.T) {
+	srv := newTestServer(t, nil)
+	srv.handle = func(w http.ResponseWriter, r *http.Request, req ChatRequest) {
+		switch {
+		case req.MaxTokens > 0:
+			http.Error(w, `{"error":{"message":"Unsupported parameter: 'max_tokens' is not supported with this model. Use 'max_completion_tokens' instead.","type":"invalid_request_error","param":"max_tokens","code":"unsupported_parameter"}}`, http.StatusBadRequest)
+		case req.Temperature != nil:
+			http.Error(w, `{"error":{"message":"Unsupported value: 'temperature' does not support 0.2 with this model. Only the default (1) value is supported.","type":"invalid_request_error","param":"temperature","code":"unsupported_value"}}`, http.StatusBadRequest)
+		default:
+			reply(w, `{"findings":[]}`)
+		}
+	}
+
+	c := testClient(srv.URL + "/v1")
+	if _, err := c.Chat(context.Background(), schemaRequest()); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.requests) != 3 {
+		t.Fatalf("sent %d request(s), want one per refused parameter and the answered one", len(srv.requests))
+	}
+	if last := srv.requests[2]; last.MaxCompletionTokens != ai.DefaultMaxTokens || last.ResponseFormat == nil {
+		t.Errorf("last request = %+v, want max_completion_tokens and the schema kept", last)
+	}
+
+	// The adjustments stick for the rest of the run
+	if _, err := c.Chat(context.Background(), schemaRequest()); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.requests) != 4 {
+		t.Errorf("sent %d request(s), want the second call answered at once", len(srv.requests))
+	}
+}
+
+func TestChatMaxCompletionTokens(t *testing.T) {
+	srv := newTestServer(t, nil)
+	c := testClient(srv.URL + "/v1")
+	c.MaxCompletionTokens = true
+
+	if _, err := c.Chat(context.Background(), schemaRequest()); err != nil {
+		t.Fatal(err)
+	}
+	if req := srv.requests[0]; req.MaxTokens != 0 || req.MaxCompletionTokens != ai.DefaultMaxTokens || req.Temperature == nil {
+		t.Errorf("request = %+v, want max_completion_tokens only", req)
+	}
+}
+
+func TestChatMapsToolCalls(t *testing.T) {
+	srv := newTestServer(t, nil)
+	srv.handle = func(w http.ResponseWriter, r *http.Request, req ChatRequest) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"model": "test-model",
+			"choices": []map[string]interface{}{{
+				"message": map[string]interface{}{
+					"role": "assistant",
+					"tool_calls": []map[string]interface{}{{
+						"id": "call_9", "type": "function",
+						"function": map[string]string{"name": "lookup", "arguments": `{"name":"svc_backup"}`},
+					}},
+				},
+				"finish_reason": "stop",
+			}},
+			"usage": map[string]int{"prompt_tokens": 120, "completion_tokens": 30},
+		})
+	}
+
+	seed := 7
+	req := &ai.ChatRequest{
+		Messages: []ai.Message{
+			{Role: ai.RoleUser, Content: "Who is svc_sql?"},
+			{Role: ai.RoleAssistant, ToolCalls: []ai.ToolCall{{ID: "call_0", Name: "lookup", Arguments: `{"name":"svc_sql"}`}}},
+			{Role: ai.RoleTool, ToolCallID: "call_0", ToolName: "lookup", Content: `{"enabled":true}`},
+		},
+		Tools:   []ai.Tool{{Name: "lookup", Description: "Look up a principal", Parameters: map[string]interface{}{"type": "object"}}},
+		Options: ai.Options{Seed: &seed, Stop: []string{"END"}},
+	}
+	resp, err := testClient(srv.URL+"/v1").Chat(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent := srv.requests[0]
+	if sent.Model != "test-model" || sent.Seed == nil || *sent.Seed != 7 || !reflect.DeepEqual(sent.Stop, []string{"END"}) || sent.ResponseFormat != nil {
+		t.Errorf("request = %+v", sent)
+	}
+	if len(sent.Tools) != 1 || sent.Tools[0].Type != "function" || sent.Tools[0].Function.Name != "lookup" {
+		t.Errorf("tools = %+v", sent.Tools)
+	}
+	call := sent.Messages[1].ToolCalls
+	if len(call) != 1 || call[0].ID != "call_0" || call[0].Function.Arguments != `{"name":"svc_sql"}` {
+		t.Errorf("assistant tool calls = %+v", call)
+	}
+	if sent.Messages[2].Role != ai.RoleTool || sent.Messages[2].ToolCallID != "call_0" {
+		t.Errorf("tool result = %+v", sent.Messages[2])
+	}
+
+	want := &ai.ChatResponse{
+		FinishReason: ai.FinishToolUse,
+		Model:        "test-model",
+		ToolCalls:    []ai.ToolCall{{ID: "call_9", Name: "lookup", Arguments: `{"name":"svc_backup"}`}},
+		Usage:        ai.Usage{PromptTokens: 120, CompletionTokens: 30},
+	}
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("response = %+v, want %+v", resp, want)
+	}
+}
+
+func TestChatStructuredNoneSkipsResponseFormat(t *testing.T) {
+	srv := newTestServer(t, nil)
+	c := testClient(srv.URL + "/v1")
+	c.Structured = StructuredNone
+	if _, err := c.Chat(context.Background(), schemaRequest()); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.requests) != 1 || srv.requests[0].ResponseFormat != nil {
+		t.Errorf("requests = %+v, want one without response_format", srv.requests)
+	}
+}
@@ -0,0 +1,101 @@
+package pricing
+
+import (
+	"sort"
+	"strings"
+
+	"ad-necromancer/internal/ai"
+)
+
+// Price is what a model costs in USD per million tokens
+type Price struct {
+	Input  float64 `yaml:"input" json:"input"`   // Prompt tokens
+	Output float64 `yaml:"output" json:"output"` // Completion tokens
+}
+
+// Cost returns the USD cost of usage at this price
+func (p Price) Cost(u ai.Usage) float64 {
+	return (float64(u.PromptTokens)*p.Input + float64(u.CompletionTokens)*p.Output) / 1e6
+}
+
+// Table maps model ids to prices. A key also matches dated or suffixed
+// variants, e.g. "gpt-4o" matches "gpt-4o-2024-08-06"; the longest key wins.
+type Table map[string]Price
+
+// Default returns list prices at the time of writing. Providers change them;
+// override entries with the config file's prices section.
+func Default() Table {
+	return Table{
+		// DeepSeek
+		"deepseek-chat":     {Input: 0.27, Output: 1.10},
+		"deepseek-reasoner": {Input: 0.55, Output: 2.19},
+
+		// OpenAI (also used for Azure OpenAI deployments of the same model)
+		"gpt-4o":       {Input: 2.50, Output: 10.00},
+		"gpt-4o-mini":  {Input: 0.15, Output: 0.60},
+		"gpt-4.1":      {Input: 2.00, Output: 8.00},
+		"gpt-4.1-mini": {Input: 0.40, Output: 1.60},
+		"gpt-4.1-nano": {Input: 0.10, Output: 0.40},
+		"gpt-4-turbo":  {Input: 10.00, Output: 30.00},
+		"o1":           {Input: 15.00, Output: 60.00},
+		"o3":           {Input: 2.00, Output: 8.00},
+		"o3-mini":      {Input: 1.10, Output: 4.40},
+		"o4-mini":      {Input: 1.10, Output: 4.40},
+
+		// Anthropic
+		"claude-3-haiku":    {Input: 0.25, Output: 1.25},
+		"claude-3-5-haiku":  {Input: 0.80, Output: 4.00},
+		"claude-3-5-sonnet": {Input: 3.00, Output: 15.00},
+		"claude-3-7-sonnet": {Input: 3.00, Output: 15.00},
+		"claude-sonnet-4":   {Input: 3.00, Output: 15.00},
+		"claude-3-opus":     {Input: 15.00, Output: 75.00},
+		"claude-opus-4":     {Input: 15.00, Output: 75.00},
+
+		// Google
+		"gemini-1.5-flash": {Input: 0.075, Output: 0.30},
+		"gemini-1.5-pro":   {Input: 1.25, Output: 5.00},
+		"gemini-2.0-flash": {Input: 0.10, Output: 0.40},
+		"gemini-2.5-flash": {Input: 0.30, Output: 2.50},
+		"gemini-2.5-pro":   {Input: 1.25, Output: 10.00},
+	}
+}
+
+// Merge returns a copy of t with the entries of overrides added or replaced
+func (t Table) Merge(overrides Table) Table {
+	merged := make(Table, len(t)+len(overrides))
+	for model, price := range t {
+		merged[model] = price
+	}
+	for model, price := range overrides {
+		merged[strings.ToLower(model)] = price
+	}
+	return merged
+}
+
+// Lookup returns the price for model, matching the exact id first and then
+// the longest key the id starts with (followed by "-", "@" or ":")
+func (t Table) Lookup(model string) (Price, bool) {
+	model = strings.ToLower(strings.TrimSpace(model))
+	if model == "" {
+		return Price{}, false
+	}
+	// Gemini reports "models/gemini-1.5-flash"; Vertex adds "publishers/..."
+	if i := strings.LastIndex(model, "/"); i >= 0 {
+		model = model[i+1:]
+	}
+	if p, ok := t[model]; ok {
+		return p, true
+	}
+
+	keys := make([]string, 0, len(t))
+	for key := range t {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return len(keys[i]) > len(keys[j]) })
+	for _, key := range keys {
+		if strings.HasPrefix(model, key) && strings.ContainsRune("-@:", rune(model[len(key)])) {
+			return t[key], true
+		}
+	}
+	return Price{}, false
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -27,13 +28,9 @@ func (t *Tokenizer) SaveMapping(runID string) error {
 		return fmt.Errorf("failed to create mapping directory: %w", err)
 	}
 
-	// Create .gitignore in .necromancer directory
-	gitignorePath := filepath.Join(".necromancer", ".gitignore")
-	if _, err := os.Stat(gitignorePath); os.IsNotExist(err) {
-		gitignoreContent := "# Ignore all mapping files - they contain sensitive data\nmappings/\n"
-		if err := os.WriteFile(gitignorePath, []byte(gitignoreContent), 0600); err != nil {
-			return fmt.Errorf("failed to create .gitignore: %w", err)
-		}
+	// Keep mapping files out of version control
+	if err := EnsureIgnored("mappings/"); err != nil {
+		return err
 	}
 
 	// Create mapping file
@@ -88,12 +85,86 @@ func (t *Tokenizer) LoadMapping(runID string) error {
 	return nil
 }
 
+// SaveSalt keeps the tokenizer salt of run runID with the mapping files,
+// so a recorded run can be replayed with the same tokens without the
+// salt travelling with its transcript
+func SaveSalt(runID, salt string) error {
+	mappingDir := filepath.Join(".necromancer", "mappings")
+	if err := os.MkdirAll(mappingDir, 0700); err != nil {
+		return fmt.Errorf("failed to create mapping directory: %w", err)
+	}
+	if err := EnsureIgnored("mappings/"); err != nil {
+		return err
+	}
+	filename := filepath.Join(mappingDir, fmt.Sprintf("run_%s.salt", runID))
+	if err := os.WriteFile(filename, []byte(salt), 0600); err != nil {
+		return fmt.Errorf("failed to write salt: %w", err)
+	}
+	return nil
+}
+
+// LoadSalt returns the tokenizer salt of run runID, from its salt file or
+// its mapping file
+func LoadSalt(runID string) (string, error) {
+	mappingDir := filepath.Join(".necromancer", "mappings")
+	if salt, err := os.ReadFile(filepath.Join(mappingDir, fmt.Sprintf("run_%s.salt", runID))); err == nil {
+		return string(salt), nil
+	}
+	data, err := os.ReadFile(filepath.Join(mappingDir, fmt.Sprintf("run_%s.json", runID)))
+	if err != nil {
+		return "", fmt.Errorf("no salt or mapping for run %s in %s", runID, mappingDir)
+	}
+	var mappingFile MappingFile
+	if err := json.Unmarshal(data, &mappingFile); err != nil || mappingFile.Salt == "" {
+		return "", fmt.Errorf("mapping of run %s holds no salt", runID)
+	}
+	return mappingFile.Salt, nil
+}
+
 // DeleteMapping removes a mapping file
 func DeleteMapping(runID string) error {
 	filename := filepath.Join(".necromancer", "mappings", fmt.Sprintf("run_%s.json", runID))
 	return os.Remove(filename)
 }
 
+// EnsureIgnored adds entry to .necromancer/.gitignore, creating the file
+// if needed. Everything under .necromancer may hold sensitive data.
+func EnsureIgnored(entry string) error {
+	path := filepath.Join(".necromancer", ".gitignore")
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		if err := os.MkdirAll(".necromancer", 0700); err != nil {
+			return fmt.Errorf("failed to create .necromancer directory: %w", err)
+		}
+		content := "# Ignore mappings, transcripts and caches - they contain sensitive data\n" + entry + "\n"
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			return fmt.Errorf("failed to create .gitignore: %w", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read .gitignore: %w", err)
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == entry {
+			return nil
+		}
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to update .gitignore: %w", err)
+	}
+	defer f.Close()
+	if len(data) > 0 && !strings.HasSuffix(string(data), "\n") {
+		fmt.Fprintln(f)
+	}
+	if _, err := fmt.Fprintln(f, entry); err != nil {
+		return fmt.Errorf("failed to update .gitignore: %w", err)
+	}
+	return nil
+}
+
 // GenerateRunID creates a unique run identifier
 func GenerateRunID() string {
 	return time.Now().Format("20060102_150405")
@@ -1,27 +1,45 @@
 package privacy
 
 import (
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
-	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 )
 
-// Tokenizer handles deterministic tokenization of sensitive AD data
+// tokenWidth is the number of hex characters of a token's hash: 48 bits,
+// so a million values share a token with a probability under 0.2%
+const tokenWidth = 12
+
+// Tokenizer handles deterministic tokenization of sensitive AD data. It is
+// safe for concurrent use: analysis workers share one tokenizer, so every
+// real value maps to the same token across all prompts of a run.
 type Tokenizer struct {
 	mapping map[string]string // real → token
 	reverse map[string]string // token → real
-	salt    string            // per-run salt for determinism
+	salt    string            // per-run salt, or per-installation on cached runs
 	mu      sync.RWMutex      // thread-safe access
 }
 
 // NewTokenizer creates a new tokenizer with a random salt
 func NewTokenizer() *Tokenizer {
-	// Generate salt from current timestamp for determinism within a run
-	salt := fmt.Sprintf("%d", getCurrentTimestamp())
+	return NewTokenizerWithSalt(NewSalt())
+}
+
+// NewSalt returns a random tokenizer salt
+func NewSalt() string {
+	b := make([]byte, 16)
+	rand.Read(b) // never fails
+	return hex.EncodeToString(b)
+}
 
+// NewTokenizerWithSalt creates a tokenizer that reproduces the tokens of an
+// earlier run, e.g. to replay a recorded transcript or to hit the response
+// cache
+func NewTokenizerWithSalt(salt string) *Tokenizer {
 	return &Tokenizer{
 		mapping: make(map[string]string),
 		reverse: make(map[string]string),
@@ -29,6 +47,13 @@ func NewTokenizer() *Tokenizer {
 	}
 }
 
+// Salt returns the salt tokens are derived from
+func (t *Tokenizer) Salt() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.salt
+}
+
 // generateToken creates a deterministic token from input
 func (t *Tokenizer) generateToken(input, prefix string) string {
 	t.mu.Lock()
@@ -39,10 +64,20 @@ func (t *Tokenizer) generateToken(input, prefix string) string {
 		return token
 	}
 
-	// Generate deterministic hash
+	// Generate deterministic hash. Tokens must not depend on the order
+	// values are first seen in, as concurrent workers tokenize in any
+	// order, so they are wide enough for collisions not to happen in
+	// practice; should one happen anyway, more of the digest keeps the
+	// reverse mapping exact
 	hash := sha256.Sum256([]byte(input + t.salt))
-	suffix := hex.EncodeToString(hash[:])[:4] // First 4 hex chars
-	token := prefix + strings.ToUpper(suffix)
+	digest := strings.ToUpper(hex.EncodeToString(hash[:]))
+	token := prefix + digest[:tokenWidth]
+	for n := tokenWidth + 2; ; n += 2 {
+		if _, taken := t.reverse[token]; !taken || n > len(digest) {
+			break
+		}
+		token = prefix + digest[:n]
+	}
 
 	// Store bidirectional mapping
 	t.mapping[input] = token
@@ -139,12 +174,23 @@ func (t *Tokenizer) Detokenize(text string) string {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
 
-	result := text
-
-	// Replace all known tokens
-	for token, real := range t.reverse {
-		result = strings.ReplaceAll(result, token, real)
+	// Replace all known tokens in one pass, longest first, so a token
+	// lengthened after a collision is not mistaken for the shorter one
+	tokens := make([]string, 0, len(t.reverse))
+	for token := range t.reverse {
+		tokens = append(tokens, token)
 	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if len(tokens[i]) != len(tokens[j]) {
+			return len(tokens[i]) > len(tokens[j])
+		}
+		return tokens[i] < tokens[j]
+	})
+	pairs := make([]string, 0, 2*len(tokens))
+	for _, token := range tokens {
+		pairs = append(pairs, token, t.reverse[token])
+	}
+	result := strings.NewReplacer(pairs...).Replace(text)
 
 	// Paranoia mode: strip unknown domains/hostnames that might have leaked
 	result = t.stripUnknownDomains(result)
@@ -152,13 +198,22 @@ func (t *Tokenizer) Detokenize(text string) string {
 	return result
 }
 
+// Resolve returns the real value behind token, e.g. a name the model
+// passed to a tool
+func (t *Tokenizer) Resolve(token string) (string, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	real, ok := t.reverse[strings.TrimSpace(token)]
+	return real, ok
+}
+
+// domainPattern finds potential domains (simple pattern)
+var domainPattern = regexp.MustCompile(`\b[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.([a-z]{2,})\b`)
+
 // stripUnknownDomains removes potential domain names that aren't in our token list
 func (t *Tokenizer) stripUnknownDomains(text string) string {
-	// Regex to find potential domains (simple pattern)
 	// This is paranoia mode - if AI invents a domain-like string, redact it
-	re := regexp.MustCompile(`\b[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.([a-z]{2,})\b`)
-
-	return re.ReplaceAllStringFunc(text, func(match string) string {
+	return domainPattern.ReplaceAllStringFunc(text, func(match string) string {
 		// If it's not a known token, redact it
 		if _, isToken := t.reverse[match]; !isToken {
 			// Check if it looks like a real domain (not our tokens)
@@ -178,10 +233,3 @@ func (t *Tokenizer) GetMappingCount() int {
 	defer t.mu.RUnlock()
 	return len(t.mapping)
 }
-
-// Helper function to get current timestamp
-func getCurrentTimestamp() int64 {
-	// In production, use time.Now().Unix()
-	// For testing, this can be mocked
-	return 1737234000 // Fixed for determinism in testing
-}
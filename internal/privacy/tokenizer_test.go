@@ -0,0 +1,93 @@
+package privacy
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// names returns n distinct user names
+func names(n int) []string {
+	out := make([]string, n)
+	for i := range out {
+		out[i] = fmt.Sprintf("USER%05d@CORP.LOCAL", i)
+	}
+	return out
+}
+
+// tokenizeConcurrently tokenizes every name from workers goroutines, each
+// in its own random order, and returns the tokens by name
+func tokenizeConcurrently(t *testing.T, tk *Tokenizer, all []string, workers int, seed int64) map[string]string {
+	t.Helper()
+	results := make([]map[string]string, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			order := rand.New(rand.NewSource(seed + int64(w))).Perm(len(all))
+			results[w] = make(map[string]string, len(all))
+			for _, i := range order {
+				results[w][all[i]] = tk.TokenizeUser(all[i])
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for w := 1; w < workers; w++ {
+		for name, token := range results[w] {
+			if results[0][name] != token {
+				t.Fatalf("%s got %s in one goroutine and %s in another", name, results[0][name], token)
+			}
+		}
+	}
+	return results[0]
+}
+
+func TestTokenizeConcurrently(t *testing.T) {
+	all := names(5000)
+	first := tokenizeConcurrently(t, NewTokenizerWithSalt("salt"), all, 16, 1)
+	second := tokenizeConcurrently(t, NewTokenizerWithSalt("salt"), all, 16, 100)
+
+	// Same salt, another order: the same tokens
+	for name, token := range first {
+		if second[name] != token {
+			t.Errorf("%s: %s in one run, %s in another", name, token, second[name])
+		}
+	}
+
+	// Every token maps back to its own name
+	tk := NewTokenizerWithSalt("salt")
+	seen := make(map[string]string, len(all))
+	for _, name := range all {
+		token := tk.TokenizeUser(name)
+		if other, dup := seen[token]; dup {
+			t.Fatalf("%s and %s share token %s", name, other, token)
+		}
+		seen[token] = name
+		if real, ok := tk.Resolve(token); !ok || real != name {
+			t.Errorf("Resolve(%s) = %q, want %q", token, real, name)
+		}
+	}
+}
+
+func TestDetokenizeConcurrently(t *testing.T) {
+	tk := NewTokenizerWithSalt("salt")
+	all := names(200)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, name := range all {
+				token := tk.TokenizeUser(name)
+				if got := tk.Detokenize(token + " has GenericAll"); got != name+" has GenericAll" {
+					t.Errorf("Detokenize = %q", got)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -214,40 +214,214 @@ Before returning JSON, verify:
 ✓ Findings are sorted by risk level (Critical first, then High, Medium, Low)
 ✓ Password age ONLY appears in HumanBlindSpot array
 ✓ All findings are driven by CONTROL EDGES, not password age
-✓ Titles focus on CONTROL, not credentials
+✓ Titles focus on CONTROL, not credentials`
 
+// FindingsFormatPrompt is appended to the system prompt when the backend
+// cannot enforce the findings schema and the model must follow it unaided
+const FindingsFormatPrompt = `═══════════════════════════════════════════════════════════════════════════════
+OUTPUT FORMAT
 ═══════════════════════════════════════════════════════════════════════════════
-JSON FORMATTING RULES (CRITICAL)
+
+Return ONE JSON object and nothing else, no markdown fences, no text around it:
+{"findings": [ ...ZombiePath objects, sorted by risk (Critical first)... ]}
+
+In JSON strings, write every line break as the two characters \n (backslash, n), never as a literal newline. Each line of a VisualPath graph is separated by \n within one string.`
+
+// CorrectionPrompt is sent back to the model when its output failed to parse
+// or validate. Arguments: the error, the offending fragment, and the titles
+// of findings that were already accepted.
+const CorrectionPrompt = `Your previous response could not be fully processed.
+
+ERROR:
+%s
+
+OFFENDING FRAGMENT:
+%s
+
+FINDINGS ALREADY ACCEPTED (do NOT repeat them):
+%s
+
+Return a JSON array containing ONLY corrected versions of the rejected findings and any findings you did not finish.
+Follow the OUTPUT FORMAT exactly. If nothing is missing, return [].
+If your output is constrained to a JSON object, wrap the array as {"findings": [ ... ]}.`
+
+// AgentPrompt is appended to the system prompt in agentic mode. Argument:
+// the number of model calls the model may spend exploring.
+const AgentPrompt = `
+═══════════════════════════════════════════════════════════════════════════════
+AGENTIC MODE: EXPLORE THE GRAPH WITH TOOLS
 ═══════════════════════════════════════════════════════════════════════════════
 
-IMPORTANT: In JSON strings, newlines must be represented as the two-character sequence: backslash followed by lowercase n
+You are NOT given a data dump. You query the full BloodHound graph with tools:
 
-When you want a line break in a JSON string value:
-- Write the backslash character (\) followed immediately by the letter n
-- This creates a newline when the JSON is parsed
+- search_nodes: find starting points by kind, flags or name
+- get_node: properties of one node
+- outbound_edges: what a node controls, and the groups it belongs to
+- inbound_edges: who controls a node, and its members if it is a group
+- group_members: members of a group, optionally through nested groups
+- shortest_path_to_tier0: fewest edges from a node to a Tier-0 object
 
-CORRECT JSON:
-{
-  "VisualPath": "Line 1\nLine 2\nLine 3"
-}
+Work like an attacker with a map:
+1. Start from the candidates you are given, or search for your own
+2. Follow control edges outward and check which ones reach Tier 0
+3. Verify every hop of a path with a tool before you report it
+4. Call several tools at once when the calls are independent
 
-When parsed, this displays as:
-Line 1
-Line 2
-Line 3
+Identifiers are opaque: pass them to tools exactly as they appear in tool
+results. Never invent one.
 
-INCORRECT - Do NOT write literal newlines in JSON:
-{
-  "VisualPath": "Line 1
-Line 2
-Line 3"
-}
+You have %d turns to explore. When you have enough evidence, stop calling
+tools and answer with the JSON array of ZombiePath objects. Every finding
+must rest on edges a tool returned; put the verified hops in VisualPath.`
+
+// AgentUserPrompt opens the agentic conversation. Arguments: user, group,
+// computer and domain counts, the Tier-0 targets and the candidate
+// starting points.
+const AgentUserPrompt = `You are investigating an Active Directory environment through BloodHound tools.
+
+ENVIRONMENT SNAPSHOT:
+- %d Users
+- %d Groups
+- %d Computers
+- %d Domains
+
+TIER-0 TARGETS (reaching any of these means domain compromise):
+%s
+
+CANDIDATE STARTING POINTS (control-relevant identities, sampled):
+%s
+
+Discover FORGOTTEN CONTROL PATHS: explore with the tools, then report your findings as a JSON array of ZombiePath objects, SORTED BY RISK.`
+
+// AgentReportPrompt asks for the findings once exploration has ended.
+// Arguments: the opening prompt and the log of tool calls and results.
+const AgentReportPrompt = `You investigated an Active Directory environment through BloodHound tools.
+
+═══════════════════════════════════════════════════════════════════════════════
+ASSIGNMENT
+═══════════════════════════════════════════════════════════════════════════════
+
+%s
+
+═══════════════════════════════════════════════════════════════════════════════
+INVESTIGATION LOG (tool calls and their results)
+═══════════════════════════════════════════════════════════════════════════════
+
+%s
+
+═══════════════════════════════════════════════════════════════════════════════
+REPORT
+═══════════════════════════════════════════════════════════════════════════════
+
+Exploration is over. Using ONLY the evidence in the log, output your findings as a JSON array of ZombiePath objects, SORTED BY RISK (Critical/High first).
+If your output is constrained to a JSON object, wrap the array as {"findings": [ ... ]}.`
+
+// TriageSystemPrompt frames the first phase of a two-phase run, in which
+// the model only ranks entities
+const TriageSystemPrompt = `You are the AD Necromancer in TRIAGE mode. You rank Active Directory entities by how likely they are to hold FORGOTTEN CONTROL PATHS: abandoned identities with live privileges, forgotten control edges (GenericAll, WriteDacl, WriteOwner, AddMember, ...) on critical objects, stale delegation, weak GPO and certificate template permissions.
+
+You do NOT explain attack chains yet. Every candidate you pick is analysed in depth afterwards, with its full neighbourhood, so pick the entities that deserve it.
+
+Output ONLY valid JSON. No markdown, no commentary.`
+
+// TriagePrompt asks for the most suspicious entities of the overview.
+// Arguments: user, group, computer and domain counts, the number of
+// candidates wanted, and the overview.
+const TriagePrompt = `ENVIRONMENT SNAPSHOT:
+- %d Users
+- %d Groups
+- %d Computers
+- %d Domains
+
+Rank the %d entities most likely to hold forgotten control paths to Tier 0.
+
+═══════════════════════════════════════════════════════════════════════════════
+ENTITY OVERVIEW (one line per entity)
+═══════════════════════════════════════════════════════════════════════════════
+
+id | name | kind | flags | control edges out (sample) | group memberships | hops to Tier 0
+
+%s
+
+═══════════════════════════════════════════════════════════════════════════════
+OUTPUT FORMAT
+═══════════════════════════════════════════════════════════════════════════════
+
+A JSON array, most suspicious first:
+[{"Id": "<id column exactly as in the overview, without the name>", "Suspicion": "Critical|High|Medium|Low", "Reason": "<one sentence>"}]
+
+Prefer entities a forgotten path runs THROUGH (short hops to Tier 0, control edges, dormancy) over Tier-0 objects themselves.
+If your output is constrained to a JSON object, wrap the array as {"candidates": [ ... ]}.`
+
+// TriageFormatPrompt is appended to the triage system prompt when the
+// backend cannot enforce the triage schema
+const TriageFormatPrompt = `OUTPUT FORMAT: return ONE JSON object and nothing else, no markdown fences, no text around it:
+{"candidates": [{"Id": "...", "Suspicion": "Critical|High|Medium|Low", "Reason": "..."}, ...]}`
+
+// TriageCorrectionPrompt is sent back when the triage output failed to
+// parse. Argument: the error.
+const TriageCorrectionPrompt = `Your previous response could not be parsed: %s
+
+Return ONLY the JSON array of candidates, each with Id, Suspicion and Reason, using ids exactly as the overview gives them.
+If your output is constrained to a JSON object, wrap the array as {"candidates": [ ... ]}.`
+
+// DeepDivePrompt asks for the findings around one triage candidate.
+// Arguments: the candidate id, the triage reason, and its dossier.
+const DeepDivePrompt = `You are analysing ONE Active Directory entity in depth: %s
+
+TRIAGE NOTE: %s
+
+═══════════════════════════════════════════════════════════════════════════════
+DOSSIER (JSON)
+═══════════════════════════════════════════════════════════════════════════════
+
+The entity's properties and dormancy, every control edge into and out of it with the neighbour on the other end, its group memberships through nesting, its members if it is a group, and its shortest path to Tier 0.
+
+%s
+
+═══════════════════════════════════════════════════════════════════════════════
+BEGIN RESURRECTION ANALYSIS
+═══════════════════════════════════════════════════════════════════════════════
+
+Report the forgotten control paths that run through this entity, using ONLY the edges in the dossier; put the hops in VisualPath. Set EntityName to the entity above.
+If the dossier shows nothing dangerous, return [].
+Output your findings as a JSON array of ZombiePath objects, SORTED BY RISK (Critical/High first).
+If your output is constrained to a JSON object, wrap the array as {"findings": [ ... ]}.`
+
+// FocusPrompt asks for the findings around the objects an analyst chose.
+// Arguments: the focus targets, the hop radius, and the subgraph.
+const FocusPrompt = `You are answering a FOCUSED question about an Active Directory environment. The analyst wants to know what makes these objects dangerous, or endangered:
+
+%s
+
+═══════════════════════════════════════════════════════════════════════════════
+NEIGHBOURHOOD (JSON)
+═══════════════════════════════════════════════════════════════════════════════
+
+Every object within %d hop(s) of the targets: what they control and the groups they are in (following edges outwards), who controls them (following edges inwards), and the OUs and GPOs whose scope they are in. "hops" is the distance from the nearest target. Edges are source → target: MemberOf joins a member to its group, Contains an OU or domain to an object in it, GPLink a GPO to the OU or domain it applies to; every other relationship is an ACE right.
+
+%s
+
+═══════════════════════════════════════════════════════════════════════════════
+BEGIN RESURRECTION ANALYSIS
+═══════════════════════════════════════════════════════════════════════════════
+
+Report the forgotten control paths that start at, end at or run through the targets, using ONLY the edges above; put the hops in VisualPath and set EntityName to the target concerned. Go deep rather than wide: chain the edges, say who can take over each target and what each target can take over.
+If the neighbourhood shows nothing dangerous, return [].
+Output your findings as a JSON array of ZombiePath objects, SORTED BY RISK (Critical/High first).
+If your output is constrained to a JSON object, wrap the array as {"findings": [ ... ]}.`
+
+// DomainScopePrompt opens every prompt of a per-domain run. Arguments: the
+// domain, the number of domains in the collection, its trusts, and the
+// edges crossing its boundary.
+const DomainScopePrompt = `DOMAIN SCOPE: this analysis covers ONLY the domain %s, one of %d domains in the collection. Counts and objects below are for this domain; the other domains are analysed separately.
+
+TRUSTS OF THIS DOMAIN:
+%s
 
-This breaks JSON parsing!
+BOUNDARY EDGES (source → target, with each end's kind and domain; control and memberships crossing into or out of this domain):
+%s
 
-For the VisualPath field specifically:
-- Each line of the ASCII graph should be separated by \n (backslash-n)
-- Do NOT put actual line breaks in the JSON string
-- The entire VisualPath value must be ONE continuous string with \n separators
+Treat boundary edges as real: a path that enters this domain through one, or leaves it through one, is a cross-trust path. Name the other domain in such findings.
 
-Output ONLY the JSON array. No markdown, no explanations outside JSON.`
+`
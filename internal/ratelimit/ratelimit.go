@@ -0,0 +1,149 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limits caps the calls to one provider; zero fields are unlimited
+type Limits struct {
+	RequestsPerMinute int `yaml:"rpm"`
+	TokensPerMinute   int `yaml:"tpm"` // Prompt plus completion tokens
+}
+
+// IsZero reports whether no limit is set
+func (l Limits) IsZero() bool {
+	return l.RequestsPerMinute <= 0 && l.TokensPerMinute <= 0
+}
+
+func (l Limits) String() string {
+	rpm, tpm := "unlimited", "unlimited"
+	if l.RequestsPerMinute > 0 {
+		rpm = fmt.Sprint(l.RequestsPerMinute)
+	}
+	if l.TokensPerMinute > 0 {
+		tpm = fmt.Sprint(l.TokensPerMinute)
+	}
+	return fmt.Sprintf("%s requests/min, %s tokens/min", rpm, tpm)
+}
+
+// Limiter spaces out calls to one provider with a token bucket per limit.
+// Each bucket holds a minute's worth and refills continuously, so bursts up
+// to the limit go through at once. It is safe for concurrent use.
+type Limiter struct {
+	mu       sync.Mutex
+	requests *bucket // nil when unlimited
+	tokens   *bucket
+	now      func() time.Time
+}
+
+// New returns a limiter enforcing l
+func New(l Limits) *Limiter {
+	lim := &Limiter{now: time.Now}
+	start := lim.now()
+	if l.RequestsPerMinute > 0 {
+		lim.requests = newBucket(l.RequestsPerMinute, start)
+	}
+	if l.TokensPerMinute > 0 {
+		lim.tokens = newBucket(l.TokensPerMinute, start)
+	}
+	return lim
+}
+
+// Wait blocks until a call estimated to use tokens fits both buckets, then
+// takes it from them, and returns how long it waited. A call larger than
+// the token limit waits for a full bucket rather than forever. It returns
+// ctx's error if ctx ends first.
+func (l *Limiter) Wait(ctx context.Context, tokens int) (time.Duration, error) {
+	if l == nil {
+		return 0, nil
+	}
+	var waited time.Duration
+	for {
+		delay := l.take(float64(tokens))
+		if delay <= 0 {
+			return waited, nil
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return waited, ctx.Err()
+		case <-timer.C:
+			waited += delay
+		}
+	}
+}
+
+// Settle corrects the tokens taken by Wait for a call estimated at
+// estimated tokens once it is known to have used actual. An overrun is
+// owed by the next calls.
+func (l *Limiter) Settle(estimated, actual int) {
+	if l == nil || l.tokens == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens.refill(l.now())
+	l.tokens.level = math.Min(l.tokens.level+float64(estimated-actual), l.tokens.capacity)
+}
+
+// take takes one request and tokens from the buckets if both can afford
+// them, and otherwise returns how long until they might
+func (l *Limiter) take(tokens float64) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	var delay time.Duration
+	if l.requests != nil {
+		delay = max(delay, l.requests.wait(1, now))
+	}
+	if l.tokens != nil {
+		delay = max(delay, l.tokens.wait(math.Min(tokens, l.tokens.capacity), now))
+	}
+	if delay > 0 {
+		return delay
+	}
+
+	if l.requests != nil {
+		l.requests.level--
+	}
+	if l.tokens != nil {
+		l.tokens.level -= math.Min(tokens, l.tokens.capacity)
+	}
+	return 0
+}
+
+// bucket is a token bucket holding up to a minute's worth
+type bucket struct {
+	capacity float64
+	level    float64 // Negative while overrun by a call that used more than estimated
+	rate     float64 // Refill per second
+	last     time.Time
+}
+
+func newBucket(perMinute int, now time.Time) *bucket {
+	capacity := float64(perMinute)
+	return &bucket{capacity: capacity, level: capacity, rate: capacity / 60, last: now}
+}
+
+// refill adds what accrued since the last refill
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.level = math.Min(b.capacity, b.level+elapsed*b.rate)
+	}
+	b.last = now
+}
+
+// wait returns how long until the bucket holds n
+func (b *bucket) wait(n float64, now time.Time) time.Duration {
+	b.refill(now)
+	if b.level >= n {
+		return 0
+	}
+	return time.Duration((n - b.level) / b.rate * float64(time.Second))
+}
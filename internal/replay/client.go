@@ -0,0 +1,165 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"ad-necromancer/internal/ai"
+	"ad-necromancer/internal/privacy"
+	"ad-necromancer/internal/transcript"
+)
+
+// Client serves the responses of a recorded run instead of calling a model,
+// for deterministic regression tests without network access
+type Client struct {
+	Path       string // Transcript the responses come from
+	Transcript *transcript.Transcript
+	Salt       string // Tokenizer salt of a cloaked recording
+
+	// Lenient serves the next unused exchange in recording order when a
+	// request matches none, instead of failing
+	Lenient bool
+
+	mu        sync.Mutex
+	exchanges []transcript.Exchange // Exchanges this client may serve, in order
+	used      []bool
+}
+
+// NewClient loads the transcript named by REPLAY_TRANSCRIPT (a run
+// directory or its transcript.jsonl). A non-empty backend limits replay to
+// the exchanges recorded for that backend name, e.g. "claude" in an ensemble.
+func NewClient(backend string) (*Client, error) {
+	path := os.Getenv("REPLAY_TRANSCRIPT")
+	if path == "" {
+		return nil, fmt.Errorf("REPLAY_TRANSCRIPT environment variable not set")
+	}
+
+	t, err := transcript.Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{Path: path, Transcript: t}
+	if v := os.Getenv("REPLAY_LENIENT"); v != "" {
+		if c.Lenient, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("invalid REPLAY_LENIENT: %w", err)
+		}
+	}
+	if t.Cloaked {
+		if c.Salt, err = privacy.LoadSalt(t.RunID); err != nil {
+			return nil, fmt.Errorf("transcript %s was recorded with the Privacy Cloak, and its tokens cannot be reproduced: %w", path, err)
+		}
+	}
+	for _, ex := range t.Exchanges {
+		if backend == "" || ex.Backend == backend {
+			c.exchanges = append(c.exchanges, ex)
+		}
+	}
+	if len(c.exchanges) == 0 {
+		if backend != "" {
+			return nil, fmt.Errorf("transcript %s has no exchanges for backend %q", path, backend)
+		}
+		return nil, fmt.Errorf("transcript %s has no exchanges", path)
+	}
+	c.used = make([]bool, len(c.exchanges))
+	return c, nil
+}
+
+func init() {
+	ai.Register(ai.Backend{
+		Name:        "replay",
+		Description: "Replay of a recorded run (no network)",
+		Config: []ai.ConfigKey{
+			{Env: "REPLAY_TRANSCRIPT", Description: "Run directory or transcript.jsonl recorded with --record", Required: true},
+			{Env: "REPLAY_LENIENT", Description: "Serve recorded responses in order when a request was not recorded, instead of failing"},
+		},
+		Capabilities: ai.Capabilities{AutoLocal: true, Tools: true},
+		New: func(cfg ai.Config) (ai.AIClient, error) {
+			// --model selects the recorded backend to replay
+			return NewClient(cfg.Model)
+		},
+	})
+}
+
+// IsLocal reports whether the recording was made without the Privacy
+// Cloak. A cloaked recording is replayed as remote, so the cloak is enabled
+// again and the prompts carry the same tokens as when it was recorded.
+func (c *Client) IsLocal() bool {
+	return !c.Transcript.Cloaked
+}
+
+// Summon implements the AIClient interface
+func (c *Client) Summon(systemPrompt, userPrompt string) (string, error) {
+	return ai.Summon(c, systemPrompt, userPrompt)
+}
+
+// Chat implements the AIClient interface. It serves the first unused
+// exchange recorded for the same conversation. A conversation that was not
+// recorded (the prompt or data changed) is an error, unless Lenient, in
+// which case the next unused exchange in recording order is served.
+// Recorded errors are returned as errors.
+func (c *Client) Chat(ctx context.Context, req *ai.ChatRequest) (*ai.ChatResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	hash := transcript.RequestHash(req.Messages)
+	next := -1
+	for i, ex := range c.exchanges {
+		if c.used[i] {
+			continue
+		}
+		if ex.RequestHash == hash {
+			next = i
+			break
+		}
+		if next < 0 {
+			next = i
+		}
+	}
+	if next < 0 {
+		return nil, fmt.Errorf("replay: transcript %s exhausted after %d exchange(s)", c.Path, len(c.exchanges))
+	}
+
+	ex := c.exchanges[next]
+	if ex.RequestHash != hash {
+		if !c.Lenient {
+			return nil, fmt.Errorf("replay: request %.12s was not recorded in %s; the prompt or data changed (set REPLAY_LENIENT=true to serve recorded responses in order)", hash, c.Path)
+		}
+		fmt.Fprintf(os.Stderr, "[!] Replay: request does not match the recording; serving exchange %d in order\n", ex.Seq)
+	}
+	c.used[next] = true
+
+	if ex.Error != "" {
+		return nil, errors.New(ex.Error)
+	}
+	return &ai.ChatResponse{
+		Text:         ex.Response,
+		FinishReason: ex.FinishReason,
+		Model:        ex.Model,
+		Usage:        ex.Usage,
+		ToolCalls:    ex.ToolCalls,
+	}, nil
+}
+
+// SupportsTools implements ai.ToolUser, so a recorded tool-use loop can be
+// replayed
+func (c *Client) SupportsTools() bool {
+	return true
+}
+
+// Probe implements ai.Prober without consuming a recorded exchange
+func (c *Client) Probe(ctx context.Context) (*ai.ProbeResult, error) {
+	notes := []string{fmt.Sprintf("%d recorded exchange(s) from run %s", len(c.exchanges), c.Transcript.RunID)}
+	if c.Transcript.Cloaked {
+		notes = append(notes, "recorded with the Privacy Cloak on")
+	}
+	return &ai.ProbeResult{Model: c.exchanges[0].Model, Notes: notes}, nil
+}
@@ -0,0 +1,158 @@
+package replay
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ad-necromancer/internal/ai"
+	"ad-necromancer/internal/privacy"
+	"ad-necromancer/internal/transcript"
+)
+
+// echoClient answers with the last user message, reversed
+type echoClient struct {
+	calls int
+}
+
+func (c *echoClient) Chat(ctx context.Context, req *ai.ChatRequest) (*ai.ChatResponse, error) {
+	c.calls++
+	prompt := req.Messages[len(req.Messages)-1].Content
+	if prompt == "fail" {
+		return nil, fmt.Errorf("upstream failed")
+	}
+	runes := []rune(prompt)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return &ai.ChatResponse{Text: string(runes), FinishReason: ai.FinishStop, Model: "echo-1"}, nil
+}
+
+func (c *echoClient) Summon(systemPrompt, userPrompt string) (string, error) {
+	return ai.Summon(c, systemPrompt, userPrompt)
+}
+
+func request(prompt string) *ai.ChatRequest {
+	return &ai.ChatRequest{Messages: []ai.Message{
+		{Role: ai.RoleSystem, Content: "system"},
+		{Role: ai.RoleUser, Content: prompt},
+	}}
+}
+
+// record runs prompts through a recorder and returns the run directory
+func record(t *testing.T, runID string, cloakSalt string, prompts ...string) string {
+	t.Helper()
+	rec, err := transcript.NewRecorder(runID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.SetCloak(cloakSalt != "", cloakSalt); err != nil {
+		t.Fatal(err)
+	}
+	client := rec.Wrap(&echoClient{}, "echo")
+	for _, p := range prompts {
+		client.Chat(context.Background(), request(p))
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return transcript.Dir(runID)
+}
+
+func TestRecordThenReplay(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("REPLAY_TRANSCRIPT", record(t, "1", "", "alpha", "beta", "fail"))
+	t.Setenv("REPLAY_LENIENT", "")
+
+	c, err := NewClient("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Transcript.CompletedAt == nil || len(c.Transcript.Exchanges) != 3 {
+		t.Fatalf("transcript not loaded in full: %+v", c.Transcript)
+	}
+
+	// Out of recording order: matched by conversation, not position
+	for _, tt := range []struct{ prompt, want string }{{"beta", "ateb"}, {"alpha", "ahpla"}} {
+		resp, err := c.Chat(context.Background(), request(tt.prompt))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.prompt, err)
+		}
+		if resp.Text != tt.want || resp.Model != "echo-1" {
+			t.Errorf("%s: got %q from %q, want %q", tt.prompt, resp.Text, resp.Model, tt.want)
+		}
+	}
+	if _, err := c.Chat(context.Background(), request("fail")); err == nil || !strings.Contains(err.Error(), "upstream failed") {
+		t.Errorf("recorded error not replayed: %v", err)
+	}
+	if _, err := c.Chat(context.Background(), request("alpha")); err == nil {
+		t.Error("served an exchange twice")
+	}
+}
+
+func TestReplayFailsOnChangedPrompt(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("REPLAY_TRANSCRIPT", record(t, "1", "", "alpha", "beta"))
+
+	t.Setenv("REPLAY_LENIENT", "")
+	strict, err := NewClient("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := strict.Chat(context.Background(), request("gamma")); err == nil {
+		t.Fatal("a prompt that was not recorded got a response")
+	}
+	// The failed call consumed nothing
+	if resp, err := strict.Chat(context.Background(), request("alpha")); err != nil || resp.Text != "ahpla" {
+		t.Errorf("alpha after a mismatch: %v, %v", resp, err)
+	}
+
+	t.Setenv("REPLAY_LENIENT", "true")
+	lenient, err := NewClient("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := lenient.Chat(context.Background(), request("gamma"))
+	if err != nil || resp.Text != "ahpla" {
+		t.Errorf("lenient replay: %v, %v; want the first exchange in order", resp, err)
+	}
+}
+
+func TestReplayCloakedRecording(t *testing.T) {
+	t.Chdir(t.TempDir())
+	tokenizer := privacy.NewTokenizerWithSalt("recorded salt")
+	prompt := "Analyse " + tokenizer.TokenizeUser("ALICE@CORP.LOCAL")
+	dir := record(t, "1", "recorded salt", prompt)
+	t.Setenv("REPLAY_TRANSCRIPT", dir)
+	t.Setenv("REPLAY_LENIENT", "")
+
+	c, err := NewClient("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Salt != "recorded salt" {
+		t.Fatalf("salt %q, want the recorded one", c.Salt)
+	}
+	replayed := privacy.NewTokenizerWithSalt(c.Salt)
+	if _, err := c.Chat(context.Background(), request("Analyse "+replayed.TokenizeUser("ALICE@CORP.LOCAL"))); err != nil {
+		t.Fatal(err)
+	}
+
+	// The transcript alone does not hold the salt
+	data, err := transcript.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !data.Cloaked {
+		t.Error("transcript not marked cloaked")
+	}
+	if err := os.Remove(filepath.Join(".necromancer", "mappings", "run_1.salt")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewClient(""); err == nil {
+		t.Error("cloaked recording replayed without its salt")
+	}
+}
@@ -0,0 +1,299 @@
+package transcript
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"ad-necromancer/internal/ai"
+	"ad-necromancer/internal/privacy"
+)
+
+// Version is bumped when the transcript format changes incompatibly
+const Version = 2
+
+// FileName is the transcript's name inside a run directory
+const FileName = "transcript.jsonl"
+
+// Transcript records every model exchange of one run. On disk it is one
+// JSON object per line: a Run record first, then one Exchange record per
+// call, and a Run record again whenever the run's state changes; the last
+// Run record wins. Appending keeps recording linear in the run's length.
+type Transcript struct {
+	Run
+	Exchanges []Exchange
+}
+
+// Run is the state of a recorded run. A cloaked run's tokenizer salt is
+// not part of it: anyone holding the salt could confirm guessed names
+// against the tokens offline, so it stays with the mapping files.
+type Run struct {
+	Version     int        `json:"version"`
+	RunID       string     `json:"run_id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"` // Unset when the run was interrupted
+	Cloaked     bool       `json:"cloaked"`                // Prompts and responses hold tokens, not real names
+}
+
+// record is one line of a transcript file
+type record struct {
+	Run      *Run      `json:"run,omitempty"`
+	Exchange *Exchange `json:"exchange,omitempty"`
+}
+
+// Exchange is one Chat call: what was sent, what came back and how long it took
+type Exchange struct {
+	Seq          int           `json:"seq"`
+	Backend      string        `json:"backend"` // Name the backend took part under, e.g. "ollama/llama3"
+	Model        string        `json:"model,omitempty"`
+	RequestHash  string        `json:"request_hash"`
+	Messages     []ai.Message  `json:"messages"`
+	Options      Options       `json:"options"`
+	Response     string        `json:"response,omitempty"`
+	ToolCalls    []ai.ToolCall `json:"tool_calls,omitempty"`
+	FinishReason string        `json:"finish_reason,omitempty"`
+	Usage        ai.Usage      `json:"usage"`
+	Error        string        `json:"error,omitempty"`
+	StartedAt    time.Time     `json:"started_at"`
+	DurationMs   int64         `json:"duration_ms"`
+}
+
+// Options are the call parameters of an exchange
+type Options struct {
+	Temperature float64  `json:"temperature"`
+	MaxTokens   int      `json:"max_tokens"`
+	Seed        *int     `json:"seed,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+	Schema      string   `json:"schema,omitempty"` // Name of the structured-output schema
+	Tools       []string `json:"tools,omitempty"`  // Names of the tools offered
+}
+
+// RequestHash identifies a conversation independently of call options, so
+// a replay can find the response to the same prompt
+func RequestHash(messages []ai.Message) string {
+	h := sha256.New()
+	for _, m := range messages {
+		fmt.Fprintf(h, "%s\x00%d\x00%s\x00", m.Role, len(m.Content), m.Content)
+		// Tool calls and results; plain turns hash as before
+		for _, call := range m.ToolCalls {
+			fmt.Fprintf(h, "call\x00%s\x00%s\x00%s\x00", call.ID, call.Name, call.Arguments)
+		}
+		if m.ToolCallID != "" {
+			fmt.Fprintf(h, "result\x00%s\x00", m.ToolCallID)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Dir returns the run directory for runID
+func Dir(runID string) string {
+	return filepath.Join(".necromancer", "runs", "run_"+runID)
+}
+
+// Recorder persists the exchanges of a run to its run directory. Each
+// exchange is appended as it completes, so an interrupted run keeps what it
+// recorded. It is safe for concurrent use by ensemble members.
+type Recorder struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	run  Run
+	seq  int
+}
+
+// NewRecorder creates the run directory for runID and an empty transcript
+func NewRecorder(runID string) (*Recorder, error) {
+	dir := Dir(runID)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create run directory: %w", err)
+	}
+	// Run directories hold prompts and, with the cloak off, real data
+	if err := privacy.EnsureIgnored("runs/"); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, FileName)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create transcript: %w", err)
+	}
+	r := &Recorder{
+		path: path,
+		file: file,
+		run:  Run{Version: Version, RunID: runID, CreatedAt: time.Now().UTC()},
+	}
+	run := r.run
+	return r, r.write(record{Run: &run})
+}
+
+// Path returns the transcript file
+func (r *Recorder) Path() string {
+	return r.path
+}
+
+// SetCloak records whether the Privacy Cloak tokenized the prompts. The
+// salt needed to reproduce the tokens is saved next to the run's mapping.
+func (r *Recorder) SetCloak(cloaked bool, salt string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if cloaked && salt != "" {
+		if err := privacy.SaveSalt(r.run.RunID, salt); err != nil {
+			return err
+		}
+	}
+	if r.run.Cloaked == cloaked {
+		return nil
+	}
+	r.run.Cloaked = cloaked
+	run := r.run
+	return r.write(record{Run: &run})
+}
+
+// Close stamps the completion time and closes the transcript
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now().UTC()
+	r.run.CompletedAt = &now
+	run := r.run
+	err := r.write(record{Run: &run})
+	if closeErr := r.file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write transcript: %w", closeErr)
+	}
+	return err
+}
+
+// Wrap returns a client that records every exchange with client under
+// name. Locality must be determined on the unwrapped client.
+func (r *Recorder) Wrap(client ai.AIClient, name string) ai.AIClient {
+	return &recordingClient{AIClient: client, name: name, rec: r}
+}
+
+// add appends an exchange to the transcript
+func (r *Recorder) add(ex Exchange) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.seq++
+	ex.Seq = r.seq
+	if err := r.write(record{Exchange: &ex}); err != nil {
+		// Recording is diagnostic; a full disk must not fail the analysis
+		fmt.Fprintf(os.Stderr, "[!] Failed to record exchange %d: %v\n", ex.Seq, err)
+	}
+}
+
+// write appends rec as one line; callers hold r.mu
+func (r *Recorder) write(rec record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal transcript: %w", err)
+	}
+	if _, err := r.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write transcript: %w", err)
+	}
+	return nil
+}
+
+// recordingClient forwards calls to the wrapped client and records them
+type recordingClient struct {
+	ai.AIClient
+	name string
+	rec  *Recorder
+}
+
+// Summon implements the AIClient interface
+func (c *recordingClient) Summon(systemPrompt, userPrompt string) (string, error) {
+	return ai.Summon(c, systemPrompt, userPrompt)
+}
+
+// Chat implements the AIClient interface
+func (c *recordingClient) Chat(ctx context.Context, req *ai.ChatRequest) (*ai.ChatResponse, error) {
+	ex := Exchange{
+		Backend:     c.name,
+		RequestHash: RequestHash(req.Messages),
+		Messages:    append([]ai.Message(nil), req.Messages...),
+		Options: Options{
+			Temperature: req.Options.TemperatureOrDefault(),
+			MaxTokens:   req.Options.MaxTokensOrDefault(),
+			Seed:        req.Options.Seed,
+			Stop:        req.Options.Stop,
+		},
+		StartedAt: time.Now().UTC(),
+	}
+	if req.Options.Schema != nil {
+		ex.Options.Schema = req.Options.Schema.Name
+	}
+	for _, tool := range req.Tools {
+		ex.Options.Tools = append(ex.Options.Tools, tool.Name)
+	}
+
+	resp, err := c.AIClient.Chat(ctx, req)
+
+	ex.DurationMs = time.Since(ex.StartedAt).Milliseconds()
+	if err != nil {
+		ex.Error = err.Error()
+	} else {
+		ex.Model = resp.Model
+		ex.Response = resp.Text
+		ex.ToolCalls = resp.ToolCalls
+		ex.FinishReason = resp.FinishReason
+		ex.Usage = resp.Usage
+	}
+	c.rec.add(ex)
+
+	return resp, err
+}
+
+// SupportsTools implements ai.ToolUser for the wrapped client
+func (c *recordingClient) SupportsTools() bool {
+	return ai.SupportsTools(c.AIClient)
+}
+
+// Load reads a transcript from a run directory or a transcript file
+func Load(path string) (*Transcript, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, FileName)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transcript: %w", err)
+	}
+	defer f.Close()
+
+	var t Transcript
+	dec := json.NewDecoder(f)
+	for n := 1; ; n++ {
+		var rec record
+		err := dec.Decode(&rec)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// A run killed mid-write leaves a partial last line
+			if n > 1 && errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return nil, fmt.Errorf("failed to parse transcript %s, record %d: %w", path, n, err)
+		}
+		switch {
+		case rec.Run != nil:
+			t.Run = *rec.Run
+		case rec.Exchange != nil:
+			t.Exchanges = append(t.Exchanges, *rec.Exchange)
+		}
+	}
+	if t.Version != Version {
+		return nil, fmt.Errorf("transcript %s has version %d, expected %d", path, t.Version, Version)
+	}
+	return &t, nil
+}
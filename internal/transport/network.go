@@ -0,0 +1,287 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Network holds the egress settings shared by every backend: client
+// networks often force traffic through a TLS-inspecting proxy whose CA is
+// not in the system roots, or require a client certificate
+type Network struct {
+	Proxy      string // http://, https:// or socks5:// URL; "" = HTTPS_PROXY/HTTP_PROXY/NO_PROXY
+	NoProxy    string // Hosts or domains reached directly, comma-separated; loopback always is
+	CAFile     string // PEM bundle trusted in addition to the system roots
+	ClientCert string // PEM certificate for mutual TLS
+	ClientKey  string // PEM key for ClientCert
+	MinTLS     string // "1.2" (default) or "1.3"
+	Verbose    bool   // Log every request with its TLS version and issuer to stderr
+}
+
+var (
+	sharedTransport http.RoundTripper // nil = http.DefaultTransport
+	verbose         bool
+)
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Configure builds the HTTP transport used by every Client created after
+// it returns and describes the effective settings, one line each
+func Configure(n Network) ([]string, error) {
+	base, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("unexpected default HTTP transport %T", http.DefaultTransport)
+	}
+	for _, path := range []*string{&n.CAFile, &n.ClientCert, &n.ClientKey} {
+		*path = expandHome(*path)
+	}
+
+	rt := base.Clone()
+	rt.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	var summary []string
+
+	// Proxy
+	if n.Proxy != "" {
+		proxyURL, err := url.Parse(n.Proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q", n.Proxy)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %q (want http, https or socks5)", proxyURL.Scheme)
+		}
+		bypass := splitHosts(n.NoProxy)
+		rt.Proxy = func(req *http.Request) (*url.URL, error) {
+			if directHost(req.URL.Hostname(), bypass) {
+				return nil, nil
+			}
+			return proxyURL, nil
+		}
+		line := "proxy: " + proxyURL.Redacted() + " (loopback direct"
+		if len(bypass) > 0 {
+			line += ", no_proxy " + strings.Join(bypass, ",")
+		}
+		summary = append(summary, line+")")
+	} else if env := proxyFromEnv(); env != "" {
+		summary = append(summary, "proxy: "+env+" (from environment)")
+	} else {
+		summary = append(summary, "proxy: none")
+	}
+
+	// Trust
+	if n.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		bundle, err := os.ReadFile(n.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		certs, err := parseCerts(bundle)
+		if err != nil {
+			return nil, fmt.Errorf("CA bundle %s: %w", n.CAFile, err)
+		}
+		for _, cert := range certs {
+			pool.AddCert(cert)
+		}
+		rt.TLSClientConfig.RootCAs = pool
+		summary = append(summary, fmt.Sprintf("CA bundle: %s (%d certificate(s) added to the system roots)", n.CAFile, len(certs)))
+	} else {
+		summary = append(summary, "CA bundle: system roots")
+	}
+
+	// Mutual TLS
+	if (n.ClientCert == "") != (n.ClientKey == "") {
+		return nil, fmt.Errorf("client certificate and key must be given together")
+	}
+	if n.ClientCert != "" {
+		pair, err := tls.LoadX509KeyPair(n.ClientCert, n.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		rt.TLSClientConfig.Certificates = []tls.Certificate{pair}
+		leaf, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse client certificate: %w", err)
+		}
+		line := fmt.Sprintf("client certificate: %s (%s, expires %s)", n.ClientCert, leaf.Subject.CommonName, leaf.NotAfter.Format("2006-01-02"))
+		if time.Now().After(leaf.NotAfter) {
+			line += " EXPIRED"
+		}
+		summary = append(summary, line)
+	}
+
+	if n.MinTLS != "" {
+		version, ok := tlsVersions[n.MinTLS]
+		if !ok {
+			return nil, fmt.Errorf("unsupported minimum TLS version %q (want 1.2 or 1.3)", n.MinTLS)
+		}
+		rt.TLSClientConfig.MinVersion = version
+	}
+	summary = append(summary, "minimum TLS: "+tlsName(rt.TLSClientConfig.MinVersion))
+
+	sharedTransport = rt
+	verbose = n.Verbose
+	return summary, nil
+}
+
+// parseCerts decodes every CERTIFICATE block of a PEM bundle
+func parseCerts(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no PEM certificates found")
+	}
+	return certs, nil
+}
+
+// expandHome replaces a leading "~/" with the user's home directory, as
+// config files are not expanded by a shell
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}
+
+// splitHosts parses a NO_PROXY style list
+func splitHosts(spec string) []string {
+	var hosts []string
+	for _, h := range strings.Split(spec, ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// directHost reports whether host bypasses the proxy: loopback addresses,
+// and entries of bypass matching the host or one of its parent domains
+func directHost(host string, bypass []string) bool {
+	host = strings.ToLower(host)
+	if host == "localhost" {
+		return true
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return true
+	}
+	for _, entry := range bypass {
+		if entry == "*" {
+			return true
+		}
+		entry = strings.TrimPrefix(entry, ".")
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyFromEnv returns the proxy Go would take from the environment, with
+// credentials redacted
+func proxyFromEnv() string {
+	for _, key := range []string{"HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy"} {
+		if v := os.Getenv(key); v != "" {
+			if u, err := url.Parse(v); err == nil {
+				return key + "=" + u.Redacted()
+			}
+			return key
+		}
+	}
+	return ""
+}
+
+func tlsName(version uint16) string {
+	for name, v := range tlsVersions {
+		if v == version {
+			return name
+		}
+	}
+	return tls.VersionName(version)
+}
+
+// logResponse prints one request for --verbose, with the negotiated TLS
+// version and the issuer that signed the server certificate; behind a
+// TLS-inspecting proxy the issuer is the proxy's CA
+func (c *Client) logResponse(req *http.Request, resp *http.Response, err error, elapsed time.Duration) {
+	line := fmt.Sprintf("[v] %s: %s %s://%s%s", c.Provider, req.Method, req.URL.Scheme, req.URL.Host, req.URL.Path)
+	switch {
+	case err != nil:
+		// url.Error embeds the full URL, which may carry an API key
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		line += " -> " + err.Error()
+	default:
+		line += fmt.Sprintf(" -> %d in %s", resp.StatusCode, elapsed.Round(time.Millisecond))
+		if state := resp.TLS; state != nil {
+			line += ", TLS " + tlsName(state.Version)
+			if len(state.PeerCertificates) > 0 {
+				line += ", issuer " + state.PeerCertificates[0].Issuer.String()
+			}
+		}
+	}
+	fmt.Fprintln(os.Stderr, line)
+}
+
+// tlsHint adds a remedy to certificate errors, which behind an inspecting
+// proxy almost always mean its CA is missing. Retrying them cannot help,
+// so permanent is true for every certificate error.
+func tlsHint(err error) (hinted error, permanent bool) {
+	var unknown x509.UnknownAuthorityError
+	var invalid x509.CertificateInvalidError
+	var hostname x509.HostnameError
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &unknown):
+		return fmt.Errorf("%w (if a proxy inspects TLS, trust its CA with --ca-file or network.ca_file)", err), true
+	case errors.As(err, &invalid), errors.As(err, &hostname):
+		return fmt.Errorf("%w (check the system clock, the endpoint host name and --ca-file)", err), true
+	case errors.As(err, &opErr) && opErr.Op == "remote error" && clientCertAlert(opErr.Err):
+		return fmt.Errorf("%w (the server wants a client certificate: --client-cert and --client-key)", err), true
+	}
+	return err, false
+}
+
+// clientCertAlert reports whether a TLS alert from the server rejects or
+// asks for the client certificate. crypto/tls does not export the alert
+// type it returns, so the alert is matched by its text.
+func clientCertAlert(alert error) bool {
+	switch alert.Error() {
+	case "tls: bad certificate", "tls: certificate required", "tls: unknown certificate authority":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,363 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults for New
+const (
+	DefaultMaxAttempts = 4
+	DefaultBaseDelay   = 1 * time.Second
+	DefaultMaxDelay    = 60 * time.Second
+
+	maxErrorBody = 2048 // bytes of response body kept in StatusError
+)
+
+// Client sends JSON requests to an AI provider, retrying transient failures
+// with exponential backoff and jitter and honouring Retry-After and
+// provider rate-limit headers
+type Client struct {
+	Provider    string        // Name used in errors and retry notices (e.g. "OpenAI")
+	HTTPClient  *http.Client  // Per-attempt timeout lives here
+	MaxAttempts int           // Total attempts including the first
+	BaseDelay   time.Duration // First backoff step; doubles every attempt
+	MaxDelay    time.Duration // Cap for backoff and for server-requested waits
+	Notify      io.Writer     // Receives retry notices; nil discards them
+}
+
+// notify is given to clients made with New; see SetNotify
+var notify io.Writer = io.Discard
+
+// SetNotify sets where clients made with New from now on report retries,
+// such as the run's progress writer. Until it is called they are discarded.
+func SetNotify(w io.Writer) {
+	notify = w
+}
+
+// Notifier returns the writer set with SetNotify, for clients that retry
+// without this package
+func Notifier() io.Writer {
+	return notify
+}
+
+// New creates a transport for provider with the given per-attempt timeout,
+// using the proxy and TLS settings given to Configure
+func New(provider string, timeout time.Duration) *Client {
+	return &Client{
+		Provider:    provider,
+		HTTPClient:  &http.Client{Timeout: timeout, Transport: sharedTransport},
+		MaxAttempts: DefaultMaxAttempts,
+		BaseDelay:   DefaultBaseDelay,
+		MaxDelay:    DefaultMaxDelay,
+		Notify:      notify,
+	}
+}
+
+// StatusError is returned when the provider answered with a non-2xx status
+type StatusError struct {
+	Provider    string
+	StatusCode  int
+	Body        string // Response body, truncated
+	PayloadSize int    // Size of the request body in bytes
+	Attempts    int
+}
+
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("%s API returned status %d (payload size: %d bytes", e.Provider, e.StatusCode, e.PayloadSize)
+	if e.Attempts > 1 {
+		msg += fmt.Sprintf(", %d attempts", e.Attempts)
+	}
+	msg += ")"
+	if e.Body != "" {
+		msg += ": " + e.Body
+	}
+	return msg
+}
+
+// IsStatus reports whether err is a StatusError with the given status code
+func IsStatus(err error, code int) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == code
+}
+
+// IsBadRequestAbout reports whether err is a 400 whose body mentions any of
+// terms, ignoring case. A 400 alone says little: it is as often a prompt
+// over the context window as a rejected parameter.
+func IsBadRequestAbout(err error, terms ...string) bool {
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadRequest {
+		return false
+	}
+	body := strings.ToLower(statusErr.Body)
+	for _, term := range terms {
+		if strings.Contains(body, strings.ToLower(term)) {
+			return true
+		}
+	}
+	return false
+}
+
+// PostJSON marshals body, POSTs it to endpoint with the given headers and
+// decodes a successful response into out
+func (c *Client) PostJSON(ctx context.Context, endpoint string, headers map[string]string, body, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	respBody, err := c.Do(ctx, http.MethodPost, endpoint, headers, payload)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", c.Provider, err)
+	}
+	return nil
+}
+
+// noRetriesKey marks a context whose requests are sent once
+type noRetriesKey struct{}
+
+// WithoutRetries returns a context under which requests are sent only
+// once, for checks that should report a failure rather than wait it out
+func WithoutRetries(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noRetriesKey{}, true)
+}
+
+// RetriesAllowed reports whether requests under ctx may be retried
+func RetriesAllowed(ctx context.Context) bool {
+	off, _ := ctx.Value(noRetriesKey{}).(bool)
+	return !off
+}
+
+// Do sends the request, retrying connection failures that happened before
+// it was sent and retryable statuses, and returns the body of the first 2xx
+// response
+func (c *Client) Do(ctx context.Context, method, endpoint string, headers map[string]string, payload []byte) ([]byte, error) {
+	maxAttempts := c.MaxAttempts
+	if maxAttempts < 1 || !RetriesAllowed(ctx) {
+		maxAttempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		body, wait, err := c.attempt(ctx, method, endpoint, headers, payload, attempt)
+		if err == nil {
+			return body, nil
+		}
+		if wait < 0 || attempt >= maxAttempts || ctx.Err() != nil {
+			return nil, err
+		}
+
+		if wait == 0 {
+			wait = c.backoff(attempt)
+		}
+		if wait > c.MaxDelay {
+			return nil, fmt.Errorf("%w (provider asked to wait %s, more than the %s limit)", err, wait.Round(time.Second), c.MaxDelay)
+		}
+
+		if c.Notify != nil {
+			fmt.Fprintf(c.Notify, "[~] %s: %v; retrying in %s (attempt %d/%d)\n",
+				c.Provider, summarize(err), wait.Round(10*time.Millisecond), attempt+1, maxAttempts)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// attempt performs one request. wait is the server-requested delay before
+// the next attempt (0 = use backoff, negative = not retryable).
+func (c *Client) attempt(ctx context.Context, method, endpoint string, headers map[string]string, payload []byte, attempt int) ([]byte, time.Duration, error) {
+	// A completion is not idempotent: once a connection is up the request
+	// may have reached the provider, been processed and been billed
+	connected := false
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(httptrace.GotConnInfo) { connected = true },
+	})
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return nil, -1, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	start := time.Now()
+	resp, err := c.HTTPClient.Do(req)
+	if verbose {
+		c.logResponse(req, resp, err, time.Since(start))
+	}
+	if err != nil {
+		// url.Error embeds the full URL, which may carry an API key
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		if ctx.Err() != nil {
+			return nil, -1, ctx.Err()
+		}
+		// A StatusError here comes from a nested transport, e.g. an OAuth2
+		// token endpoint, which has already retried
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) {
+			return nil, -1, err
+		}
+		if hinted, permanent := tlsHint(err); permanent {
+			return nil, -1, fmt.Errorf("failed to call %s API: %w", c.Provider, hinted)
+		}
+		// Only a request that never left (DNS, dial, proxy or TLS handshake
+		// failures, timeouts while connecting) is safe to send again
+		if connected {
+			return nil, -1, fmt.Errorf("failed to call %s API: %w", c.Provider, err)
+		}
+		return nil, 0, fmt.Errorf("failed to call %s API: %w", c.Provider, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, -1, fmt.Errorf("failed to read %s response: %w", c.Provider, err)
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return body, 0, nil
+	}
+
+	statusErr := &StatusError{
+		Provider:    c.Provider,
+		StatusCode:  resp.StatusCode,
+		Body:        truncateBody(body),
+		PayloadSize: len(payload),
+		Attempts:    attempt,
+	}
+	if !retryableStatus(resp.StatusCode) {
+		return nil, -1, statusErr
+	}
+	return nil, retryDelay(resp.Header, body, time.Now()), statusErr
+}
+
+// backoff returns the exponential delay for attempt with full jitter
+func (c *Client) backoff(attempt int) time.Duration {
+	delay := c.BaseDelay << (attempt - 1)
+	if delay <= 0 || delay > c.MaxDelay {
+		delay = c.MaxDelay
+	}
+	// Full jitter keeps parallel workers from retrying in lockstep
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// retryableStatus lists the failure classes that are safe to resend:
+// the provider did not (or could not) process the request
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, // 408
+		http.StatusTooEarly,            // 425
+		http.StatusTooManyRequests,     // 429
+		http.StatusInternalServerError, // 500
+		http.StatusBadGateway,          // 502
+		http.StatusServiceUnavailable,  // 503
+		http.StatusGatewayTimeout,      // 504
+		529:                            // Anthropic "overloaded"
+		return true
+	}
+	return false
+}
+
+var geminiRetryDelay = regexp.MustCompile(`"retryDelay"\s*:\s*"([0-9.]+s)"`)
+
+// retryDelay extracts the wait requested by the provider, checking the
+// standard Retry-After header first and then provider-specific hints
+func retryDelay(header http.Header, body []byte, now time.Time) time.Duration {
+	// Azure/OpenAI millisecond precision variant
+	if ms := header.Get("retry-after-ms"); ms != "" {
+		if n, err := strconv.ParseFloat(ms, 64); err == nil && n >= 0 {
+			return time.Duration(n * float64(time.Millisecond))
+		}
+	}
+
+	if ra := header.Get("Retry-After"); ra != "" {
+		if secs, err := strconv.Atoi(strings.TrimSpace(ra)); err == nil && secs >= 0 {
+			return time.Duration(secs) * time.Second
+		}
+		if at, err := http.ParseTime(ra); err == nil {
+			return positive(at.Sub(now))
+		}
+	}
+
+	// OpenAI / DeepSeek: durations such as "1s", "6m0s", "250ms"
+	for _, key := range []string{"x-ratelimit-reset-requests", "x-ratelimit-reset-tokens"} {
+		if v := header.Get(key); v != "" && exhausted(header, strings.Replace(key, "reset", "remaining", 1)) {
+			if d, err := time.ParseDuration(v); err == nil {
+				return d
+			}
+		}
+	}
+
+	// Anthropic: RFC 3339 timestamps
+	for _, key := range []string{"anthropic-ratelimit-requests-reset", "anthropic-ratelimit-tokens-reset"} {
+		if v := header.Get(key); v != "" && exhausted(header, strings.Replace(key, "reset", "remaining", 1)) {
+			if at, err := time.Parse(time.RFC3339, v); err == nil {
+				return positive(at.Sub(now))
+			}
+		}
+	}
+
+	// Gemini: google.rpc.RetryInfo in the error body
+	if m := geminiRetryDelay.FindSubmatch(body); m != nil {
+		if d, err := time.ParseDuration(string(m[1])); err == nil {
+			return d
+		}
+	}
+
+	return 0
+}
+
+// exhausted reports whether a "remaining" rate-limit header is zero (or absent)
+func exhausted(header http.Header, key string) bool {
+	v := header.Get(key)
+	return v == "" || v == "0"
+}
+
+func positive(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+func truncateBody(body []byte) string {
+	s := strings.TrimSpace(string(body))
+	if len(s) > maxErrorBody {
+		return s[:maxErrorBody] + "..."
+	}
+	return s
+}
+
+// summarize shortens an error for the one-line retry notice
+func summarize(err error) string {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return fmt.Sprintf("status %d", statusErr.StatusCode)
+	}
+	return err.Error()
+}
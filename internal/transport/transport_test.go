@@ -0,0 +1,157 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testClient(timeout time.Duration) *Client {
+	c := New("Test", timeout)
+	c.HTTPClient.Transport = &http.Transport{}
+	c.BaseDelay = time.Millisecond
+	c.MaxDelay = 10 * time.Millisecond
+	return c
+}
+
+func TestDoDoesNotResendAfterConnecting(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler func(w http.ResponseWriter, r *http.Request)
+		timeout time.Duration
+	}{
+		{
+			name: "connection dropped",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+			},
+		},
+		{
+			name: "client timeout",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(200 * time.Millisecond)
+			},
+			timeout: 50 * time.Millisecond,
+		},
+		{
+			name: "truncated body",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Length", "100")
+				w.Write([]byte(`{"partial":`))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				tt.handler(w, r)
+			}))
+			defer srv.Close()
+
+			timeout := tt.timeout
+			if timeout == 0 {
+				timeout = 5 * time.Second
+			}
+			if _, err := testClient(timeout).Do(context.Background(), http.MethodPost, srv.URL, nil, []byte("{}")); err == nil {
+				t.Fatal("Do succeeded")
+			}
+			if n := calls.Load(); n != 1 {
+				t.Errorf("request sent %d times, want 1", n)
+			}
+		})
+	}
+}
+
+func TestDoRetriesRefusedConnections(t *testing.T) {
+	// A port nothing listens on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	var attempts int32
+	c := testClient(time.Second)
+	c.HTTPClient.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			atomic.AddInt32(&attempts, 1)
+			return (&net.Dialer{}).DialContext(ctx, network, address)
+		},
+	}
+	if _, err := c.Do(context.Background(), http.MethodPost, "http://"+addr, nil, []byte("{}")); err == nil {
+		t.Fatal("Do succeeded")
+	}
+	if attempts != DefaultMaxAttempts {
+		t.Errorf("dialled %d times, want %d", attempts, DefaultMaxAttempts)
+	}
+}
+
+func TestDoRetriesRetryableStatus(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	c := testClient(time.Second)
+	var notices bytes.Buffer
+	c.Notify = &notices
+	if _, err := c.Do(context.Background(), http.MethodPost, srv.URL, nil, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("request sent %d times, want 3", n)
+	}
+	if n := bytes.Count(notices.Bytes(), []byte("retrying")); n != 2 {
+		t.Errorf("%d retry notices written to Notify, want 2:\n%s", n, notices.String())
+	}
+}
+
+func TestDoWithoutRetries(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	ctx := WithoutRetries(context.Background())
+	if _, err := testClient(time.Second).Do(ctx, http.MethodPost, srv.URL, nil, []byte("{}")); !IsStatus(err, http.StatusServiceUnavailable) {
+		t.Fatalf("err = %v, want the 503", err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("request sent %d times, want 1", n)
+	}
+}
+
+func TestIsBadRequestAbout(t *testing.T) {
+	terms := []string{"response_format", "json_schema"}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"schema refused", &StatusError{StatusCode: 400, Body: `{"error":{"message":"Invalid parameter: 'response_format' of type 'json_schema' is not supported with this model."}}`}, true},
+		{"context length", &StatusError{StatusCode: 400, Body: `{"error":{"message":"This model's maximum context length is 8192 tokens.","code":"context_length_exceeded"}}`}, false},
+		{"other status", &StatusError{StatusCode: 422, Body: `response_format`}, false},
+		{"not a status", context.Canceled, false},
+	}
+	for _, tt := range tests {
+		if got := IsBadRequestAbout(tt.err, terms...); got != tt.want {
+			t.Errorf("%s: IsBadRequestAbout = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}